@@ -0,0 +1,52 @@
+package main
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Returns True If Any Folder Above An Archive Entry, Relative To The Folder It Is Unpacked Into, Is A Symlink, So
+// Writing The Entry Would Write Through The Link To Wherever It Points
+func entryThroughSymlink(dir string, relPath string) bool {
+	parent := dir
+	for _, part := range strings.Split(filepath.Dir(relPath), string(filepath.Separator)) {
+		if part == "." {
+			continue
+		}
+		parent = filepath.Join(parent, part)
+		if info, err := os.Lstat(parent); err == nil && info.Mode()&fs.ModeSymlink != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns True If A Symlink Entry Of An Archive, At relPath Relative To The Folder It Is Unpacked Into, Is Absolute Or
+// Points Outside Of That Folder
+func symlinkEscapes(relPath string, linkname string) bool {
+	linkname = filepath.FromSlash(linkname)
+	return filepath.IsAbs(linkname) || !filepath.IsLocal(filepath.Join(filepath.Dir(relPath), linkname))
+}
+
+// Return The First Of The Symlinks Unpacked Into dir (Relative To It) That Points Through Another Symlink, Which Could
+// Resolve Outside Of dir Even Though Every Link On Its Own Points Inside.   Links Are Only Checked Once Everything Is
+// Unpacked, Since A Link Can Point Through One That Comes Later In The Archive.
+func symlinkThroughSymlink(dir string, links []string) (string, bool) {
+	for _, relPath := range links {
+		linkname, err := os.Readlink(filepath.Join(dir, relPath))
+		if err != nil {
+			continue
+		}
+		parts := strings.Split(linkname, string(filepath.Separator))
+		parent := filepath.Dir(filepath.Join(dir, relPath))
+		for _, part := range parts[:len(parts)-1] {
+			parent = filepath.Join(parent, part)
+			if info, err := os.Lstat(parent); err == nil && info.Mode()&fs.ModeSymlink != 0 {
+				return relPath, true
+			}
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/gruntwork-io/go-commons/errors"
+)
+
+// Downloads Every Source Once Per Run, However Many Modules Reference It.   The First Module To Ask For A Source
+// Downloads It Into A Folder Shared By The Run, Modules Asking For It At The Same Time Wait For That Download, And
+// Every Module Then Gets A Copy Of It.   The Shared Folder Lives In The Stage Directory, So Copies Stay On The Same
+// Disk, And Is Removed When The Run Is Done.
+type DownloadCoordinator struct {
+	stageDir string
+	cacheDir string
+
+	mutex     sync.Mutex
+	downloads map[string]*sharedDownload
+}
+
+// A Download Of One Source, Shared By Every Module That References It
+type sharedDownload struct {
+	done   chan struct{}
+	dir    string
+	record DownloadRecord
+	err    error
+}
+
+// Start A Coordinator Sharing Downloads Through A Folder In The Given Stage Directory
+func NewDownloadCoordinator(stageDir string) *DownloadCoordinator {
+	return &DownloadCoordinator{stageDir: stageDir, downloads: map[string]*sharedDownload{}}
+}
+
+// Return The Folder The Source Identified By The Key Was Downloaded Into, And The Record Of That Download.  Only
+// The First Call For A Key Runs The Download, Into A Fresh Folder, And Later Calls Get Its Result, Errors Included.
+func (coordinator *DownloadCoordinator) Download(key string, download func(dir string, record *DownloadRecord) error) (string, DownloadRecord, error) {
+	coordinator.mutex.Lock()
+	if shared, ok := coordinator.downloads[key]; ok {
+		coordinator.mutex.Unlock()
+		<-shared.done
+		return shared.dir, shared.record, shared.err
+	}
+
+	shared := &sharedDownload{done: make(chan struct{})}
+	coordinator.downloads[key] = shared
+	if coordinator.cacheDir == "" {
+		if err := os.MkdirAll(coordinator.stageDir, os.ModePerm); err != nil {
+			shared.err = errors.WithStackTrace(err)
+		} else if coordinator.cacheDir, err = os.MkdirTemp(coordinator.stageDir, ".terrastage-downloads-"); err != nil {
+			shared.err = errors.WithStackTrace(err)
+		}
+	}
+	cacheDir := coordinator.cacheDir
+	coordinator.mutex.Unlock()
+
+	defer close(shared.done)
+	if shared.err != nil {
+		return "", shared.record, shared.err
+	}
+
+	shared.dir = filepath.Join(cacheDir, fmt.Sprintf("%x", sha256.Sum256([]byte(key)))[:16])
+	shared.err = download(shared.dir, &shared.record)
+	return shared.dir, shared.record, shared.err
+}
+
+// Remove The Shared Downloads.   Call Once Every Module Is Staged.
+func (coordinator *DownloadCoordinator) Close() error {
+	coordinator.mutex.Lock()
+	defer coordinator.mutex.Unlock()
+
+	if coordinator.cacheDir == "" {
+		return nil
+	}
+	err := os.RemoveAll(coordinator.cacheDir)
+	coordinator.cacheDir = ""
+	coordinator.downloads = map[string]*sharedDownload{}
+	return errors.WithStackTrace(err)
+}
+
+// Copy A Folder, Including Hidden Files Like .git, Keeping File Modes And Symlinks.   Files Are Copied Rather Than
+// Linked Because Every Stage Gets Files Generated Into It.   Files And Folders skip Returns True For (Given Their
+// Slash Separated Path Relative To src) Are Left Out, skip Can Be Nil.
+func copyDirectory(src string, dst string, skip func(relPath string) bool) error {
+	return filepath.WalkDir(src, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		relPath, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		if relPath != "." && skip != nil && skip(filepath.ToSlash(relPath)) {
+			if entry.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		target := filepath.Join(dst, relPath)
+
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		switch {
+		case info.Mode()&fs.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			return os.Symlink(link, target)
+		case info.IsDir():
+			return os.MkdirAll(target, info.Mode().Perm()|0700)
+		default:
+			return copyFile(path, target, info.Mode().Perm())
+		}
+	})
+}
+
+func copyFile(src string, dst string, perm fs.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_EXCL|os.O_WRONLY, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/gruntwork-io/terragrunt/options"
+	"github.com/gruntwork-io/terragrunt/util"
+	"github.com/hashicorp/go-getter"
+)
+
+// How Many Times A Failed Download Is Retried, And How Long To Wait Before The First Retry, Unless Given
+const DefaultDownloadRetries = 3
+const DefaultRetryBackoff = 2 * time.Second
+
+// The Wait Between Attempts Doubles After Every Failure, Up To This
+const maxRetryBackoff = time.Minute
+
+// HTTP Statuses Of An Overloaded Or Failing Server, The Only Failed Responses Worth Asking For Again
+var retryableStatusCodes = map[int]bool{
+	http.StatusTooManyRequests:     true,
+	http.StatusInternalServerError: true,
+	http.StatusBadGateway:          true,
+	http.StatusServiceUnavailable:  true,
+	http.StatusGatewayTimeout:      true,
+}
+
+// Errors Of A Request That Failed With The Given Response Status, Like go-git's HTTP Errors, The AWS SDK's Request
+// Failures And HTTPRequestFailed
+type statusCodeError interface {
+	StatusCode() int
+}
+
+// What git Prints When It Lost Its Connection To The Remote, Case Insensitively.   git Only Reports Why It Failed In
+// Its Output, So Failed git Commands Are The One Kind Of Error Told Apart By Their Message.
+var gitTransportErrorMessages = []string{
+	"could not resolve host",
+	"temporary failure in name resolution",
+	"connection refused",
+	"connection reset",
+	"connection timed out",
+	"operation timed out",
+	"no route to host",
+	"network is unreachable",
+	"early eof",
+	"the remote end hung up unexpectedly",
+	"rpc failed",
+	"returned error: 429",
+	"returned error: 500",
+	"returned error: 502",
+	"returned error: 503",
+	"returned error: 504",
+}
+
+// Returns True If A Failed Download Is Worth Trying Again: The Network Failed, Or The Server Answered With A
+// Retryable Status.   Anything Else (A Missing Ref, Bad Credentials, A Moved Lock) Fails The Same Way Every Time.
+func isRetryableDownloadError(err error) bool {
+	var urlErr *url.Error
+	var unexpectedErr *plumbing.UnexpectedError
+	var statusErr statusCodeError
+	var netErr net.Error
+	var gitErr GitCommandFailed
+	switch {
+	// Every *url.Error Is A net.Error, Whatever It Wraps
+	case errors.As(err, &urlErr):
+		return urlErr.Timeout() || isRetryableDownloadError(urlErr.Err)
+	// go-git's Unexpected Errors Can't Be Unwrapped
+	case errors.As(err, &unexpectedErr):
+		return isRetryableDownloadError(unexpectedErr.Err)
+	case errors.As(err, &statusErr):
+		return retryableStatusCodes[statusErr.StatusCode()]
+	case errors.As(err, &netErr):
+		return true
+	case errors.Is(err, io.ErrUnexpectedEOF), errors.Is(err, syscall.ECONNRESET), errors.Is(err, syscall.ECONNREFUSED), errors.Is(err, syscall.EPIPE):
+		return true
+	case errors.As(err, &gitErr):
+		output := strings.ToLower(gitErr.Output)
+		for _, message := range gitTransportErrorMessages {
+			if strings.Contains(output, message) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// A Getter That Keeps The Error It Failed With, Since go-getter Only Passes The Errors Of Its Getters On As Text
+type errorKeepingGetter struct {
+	getter.Getter
+	err *error
+}
+
+func (g *errorKeepingGetter) Get(dst string, u *url.URL) error {
+	err := g.Getter.Get(dst, u)
+	if err != nil {
+		*g.err = err
+	}
+	return err
+}
+
+func (g *errorKeepingGetter) GetFile(dst string, u *url.URL) error {
+	err := g.Getter.GetFile(dst, u)
+	if err != nil {
+		*g.err = err
+	}
+	return err
+}
+
+// A go-getter Option, After The One Setting Up The Getters, That Keeps The Error A Getter Fails With In The Source
+func keepGetterErrors(terraformSource *Source) getter.ClientOption {
+	return func(client *getter.Client) error {
+		for getterName, getterValue := range client.Getters {
+			client.Getters[getterName] = &errorKeepingGetter{Getter: getterValue, err: &terraformSource.getterErr}
+		}
+		return nil
+	}
+}
+
+// An HTTP Transport For go-getter's HTTP Getter, Which Reports Failed Responses Only As Text.   Responses With A
+// Retryable Status Fail The Request With HTTPRequestFailed Instead.
+type retryableStatusTransport struct {
+	http.RoundTripper
+}
+
+func (transport retryableStatusTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	response, err := transport.RoundTripper.RoundTrip(request)
+	if err == nil && retryableStatusCodes[response.StatusCode] {
+		response.Body.Close()
+		return nil, HTTPRequestFailed{Status: response.StatusCode}
+	}
+	return response, err
+}
+
+// How Long To Wait Before Retrying After The Given (1 Based) Failed Attempt: The Backoff Doubled For Every Earlier
+// Failure, Capped At maxRetryBackoff, Plus Up To A Quarter More At Random So Parallel Runs Don't Retry In Step
+func retryDelay(backoff time.Duration, attempt int) time.Duration {
+	delay := backoff
+	for i := 1; i < attempt && delay < maxRetryBackoff; i++ {
+		delay *= 2
+	}
+	if delay > maxRetryBackoff {
+		delay = maxRetryBackoff
+	}
+	if delay <= 0 {
+		return 0
+	}
+	return delay + time.Duration(rand.Int63n(int64(delay)/4+1))
+}
+
+// Run A Download, Giving Every Attempt Its Own Timeout And Retrying Retryable Failures With Exponential Backoff.
+// The Number Of Attempts And The Errors Of The Ones That Failed Are Reported In The Source's Download Record.
+// When The Download Folder Didn't Exist Before, Whatever A Failed Attempt Left In It Is Removed Before Retrying,
+// So A Partial Clone Isn't Mistaken For A Stage To Update.
+func downloadWithRetries(terraformSource *Source, terragruntOptions *options.TerragruntOptions, stageOptions *StageOptions, download func(ctx context.Context) error) error {
+	downloadDirExisted := util.FileExists(terraformSource.DownloadDir)
+	maxAttempts := stageOptions.DownloadRetries + 1
+
+	for attempt := 1; ; attempt++ {
+		var ctx context.Context
+		var cancel context.CancelFunc
+		if stageOptions.DownloadTimeout > 0 {
+			ctx, cancel = context.WithTimeout(context.Background(), stageOptions.DownloadTimeout)
+		} else {
+			ctx, cancel = context.WithCancel(context.Background())
+		}
+		terraformSource.getterErr = nil
+		err := download(ctx)
+		timedOut := ctx.Err() == context.DeadlineExceeded
+		cancel()
+
+		terraformSource.Record.Attempts = attempt
+		if err == nil {
+			return nil
+		}
+		if timedOut {
+			err = DownloadTimedOut{Timeout: stageOptions.DownloadTimeout, Err: err}
+		}
+
+		message := terraformSource.redactSourceURL(err.Error())
+		terraformSource.Record.FailedAttempts = append(terraformSource.Record.FailedAttempts, message)
+
+		// The Getter's Own Error Says More Than What go-getter Made Of It
+		retryable := isRetryableDownloadError(err)
+		if terraformSource.getterErr != nil {
+			retryable = isRetryableDownloadError(terraformSource.getterErr)
+		}
+		if !timedOut && !retryable {
+			return err
+		}
+		if attempt >= maxAttempts {
+			if attempt == 1 {
+				return err
+			}
+			return DownloadAttemptsExhausted{Attempts: attempt, Err: err}
+		}
+
+		delay := retryDelay(stageOptions.RetryBackoff, attempt)
+		terragruntOptions.Logger.Warnf("Attempt %d of %d to download %s failed, retrying in %s: %s", attempt, maxAttempts, terraformSource.SourceURLWithoutQuery(), delay.Round(time.Millisecond), message)
+
+		if !downloadDirExisted {
+			if err := os.RemoveAll(terraformSource.DownloadDir); err != nil {
+				return err
+			}
+		}
+		time.Sleep(delay)
+	}
+}
+
+// Replace The Full Source URL In A Message With The Source URL Without Its Query String (Which May Hold An SSH Key)
+// And Without Any Password
+func (terraformSource Source) redactSourceURL(message string) string {
+	sourceURL := *terraformSource.CanonicalSourceURL
+	sourceURL.RawQuery = ""
+	return strings.ReplaceAll(message, terraformSource.CanonicalSourceURL.String(), sourceURL.Redacted())
+}
+
+type DownloadTimedOut struct {
+	Timeout time.Duration
+	Err     error
+}
+
+func (err DownloadTimedOut) Error() string {
+	return fmt.Sprintf("download timed out after %s (-download-timeout): %v", err.Timeout, err.Err)
+}
+
+type HTTPRequestFailed struct {
+	Status int
+}
+
+func (err HTTPRequestFailed) Error() string {
+	return fmt.Sprintf("server responded with %d %s", err.Status, http.StatusText(err.Status))
+}
+
+func (err HTTPRequestFailed) StatusCode() int {
+	return err.Status
+}
+
+type DownloadAttemptsExhausted struct {
+	Attempts int
+	Err      error
+}
+
+func (err DownloadAttemptsExhausted) Error() string {
+	return fmt.Sprintf("gave up after %d attempts: %v", err.Attempts, err.Err)
+}
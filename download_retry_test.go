@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"syscall"
+	"testing"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	githttp "github.com/go-git/go-git/v5/plumbing/transport/http"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIsRetryableDownloadError(t *testing.T) {
+	t.Parallel()
+
+	refused := &net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED}
+	testCases := []struct {
+		name      string
+		err       error
+		retryable bool
+	}{
+		{name: "connection refused", err: &url.Error{Op: "Get", URL: "https://example.com", Err: refused}, retryable: true},
+		{name: "unknown host", err: &net.DNSError{Err: "no such host", Name: "example.invalid"}, retryable: true},
+		{name: "unexpected eof", err: fmt.Errorf("reading the package: %w", io.ErrUnexpectedEOF), retryable: true},
+		{name: "retryable status", err: &url.Error{Op: "Get", URL: "https://example.com", Err: HTTPRequestFailed{Status: http.StatusServiceUnavailable}}, retryable: true},
+		{name: "go-git status", err: plumbing.NewUnexpectedError(&githttp.Err{Response: &http.Response{StatusCode: http.StatusBadGateway}}), retryable: true},
+		{name: "go-git missing repository", err: plumbing.NewUnexpectedError(fmt.Errorf("repository not found")), retryable: false},
+		{name: "git lost its connection", err: fmt.Errorf("cloning: %w", GitCommandFailed{Path: "git", ExitStatus: 128, Output: "fatal: unable to access 'https://example.com/': Could not resolve host: example.com"}), retryable: true},
+		{name: "git missing ref", err: GitCommandFailed{Path: "git", ExitStatus: 128, Output: "fatal: couldn't find remote ref v9.9.9"}, retryable: false},
+		{name: "unsupported scheme", err: &url.Error{Op: "Get", URL: "nope://example.com", Err: fmt.Errorf("unsupported protocol scheme \"nope\"")}, retryable: false},
+		{name: "message alone", err: fmt.Errorf("connection refused"), retryable: false},
+	}
+
+	for _, testCase := range testCases {
+		testCase := testCase
+		t.Run(testCase.name, func(t *testing.T) {
+			t.Parallel()
+			assert.Equal(t, testCase.retryable, isRetryableDownloadError(testCase.err))
+		})
+	}
+}
+
+func TestRetryableStatusTransport(t *testing.T) {
+	t.Parallel()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/busy":
+			w.WriteHeader(http.StatusTooManyRequests)
+		case "/missing":
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(server.Close)
+	client := &http.Client{Transport: retryableStatusTransport{RoundTripper: http.DefaultTransport}}
+
+	_, err := client.Get(server.URL + "/busy")
+	var failed HTTPRequestFailed
+	require.ErrorAs(t, err, &failed)
+	assert.Equal(t, http.StatusTooManyRequests, failed.StatusCode())
+	assert.True(t, isRetryableDownloadError(err))
+
+	response, err := client.Get(server.URL + "/missing")
+	require.NoError(t, err)
+	response.Body.Close()
+	assert.Equal(t, http.StatusNotFound, response.StatusCode)
+}
@@ -1,10 +1,13 @@
 package main
 
 import (
+	"context"
 	"fmt"
+	"net/http"
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/hashicorp/go-getter"
 
@@ -12,7 +15,6 @@ import (
 	"github.com/gruntwork-io/terragrunt/cli/commands"
 	"github.com/gruntwork-io/terragrunt/config"
 	"github.com/gruntwork-io/terragrunt/options"
-	"github.com/gruntwork-io/terragrunt/terraform"
 	"github.com/gruntwork-io/terragrunt/util"
 )
 
@@ -53,7 +55,7 @@ func downloadTerraformSource(source string, terragruntOptions *options.Terragrun
 		return nil, err
 	}
 
-	if err := downloadTerraformSourceIfNecessary(terraformSource, terragruntOptions, terragruntConfig); err != nil {
+	if err := downloadTerraformSourceIfNecessary(terraformSource, terragruntOptions, NewStageOptions(), terragruntConfig); err != nil {
 		return nil, err
 	}
 
@@ -79,14 +81,44 @@ func downloadTerraformSource(source string, terragruntOptions *options.Terragrun
 // Custom Function To Download Terraform Source
 // Replica Of Function Above, Only Exception Is It Calls Custom
 // Source Processing Function
-func customDownloadTerraformSource(source string, stageSubDir string, terragruntOptions *options.TerragruntOptions, terragruntConfig *config.TerragruntConfig) (*options.TerragruntOptions, error) {
+func customDownloadTerraformSource(source string, stageSubDir string, terragruntOptions *options.TerragruntOptions, stageOptions *StageOptions, terragruntConfig *config.TerragruntConfig) (*options.TerragruntOptions, error) {
 	//terraformSource, err := terraform.NewSource(source, terragruntOptions.DownloadDir, terragruntOptions.WorkingDir, stageSubDir, terragruntOptions.Logger)
 	terraformSource, err := CustomNewSource(source, terragruntOptions.DownloadDir, terragruntOptions.WorkingDir, stageSubDir, terragruntOptions.Logger)
 	if err != nil {
 		return nil, err
 	}
 
-	if err := downloadTerraformSourceIfNecessary(terraformSource, terragruntOptions, terragruntConfig); err != nil {
+	// Sources Outside Of The Allowlist In The Terrastage Config Are Never Downloaded
+	if err := stageOptions.AllowedSources.check(AllowedSourcesBlock, terraformSource.CanonicalSourceURL); err != nil {
+		return nil, err
+	}
+	terraformSource.Policy, terraformSource.PolicyName = stageOptions.AllowedSources, AllowedSourcesBlock
+
+	// Files Generated Into The Stage Last Time Aren't Local Changes When A Git Stage Is Updated
+	terraformSource.PreviousGenerated = previousGeneratedFiles(terraformSource.DownloadDir)
+
+	// Set Up Content Hashing For Local Sources.  The File Fingerprints From The Previous Stage Let
+	// Us Skip Reading Files Whose Size And Modification Time Haven't Changed.
+	if stageOptions.HashContents && IsLocalSource(terraformSource.CanonicalSourceURL) {
+		terraformSource.HashContents = true
+		terraformSource.HashExclude = stageOptions.HashExclude
+		if terragruntConfig.Terraform != nil && terragruntConfig.Terraform.IncludeInCopy != nil {
+			terraformSource.IncludeInCopy = *terragruntConfig.Terraform.IncludeInCopy
+		}
+		if util.FileExists(terraformSource.MetadataFile) {
+			if previousMetadata, err := readStageMetadata(terraformSource.MetadataFile); err == nil {
+				terraformSource.PreviousFiles = previousMetadata.Files
+			}
+		}
+	}
+
+	// Stage Only The Module Directory As The Root Of The Stage Subdirectory Unless The Full Repo Was Asked For
+	if !stageOptions.FullRepo && terraformSource.ModulePath != "" {
+		terraformSource.ModuleOnly = true
+		terraformSource.WorkingDir = terraformSource.DownloadDir
+	}
+
+	if err := downloadTerraformSourceIfNecessary(terraformSource, terragruntOptions, stageOptions, terragruntConfig); err != nil {
 		return nil, err
 	}
 
@@ -110,7 +142,7 @@ func customDownloadTerraformSource(source string, stageSubDir string, terragrunt
 }
 
 // Download the specified TerraformSource if the latest code hasn't already been downloaded.
-func downloadTerraformSourceIfNecessary(terraformSource *Source, terragruntOptions *options.TerragruntOptions, terragruntConfig *config.TerragruntConfig) error {
+func downloadTerraformSourceIfNecessary(terraformSource *Source, terragruntOptions *options.TerragruntOptions, stageOptions *StageOptions, terragruntConfig *config.TerragruntConfig) error {
 	if terragruntOptions.SourceUpdate {
 		terragruntOptions.Logger.Debugf("The --%s flag is set, so deleting the temporary folder %s before downloading source.", commands.FlagNameTerragruntSourceUpdate, terraformSource.DownloadDir)
 		if err := os.RemoveAll(terraformSource.DownloadDir); err != nil {
@@ -118,7 +150,7 @@ func downloadTerraformSourceIfNecessary(terraformSource *Source, terragruntOptio
 		}
 	}
 
-	alreadyLatest, err := alreadyHaveLatestCode(terraformSource, terragruntOptions)
+	alreadyLatest, err := alreadyHaveLatestCode(terraformSource, terragruntOptions, stageOptions)
 	if err != nil {
 		return err
 	}
@@ -127,6 +159,12 @@ func downloadTerraformSourceIfNecessary(terraformSource *Source, terragruntOptio
 		if err := validateWorkingDir(terraformSource); err != nil {
 			return err
 		}
+		if err := lockCachedSource(terraformSource, stageOptions); err != nil {
+			return err
+		}
+		if err := terraformSource.refreshFileFingerprints(); err != nil {
+			terragruntOptions.Logger.WithError(err).Warningf("Could not record the file fingerprints of source %s", terraformSource.CanonicalSourceURL)
+		}
 		terragruntOptions.Logger.Debugf("%s files in %s are up to date. Will not download again.", terragruntOptions.TerraformImplementation, terraformSource.WorkingDir)
 		return nil
 	}
@@ -134,10 +172,21 @@ func downloadTerraformSourceIfNecessary(terraformSource *Source, terragruntOptio
 	var previousVersion = ""
 	// read previous source version
 	// https://github.com/gruntwork-io/terragrunt/issues/1921
-	if util.FileExists(terraformSource.VersionFile) {
-		previousVersion, err = readVersionFile(terraformSource)
+	if util.FileExists(terraformSource.MetadataFile) {
+		previousMetadata, err := readStageMetadata(terraformSource.MetadataFile)
 		if err != nil {
-			return err
+			// Unreadable Metadata Has No Version To Pass On, So The Source Is Simply Downloaded Again
+			terragruntOptions.Logger.Debugf("Could not read stage metadata %s, so downloading source again without its previous version: %s", terraformSource.MetadataFile, err)
+		} else {
+			previousVersion = previousMetadata.SourceVersion
+
+			// A stage of the full repo can't be updated into a stage of just the module directory, or the other way round
+			if previousMetadata.ModuleOnly != terraformSource.ModuleOnly {
+				terragruntOptions.Logger.Debugf("Stage %s was staged with a different -fullrepo setting, so deleting it before downloading source.", terraformSource.DownloadDir)
+				if err := os.RemoveAll(terraformSource.DownloadDir); err != nil {
+					return errors.WithStackTrace(err)
+				}
+			}
 		}
 	}
 
@@ -148,7 +197,7 @@ func downloadTerraformSourceIfNecessary(terraformSource *Source, terragruntOptio
 	terragruntOptionsForDownload.TerraformCommand = CommandNameInitFromModule
 
 	// Don't Need Hooks
-	downloadErr := downloadSource(terraformSource, terragruntOptions, terragruntConfig)
+	downloadErr := downloadSource(terraformSource, terragruntOptions, stageOptions, terragruntConfig)
 
 	//downloadErr := runActionWithHooks("download source", terragruntOptionsForDownload, terragruntConfig, func() error {
 	//	return downloadSource(terraformSource, terragruntOptions, terragruntConfig)
@@ -158,7 +207,7 @@ func downloadTerraformSourceIfNecessary(terraformSource *Source, terragruntOptio
 		return DownloadingTerraformSourceErr{ErrMsg: downloadErr, Url: terraformSource.CanonicalSourceURL.String()}
 	}
 
-	if err := terraformSource.WriteVersionFile(); err != nil {
+	if err := terraformSource.WriteStageMetadata(); err != nil {
 		return err
 	}
 
@@ -184,10 +233,27 @@ func downloadTerraformSourceIfNecessary(terraformSource *Source, terragruntOptio
 // Returns true if the specified TerraformSource, of the exact same version, has already been downloaded into the
 // DownloadFolder. This helps avoid downloading the same code multiple times. Note that if the TerraformSource points
 // to a local file path, a hash will be generated from the contents of the source dir. See the ProcessTerraformSource method for more info.
-func alreadyHaveLatestCode(terraformSource *Source, terragruntOptions *options.TerragruntOptions) (bool, error) {
+// The decision is made from the stage metadata file in the stage subdirectory, so modules staged into the same stage
+// directory don't affect each other.
+func alreadyHaveLatestCode(terraformSource *Source, terragruntOptions *options.TerragruntOptions, stageOptions *StageOptions) (bool, error) {
+	if stageOptions.NoCache {
+		terragruntOptions.Logger.Debugf("The -no-cache flag is set, so downloading %s again.", terraformSource.CanonicalSourceURL)
+		return false, nil
+	}
+
+	// Locked Git Sources Are Checked Against The Remote On Every Run, So A Moved Ref Is Never Missed.   The Same Goes
+	// For Registry Sources With A Version Constraint, Which Are Checked Against The Locked Version, And OCI Sources
+	// Pulled By Tag, Which Are Checked Against The Locked Digest.
+	_, _, registryConstraint := registryLockKey(terraformSource.CanonicalSourceURL)
+	_, _, ociTag := ociLockKey(terraformSource.CanonicalSourceURL)
+	if stageOptions.Locked && stageOptions.Lock != nil && (isGitSource(terraformSource.CanonicalSourceURL) || registryConstraint || ociTag) {
+		terragruntOptions.Logger.Debugf("The -locked flag is set, so checking %s against the lock file again.", terraformSource.CanonicalSourceURL)
+		return false, nil
+	}
+
 	if !util.FileExists(terraformSource.DownloadDir) ||
 		!util.FileExists(terraformSource.WorkingDir) ||
-		!util.FileExists(terraformSource.VersionFile) {
+		!util.FileExists(terraformSource.MetadataFile) {
 		return false, nil
 	}
 
@@ -211,30 +277,96 @@ func alreadyHaveLatestCode(terraformSource *Source, terragruntOptions *options.T
 		}
 	}
 
-	previousVersion, err := readVersionFile(terraformSource)
+	previousMetadata, err := readStageMetadata(terraformSource.MetadataFile)
+	if err != nil {
+		// Unreadable Metadata Just Means We Can't Trust What Is In The Stage, So Download Again
+		terragruntOptions.Logger.Debugf("Could not read stage metadata %s, so assuming code needs to be downloaded again: %s", terraformSource.MetadataFile, err)
+		return false, nil
+	}
+
+	// A Different Source Staged Into The Same Subdirectory, Or A Different Terrastage Version, Can't Be Reused
+	if previousMetadata.SourceURL != terraformSource.SourceURLWithoutQuery() {
+		terragruntOptions.Logger.Debugf("Stage %s was downloaded from %s, not %s, so downloading again.", terraformSource.DownloadDir, previousMetadata.SourceURL, terraformSource.SourceURLWithoutQuery())
+		return false, nil
+	}
+	if previousMetadata.ModuleOnly != terraformSource.ModuleOnly {
+		terragruntOptions.Logger.Debugf("Stage %s was staged with a different -fullrepo setting, so downloading again.", terraformSource.DownloadDir)
+		return false, nil
+	}
+	if previousMetadata.TerrastageVersion != VERSION {
+		terragruntOptions.Logger.Debugf("Stage %s was staged by terrastage %s, not %s, so downloading again.", terraformSource.DownloadDir, previousMetadata.TerrastageVersion, VERSION)
+		return false, nil
+	}
+
+	if previousMetadata.SourceVersion != currentVersion {
+		return false, nil
+	}
 
+	// An Archive Pinned To A Checksum Is Only Reused If It Was Verified Against That Checksum, Which Can Be Changed In
+	// The Terrastage Config Without Changing The Source
+	checksum, err := sourceChecksum(terraformSource.CanonicalSourceURL, stageOptions.Checksums)
 	if err != nil {
 		return false, err
 	}
+	if checksum != "" && !strings.EqualFold(previousMetadata.VerifiedChecksum, checksum) {
+		terragruntOptions.Logger.Debugf("Stage %s wasn't verified against checksum %s, so downloading again.", terraformSource.DownloadDir, checksum)
+		return false, nil
+	}
+
+	// A Git Source (Or A Registry Module Downloaded From Git) Is Only Reused If Its Signature Was Verified The Way This
+	// Run Asks For, Since -git-verify-signatures Can Be Turned On Without Changing The Source
+	fromGit := isGitSource(terraformSource.CanonicalSourceURL) || previousMetadata.ResolvedCommit != ""
+	if mode := stageOptions.GitSignatures.Mode; mode != "" && fromGit && previousMetadata.VerifiedSignature != mode {
+		terragruntOptions.Logger.Debugf("Stage %s wasn't verified to be a signed %s, so downloading again.", terraformSource.DownloadDir, mode)
+		return false, nil
+	}
+
+	// Refs That Aren't Pinned (Branches) Can Move, So Refresh Them Once The Stage Is Older Than The Cache TTL
+	if stageOptions.CacheTTL > 0 && !IsPinnedSource(terraformSource.CanonicalSourceURL) {
+		if age := time.Since(previousMetadata.DownloadedAt); age > stageOptions.CacheTTL {
+			terragruntOptions.Logger.Debugf("Source %s is not pinned and was downloaded %s ago, which is older than the cache TTL of %s, so downloading again.", terraformSource.CanonicalSourceURL, age.Round(time.Second), stageOptions.CacheTTL)
+			return false, nil
+		}
+	}
 
-	return previousVersion == currentVersion, nil
+	return true, nil
 }
 
-// Return the version number stored in the DownloadDir. This version number can be used to check if the Terraform code
-// that has already been downloaded is the same as the version the user is currently requesting. The version number is
-// calculated using the encodeSourceVersion method.
-func readVersionFile(terraformSource *Source) (string, error) {
-	return util.ReadFileAsString(terraformSource.VersionFile)
+// Record The Commit Of A Git Source, The Version A Registry Source's Constraint Resolved To, Or The Digest An OCI
+// Source's Tag Pointed At, That Was Already Staged In The Lock File, So The Lock File Covers Every Source Even When
+// Nothing Had To Be Downloaded
+func lockCachedSource(terraformSource *Source, stageOptions *StageOptions) error {
+	lockURL, constraint, registryConstraint := registryLockKey(terraformSource.CanonicalSourceURL)
+	ociLockURL, tag, ociTag := ociLockKey(terraformSource.CanonicalSourceURL)
+	if stageOptions.Lock == nil || stageOptions.Locked || (!isGitSource(terraformSource.CanonicalSourceURL) && !registryConstraint && !ociTag) {
+		return nil
+	}
+
+	metadata, err := readStageMetadata(terraformSource.MetadataFile)
+	if err != nil {
+		return err
+	}
+	if registryConstraint && metadata.ResolvedVersion != "" {
+		stageOptions.Lock.Record(lockURL, constraint, metadata.ResolvedVersion)
+	} else if ociTag && metadata.ResolvedDigest != "" {
+		stageOptions.Lock.Record(ociLockURL, tag, metadata.ResolvedDigest)
+	} else if isGitSource(terraformSource.CanonicalSourceURL) && metadata.ResolvedCommit != "" {
+		stageOptions.Lock.Record(terraformSource.CanonicalSourceURL, terraformSource.Ref(), metadata.ResolvedCommit)
+	}
+	return nil
 }
 
 // updateGetters returns the customized go-getter interfaces that Terragrunt relies on. Specifically:
 //   - Local file path getter is updated to copy the files instead of creating symlinks, which is what go-getter defaults
 //     to.
-//   - Include the customized getter for fetching sources from the Terraform Registry.
+//   - Include the customized getter for fetching sources from the Terraform Registry, which authenticates to private
+//     registries like terraform does and can be pointed at a mirror.
+//   - Git getter is replaced with one that only fetches submodules when asked to, can use the shared git mirror cache
+//     and can materialise just the module subdirectory of the source.
 //
 // This creates a closure that returns a function so that we have access to the terragrunt configuration, which is
 // necessary for customizing the behavior of the file getter.
-func updateGetters(terragruntOptions *options.TerragruntOptions, terragruntConfig *config.TerragruntConfig) func(*getter.Client) error {
+func updateGetters(terragruntOptions *options.TerragruntOptions, stageOptions *StageOptions, terragruntConfig *config.TerragruntConfig, terraformSource *Source) func(*getter.Client) error {
 	return func(client *getter.Client) error {
 		// We copy all the default getters from the go-getter library, but replace the "file" getter. We shallow clone the
 		// getter map here rather than using getter.Getters directly because (a) we shouldn't change the original,
@@ -248,30 +380,263 @@ func updateGetters(terragruntOptions *options.TerragruntOptions, terragruntConfi
 					includeInCopy = *terragruntConfig.Terraform.IncludeInCopy
 				}
 				client.Getters[getterName] = &FileCopyGetter{IncludeInCopy: includeInCopy}
+			} else if getterName == "git" && stageOptions.GitBackend == GitBackendGoGit {
+				client.Getters[getterName] = &GoGitGetter{
+					Record:     terraformSource.Record,
+					Lock:       stageOptions.Lock,
+					Locked:     stageOptions.Locked,
+					Submodules: stageOptions.Submodules,
+					Generated:  terraformSource.PreviousGenerated,
+					Auth:       stageOptions.GitAuth,
+					Signatures: stageOptions.GitSignatures,
+				}
 			} else if getterName == "git" {
-				client.Getters[getterName] = &GitGetter{}
+				client.Getters[getterName] = &GitGetter{
+					MirrorDir:   stageOptions.GitCacheDir,
+					Record:      terraformSource.Record,
+					Sparse:      stageOptions.Sparse,
+					Subdir:      terraformSource.ModulePath,
+					Lock:        stageOptions.Lock,
+					Locked:      stageOptions.Locked,
+					Submodules:  stageOptions.Submodules,
+					Generated:   terraformSource.PreviousGenerated,
+					Auth:        stageOptions.GitAuth,
+					Signatures:  stageOptions.GitSignatures,
+					SharedClone: terraformSource.SharedClone,
+				}
+			} else if getterName == "http" || getterName == "https" {
+				// go-getter only reports failed responses as text, so retryable ones are failed with their status
+				client.Getters[getterName] = &getter.HttpGetter{
+					Netrc:  true,
+					Client: &http.Client{Transport: retryableStatusTransport{RoundTripper: http.DefaultTransport.(*http.Transport).Clone()}},
+				}
 			} else {
 				client.Getters[getterName] = getterValue
 			}
 		}
 
-		// Load in custom getters that are only supported in Terragrunt
-		client.Getters["tfr"] = &terraform.RegistryGetter{
-			TerragruntOptions: terragruntOptions,
+		// Load in the getter for the Terraform Registry, which downloads the module package the registry points at
+		// with the other getters
+		packageGetters := map[string]getter.Getter{}
+		for getterName, getterValue := range client.Getters {
+			packageGetters[getterName] = getterValue
+		}
+		client.Getters["tfr"] = &RegistryGetter{
+			HostOverrides: stageOptions.RegistryHosts,
+			Getters:       packageGetters,
+			Record:        terraformSource.Record,
+			Lock:          stageOptions.Lock,
+			Locked:        stageOptions.Locked,
+			Policy:        terraformSource.Policy,
+			PolicyName:    terraformSource.PolicyName,
+		}
+
+		// Load in the getter for module artifacts in OCI registries
+		client.Getters["oci"] = &OCIGetter{
+			Record:     terraformSource.Record,
+			Lock:       stageOptions.Lock,
+			Locked:     stageOptions.Locked,
+			Policy:     terraformSource.Policy,
+			PolicyName: terraformSource.PolicyName,
 		}
 
 		return nil
 	}
 }
 
-// Download the code from the Canonical Source URL into the Download Folder using the go-getter library
-func downloadSource(terraformSource *Source, terragruntOptions *options.TerragruntOptions, terragruntConfig *config.TerragruntConfig) error {
+// Download the code from the Canonical Source URL into the Download Folder using the go-getter library. When the
+// run shares downloads between modules, the stage gets a copy of the run's download of the source instead, unless
+// it is a git clone from an earlier run: clones are updated in place from the run's clone of the source, which
+// refuses to overwrite local changes.
+func downloadSource(terraformSource *Source, terragruntOptions *options.TerragruntOptions, stageOptions *StageOptions, terragruntConfig *config.TerragruntConfig) error {
+	if terraformSource.ModuleOnly {
+		return downloadModuleOnly(terraformSource, terragruntOptions, stageOptions, terragruntConfig)
+	}
+
+	var err error
+	switch {
+	case stageOptions.Downloads == nil || IsLocalSource(terraformSource.CanonicalSourceURL):
+		err = fetchSource(terraformSource, terragruntOptions, stageOptions, terragruntConfig)
+	case !util.IsDir(filepath.Join(terraformSource.DownloadDir, ".git")):
+		err = copySharedSource(terraformSource, terragruntOptions, stageOptions, terragruntConfig)
+	case sharesGitClone(terraformSource, stageOptions):
+		err = updateFromSharedClone(terraformSource, terragruntOptions, stageOptions, terragruntConfig)
+	default:
+		err = fetchSource(terraformSource, terragruntOptions, stageOptions, terragruntConfig)
+	}
+	if err != nil {
+		return err
+	}
+
+	if terraformSource.Record.AuthSource != "" {
+		terragruntOptions.Logger.Debugf("Authenticated to %s with credentials from %s", terraformSource.CanonicalSourceURL.Host, terraformSource.Record.AuthSource)
+	}
+
+	for _, reference := range terraformSource.Record.UnresolvedReferences {
+		terragruntOptions.Logger.Warnf("Sparse checkout could not include %s", reference)
+	}
+
+	return nil
+}
+
+// Download the whole source into a temporary folder next to the Download Folder, then replace the Download Folder's
+// contents with just the module directory, keeping terraform's working files and terrastage's bookkeeping. A stage
+// of only the module directory isn't a checkout, so it is downloaded again every time it is updated.
+func downloadModuleOnly(terraformSource *Source, terragruntOptions *options.TerragruntOptions, stageOptions *StageOptions, terragruntConfig *config.TerragruntConfig) error {
+	if err := os.MkdirAll(filepath.Dir(terraformSource.DownloadDir), os.ModePerm); err != nil {
+		return errors.WithStackTrace(err)
+	}
+	tmpDir, err := os.MkdirTemp(filepath.Dir(terraformSource.DownloadDir), ".terrastage-module-")
+	if err != nil {
+		return errors.WithStackTrace(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	fullSource := *terraformSource
+	fullSource.DownloadDir = filepath.Join(tmpDir, "source")
+	fullSource.WorkingDir = filepath.Join(fullSource.DownloadDir, filepath.FromSlash(terraformSource.ModulePath))
+	fullSource.ModuleOnly = false
+	fullSource.PreviousGenerated = nil
+	if err := downloadSource(&fullSource, terragruntOptions, stageOptions, terragruntConfig); err != nil {
+		return err
+	}
+	if !util.IsDir(fullSource.WorkingDir) {
+		return errors.WithStackTrace(WorkingDirNotFound{Dir: terraformSource.ModulePath, Source: terraformSource.CanonicalSourceURL.String()})
+	}
+
+	if err := clearStage(terraformSource.DownloadDir); err != nil {
+		return err
+	}
+
+	terragruntOptions.Logger.Debugf("Staging only %s of the source into %s", terraformSource.ModulePath, terraformSource.DownloadDir)
+	err = copyDirectory(fullSource.WorkingDir, terraformSource.DownloadDir, func(relPath string) bool {
+		return keptInStage(relPath, nil) && util.FileExists(filepath.Join(terraformSource.DownloadDir, filepath.FromSlash(relPath)))
+	})
+	return errors.WithStackTrace(err)
+}
+
+// Delete everything at the top of a stage except terraform's working files and terrastage's bookkeeping
+func clearStage(downloadDir string) error {
+	entries, err := os.ReadDir(downloadDir)
+	if err != nil && !os.IsNotExist(err) {
+		return errors.WithStackTrace(err)
+	}
+	for _, entry := range entries {
+		if keptInStage(entry.Name(), nil) {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(downloadDir, entry.Name())); err != nil {
+			return errors.WithStackTrace(err)
+		}
+	}
+	return nil
+}
+
+// Fetch the source into the Download Folder with the getters, retrying failures. Offline, remote sources are taken
+// from the source bundle instead.
+func fetchSource(terraformSource *Source, terragruntOptions *options.TerragruntOptions, stageOptions *StageOptions, terragruntConfig *config.TerragruntConfig) error {
+	if stageOptions.Bundle != nil && !IsLocalSource(terraformSource.CanonicalSourceURL) {
+		return stageOptions.Bundle.Fetch(terraformSource, terragruntOptions, stageOptions)
+	}
+
+	// Archives pinned to a checksum are downloaded as a file and checked before anything is staged from them
+	checksum, err := sourceChecksum(terraformSource.CanonicalSourceURL, stageOptions.Checksums)
+	if err != nil {
+		return err
+	}
+
 	terragruntOptions.Logger.Infof("Downloading Terraform configurations from %s into %s", terraformSource.CanonicalSourceURL, terraformSource.DownloadDir)
 
-	if err := getter.GetAny(terraformSource.DownloadDir, terraformSource.CanonicalSourceURL.String(), updateGetters(terragruntOptions, terragruntConfig)); err != nil {
+	err = downloadWithRetries(terraformSource, terragruntOptions, stageOptions, func(ctx context.Context) error {
+		if checksum != "" {
+			return fetchVerifiedArchive(ctx, terraformSource, checksum, updateGetters(terragruntOptions, stageOptions, terragruntConfig, terraformSource), keepGetterErrors(terraformSource))
+		}
+		client := &getter.Client{
+			Ctx:     ctx,
+			Src:     terraformSource.CanonicalSourceURL.String(),
+			Dst:     terraformSource.DownloadDir,
+			Mode:    getter.ClientModeAny,
+			Options: []getter.ClientOption{updateGetters(terragruntOptions, stageOptions, terragruntConfig, terraformSource), keepGetterErrors(terraformSource)},
+		}
+		return client.Get()
+	})
+	if err != nil {
 		return errors.WithStackTrace(err)
 	}
+	return nil
+}
+
+// Return the folder of the run's shared download of the source, downloading it first if no other module has, and
+// whether this call downloaded it. Sources are shared by their canonical URL, ref included, and for sparse checkouts
+// of git sources by the module path too, since that decides what is checked out.
+func downloadSharedSource(terraformSource *Source, terragruntOptions *options.TerragruntOptions, stageOptions *StageOptions, terragruntConfig *config.TerragruntConfig) (string, DownloadRecord, bool, error) {
+	key := terraformSource.CanonicalSourceURL.String()
+	if stageOptions.Sparse && isGitSource(terraformSource.CanonicalSourceURL) {
+		key += "//" + terraformSource.ModulePath
+	}
+
+	downloaded := false
+	sharedDir, record, err := stageOptions.Downloads.Download(key, func(dir string, record *DownloadRecord) error {
+		downloaded = true
+		sharedSource := *terraformSource
+		sharedSource.DownloadDir = dir
+		sharedSource.Record = record
+		sharedSource.PreviousGenerated = nil
+		sharedSource.SharedClone = ""
+		return fetchSource(&sharedSource, terragruntOptions, stageOptions, terragruntConfig)
+	})
+	return sharedDir, record, downloaded, err
+}
+
+// Whether a git clone from an earlier run can be updated from the run's shared download of its source, which is
+// only a clone when git sources are cloned with the git CLI. Mirrored sources are already fetched once per run,
+// go-git clones are updated from their remote and offline stages from the source bundle.
+func sharesGitClone(terraformSource *Source, stageOptions *StageOptions) bool {
+	return isGitSource(terraformSource.CanonicalSourceURL) && stageOptions.GitCacheDir == "" && stageOptions.GitBackend != GitBackendGoGit && stageOptions.Bundle == nil
+}
+
+// Update the git clone from an earlier run in the Download Folder from the run's shared clone of the source, cloning
+// it first if no other module has, so the remote is only fetched from once however many stages share the source.
+// Each stage is still checked, reset and cleaned on its own, so local changes are refused the same way.
+func updateFromSharedClone(terraformSource *Source, terragruntOptions *options.TerragruntOptions, stageOptions *StageOptions, terragruntConfig *config.TerragruntConfig) error {
+	sharedDir, _, downloaded, err := downloadSharedSource(terraformSource, terragruntOptions, stageOptions, terragruntConfig)
+	if err != nil {
+		return err
+	}
+	if !downloaded {
+		terragruntOptions.Logger.Infof("Updating %s from the clone of %s already downloaded by this run", terraformSource.DownloadDir, terraformSource.CanonicalSourceURL)
+	}
+
+	terraformSource.SharedClone = sharedDir
+	defer func() { terraformSource.SharedClone = "" }()
+	return fetchSource(terraformSource, terragruntOptions, stageOptions, terragruntConfig)
+}
+
+// Copy the source into the Download Folder from the run's shared download of it, downloading it first if no other
+// module has. An existing stage is cleared first, keeping terraform's working files and terrastage's bookkeeping.
+func copySharedSource(terraformSource *Source, terragruntOptions *options.TerragruntOptions, stageOptions *StageOptions, terragruntConfig *config.TerragruntConfig) error {
+	sharedDir, record, downloaded, err := downloadSharedSource(terraformSource, terragruntOptions, stageOptions, terragruntConfig)
+	if err != nil {
+		return err
+	}
+	if !downloaded {
+		terragruntOptions.Logger.Infof("Copying Terraform configurations from %s, already downloaded by this run, into %s", terraformSource.CanonicalSourceURL, terraformSource.DownloadDir)
+	}
 
+	*terraformSource.Record = record
+	existingStage := util.FileExists(terraformSource.DownloadDir)
+	if err := clearStage(terraformSource.DownloadDir); err != nil {
+		return err
+	}
+	err = copyDirectory(sharedDir, terraformSource.DownloadDir, func(relPath string) bool {
+		return keptInStage(relPath, nil) && util.FileExists(filepath.Join(terraformSource.DownloadDir, filepath.FromSlash(relPath)))
+	})
+	if err != nil {
+		if !existingStage {
+			os.RemoveAll(terraformSource.DownloadDir)
+		}
+		return errors.WithStackTrace(err)
+	}
 	return nil
 }
 
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/gruntwork-io/go-commons/errors"
+)
+
+// How Often To Retry Taking A Lock That Someone Else Holds
+const lockRetryInterval = 250 * time.Millisecond
+
+// A Lock Older Than This Is Assumed To Be Left Behind By A Run That Was Killed
+const staleLockAge = time.Hour
+
+// How Often A Held Lock Is Touched, So A Run That Holds It For Longer Than staleLockAge Doesn't Look Like It Was Killed
+const lockRefreshInterval = staleLockAge / 4
+
+// Take An Exclusive Lock By Creating The Lock File, Waiting Up To The Timeout For Anyone Else Holding It.
+// This Works Across Processes And Operating Systems, So Parallel Terrastage Runs Can Share Caches Safely.
+// The Lock File's Modification Time Is Refreshed While It Is Held.   Returns A Function That Releases The Lock.
+func acquireFileLock(lockPath string, timeout time.Duration) (func(), error) {
+	if err := os.MkdirAll(filepath.Dir(lockPath), os.ModePerm); err != nil {
+		return nil, errors.WithStackTrace(err)
+	}
+
+	deadline := time.Now().Add(timeout)
+	for {
+		lockFile, err := os.OpenFile(lockPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+		if err == nil {
+			fmt.Fprintf(lockFile, "%d\n", os.Getpid())
+			lockFile.Close()
+			return holdFileLock(lockPath), nil
+		}
+		if !os.IsExist(err) {
+			return nil, errors.WithStackTrace(err)
+		}
+
+		// Clear Out Locks Left Behind By Runs That Never Released Them
+		if info, statErr := os.Stat(lockPath); statErr == nil && time.Since(info.ModTime()) > staleLockAge {
+			os.Remove(lockPath)
+			continue
+		}
+
+		if time.Now().After(deadline) {
+			return nil, errors.WithStackTrace(LockTimeout{Path: lockPath, Timeout: timeout})
+		}
+		time.Sleep(lockRetryInterval)
+	}
+}
+
+// Keep Touching The Lock File Until The Returned Function Releases It
+func holdFileLock(lockPath string) func() {
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(lockRefreshInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case now := <-ticker.C:
+				os.Chtimes(lockPath, now, now)
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			close(done)
+			<-stopped
+			os.Remove(lockPath)
+		})
+	}
+}
+
+type LockTimeout struct {
+	Path    string
+	Timeout time.Duration
+}
+
+func (err LockTimeout) Error() string {
+	return fmt.Sprintf("Timed out after %s waiting for lock %s. If no other terrastage run is using it, delete the lock file.", err.Timeout, err.Path)
+}
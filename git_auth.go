@@ -0,0 +1,274 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"github.com/gruntwork-io/terragrunt/util"
+)
+
+// Prefix Of The Environment Variables Holding An HTTPS Token Per Git Host, e.g. TERRASTAGE_GIT_TOKEN_github_com
+const gitTokenEnvPrefix = "TERRASTAGE_GIT_TOKEN_"
+
+// Prefix Of The Environment Variables Holding The User Name Sent With A Token, Which Defaults To defaultGitTokenUser
+const gitUserEnvPrefix = "TERRASTAGE_GIT_USER_"
+
+// User Name Sent With A Token When None Is Configured.   GitHub, GitLab And Azure DevOps Accept Any User Name
+// With A Personal Access Token.
+const defaultGitTokenUser = "x-access-token"
+
+var nonAlphanumericRegexp = regexp.MustCompile(`[^A-Za-z0-9]`)
+
+// How Terrastage Authenticates To Git Remotes, Set From The Command Line
+type GitAuthOptions struct {
+	// Path Of An SSH Private Key Used For SSH Remotes.   A Base64 sshkey Query Parameter In The Source Wins.
+	SSHKeyFile string
+
+	// Path Of A known_hosts File Used, Strictly, To Verify SSH Remotes
+	KnownHostsFile string
+
+	// Git Credential Helper Used For HTTPS Remotes When No Token Or .netrc Entry Is Found
+	CredentialHelper string
+}
+
+// HTTPS Credentials For A Git Host, Along With Where They Came From So That Can Be Logged Instead Of The Secret
+type httpCredentials struct {
+	User     string
+	Password string
+	Source   string
+}
+
+// Find HTTPS Credentials For A Host (Which May Include A Port), From The Host's Token Environment Variable
+// Or A .netrc Entry, In That Order
+func findHTTPCredentials(host string) (*httpCredentials, bool) {
+	envHost := nonAlphanumericRegexp.ReplaceAllString(strings.ToLower(host), "_")
+	for _, name := range []string{envHost, strings.ToUpper(envHost)} {
+		if token := os.Getenv(gitTokenEnvPrefix + name); token != "" {
+			user := os.Getenv(gitUserEnvPrefix + name)
+			if user == "" {
+				user = defaultGitTokenUser
+			}
+			return &httpCredentials{User: user, Password: token, Source: gitTokenEnvPrefix + name}, true
+		}
+	}
+
+	if netrcFile := netrcPath(); netrcFile != "" {
+		hostname := host
+		if h, _, found := strings.Cut(host, ":"); found {
+			hostname = h
+		}
+		if user, password, ok := lookupNetrc(netrcFile, hostname); ok {
+			return &httpCredentials{User: user, Password: password, Source: netrcFile}, true
+		}
+	}
+
+	return nil, false
+}
+
+// Return The Path Of The .netrc File, From The NETRC Environment Variable Or The Home Directory.  Windows
+// Tools Traditionally Use _netrc, So That Is Looked For There Too.
+func netrcPath() string {
+	if path := os.Getenv("NETRC"); path != "" {
+		return path
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	names := []string{".netrc"}
+	if runtime.GOOS == "windows" {
+		names = append(names, "_netrc")
+	}
+	for _, name := range names {
+		if path := filepath.Join(home, name); util.FileExists(path) {
+			return path
+		}
+	}
+	return ""
+}
+
+// Look Up The Login And Password For A Machine In A .netrc File, Falling Back To Its default Entry
+func lookupNetrc(path string, machine string) (string, string, bool) {
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return "", "", false
+	}
+
+	type entry struct{ login, password string }
+	var current *entry
+	var defaultEntry *entry
+	var found *entry
+
+	lines := strings.Split(string(contents), "\n")
+	for i := 0; i < len(lines); i++ {
+		fields := strings.Fields(lines[i])
+		for j := 0; j < len(fields); j++ {
+			keyword := fields[j]
+			if keyword == "default" {
+				defaultEntry = &entry{}
+				current = defaultEntry
+				continue
+			}
+			if keyword == "macdef" {
+				// Macro Definitions Run Until The Next Blank Line
+				for i+1 < len(lines) && strings.TrimSpace(lines[i+1]) != "" {
+					i++
+				}
+				break
+			}
+			if j+1 >= len(fields) {
+				break
+			}
+			j++
+			value := fields[j]
+
+			switch keyword {
+			case "machine":
+				current = nil
+				if found == nil && strings.EqualFold(value, machine) {
+					found = &entry{}
+					current = found
+				}
+			case "login":
+				if current != nil {
+					current.login = value
+				}
+			case "password":
+				if current != nil {
+					current.password = value
+				}
+			}
+		}
+	}
+
+	if found == nil {
+		found = defaultEntry
+	}
+	if found == nil || found.password == "" {
+		return "", "", false
+	}
+	return found.login, found.password, true
+}
+
+// gitAuth is what git commands for one remote are run with: an SSH key and
+// known_hosts file for SSH remotes, and git configuration, passed through the
+// environment, that authenticates HTTPS remotes. Secrets only ever live in the
+// environment of the git commands, never in their arguments, the clone's
+// .git/config or the stage.
+type gitAuth struct {
+	sshKeyFile     string
+	knownHostsFile string
+	config         []gitConfigEntry
+
+	// Where the HTTPS credentials came from, for logging
+	source string
+}
+
+type gitConfigEntry struct {
+	key   string
+	value string
+}
+
+// newGitAuth works out how to authenticate to the remote u. sshKeyFile is the
+// key decoded from the sshkey query parameter, which wins over the configured
+// key file.
+func newGitAuth(options GitAuthOptions, u *url.URL, sshKeyFile string) *gitAuth {
+	auth := &gitAuth{sshKeyFile: sshKeyFile, knownHostsFile: options.KnownHostsFile}
+	if auth.sshKeyFile == "" {
+		auth.sshKeyFile = options.SSHKeyFile
+	}
+
+	// Credentials are only ever sent over HTTPS, and only to the remote's host
+	if u.Scheme != "https" || u.Host == "" {
+		return auth
+	}
+
+	if credentials, ok := findHTTPCredentials(u.Host); ok {
+		header := "Authorization: Basic " + base64.StdEncoding.EncodeToString([]byte(credentials.User+":"+credentials.Password))
+		auth.config = append(auth.config, gitConfigEntry{key: fmt.Sprintf("http.https://%s/.extraHeader", u.Host), value: header})
+		auth.source = credentials.Source
+	} else if options.CredentialHelper != "" {
+		auth.config = append(auth.config, gitConfigEntry{key: "credential.helper", value: options.CredentialHelper})
+		auth.source = "credential helper " + options.CredentialHelper
+	}
+
+	return auth
+}
+
+// env returns the environment to run git with: the current environment plus
+// GIT_SSH_COMMAND options for the key and known_hosts files and the git
+// configuration entries, added after any GIT_CONFIG_COUNT entries already in
+// the environment. Returns nil when there is nothing to add.
+func (auth *gitAuth) env() []string {
+	if auth == nil || (auth.sshKeyFile == "" && auth.knownHostsFile == "" && len(auth.config) == 0) {
+		return nil
+	}
+
+	const gitSSHCommand = "GIT_SSH_COMMAND="
+	const gitConfigCount = "GIT_CONFIG_COUNT="
+	var sshCmd []string
+	configCount := 0
+
+	// If we have an existing GIT_SSH_COMMAND, we need to append our options.
+	env := []string{}
+	for _, v := range os.Environ() {
+		if strings.HasPrefix(v, gitSSHCommand) && len(v) > len(gitSSHCommand) {
+			sshCmd = []string{v}
+			continue
+		}
+		if strings.HasPrefix(v, gitConfigCount) {
+			configCount, _ = strconv.Atoi(strings.TrimPrefix(v, gitConfigCount))
+			continue
+		}
+		env = append(env, v)
+	}
+
+	if auth.sshKeyFile != "" || auth.knownHostsFile != "" {
+		if len(sshCmd) == 0 {
+			sshCmd = []string{gitSSHCommand + "ssh"}
+		}
+		if auth.sshKeyFile != "" {
+			sshCmd = append(sshCmd, "-i", shellQuote(sshPath(auth.sshKeyFile)))
+		}
+		if auth.knownHostsFile != "" {
+			sshCmd = append(sshCmd, "-o", shellQuote("UserKnownHostsFile="+sshPath(auth.knownHostsFile)), "-o", "StrictHostKeyChecking=yes")
+		}
+		env = append(env, strings.Join(sshCmd, " "))
+	} else if len(sshCmd) > 0 {
+		env = append(env, sshCmd[0])
+	}
+
+	for _, entry := range auth.config {
+		env = append(env,
+			fmt.Sprintf("GIT_CONFIG_KEY_%d=%s", configCount, entry.key),
+			fmt.Sprintf("GIT_CONFIG_VALUE_%d=%s", configCount, entry.value))
+		configCount++
+	}
+	if configCount > 0 {
+		env = append(env, gitConfigCount+strconv.Itoa(configCount))
+	}
+
+	return env
+}
+
+// shellQuote quotes an argument for GIT_SSH_COMMAND, which git runs with the
+// shell, so paths with spaces or quotes reach ssh as a single argument.
+func shellQuote(arg string) string {
+	return "'" + strings.ReplaceAll(arg, "'", `'\''`) + "'"
+}
+
+// sshPath returns a path in the form ssh expects it on the command line.
+func sshPath(path string) string {
+	if runtime.GOOS == "windows" {
+		return strings.Replace(path, `\`, `/`, -1)
+	}
+	return path
+}
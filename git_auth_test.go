@@ -0,0 +1,37 @@
+package main
+
+import (
+	"os/exec"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGitAuthQuotesSSHCommandPaths(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("GIT_SSH_COMMAND is run with the shell")
+	}
+	t.Setenv("GIT_SSH_COMMAND", "")
+
+	auth := &gitAuth{sshKeyFile: "/keys/deploy key's/id_rsa", knownHostsFile: "/keys/known hosts"}
+
+	sshCommand := ""
+	for _, v := range auth.env() {
+		if strings.HasPrefix(v, "GIT_SSH_COMMAND=") {
+			sshCommand = strings.TrimPrefix(v, "GIT_SSH_COMMAND=")
+		}
+	}
+	require.True(t, strings.HasPrefix(sshCommand, "ssh "))
+
+	// Print the arguments the shell passes to ssh, one per line
+	output, err := exec.Command("sh", "-c", `printf '%s\n' `+strings.TrimPrefix(sshCommand, "ssh ")).Output()
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"-i", "/keys/deploy key's/id_rsa",
+		"-o", "UserKnownHostsFile=/keys/known hosts",
+		"-o", "StrictHostKeyChecking=yes",
+	}, strings.Split(strings.TrimSuffix(string(output), "\n"), "\n"))
+}
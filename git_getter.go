@@ -9,6 +9,7 @@ import (
 	"net/url"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"regexp"
 	"runtime"
 	"strconv"
@@ -19,14 +20,55 @@ import (
 	version "github.com/hashicorp/go-version"
 )
 
-// A custom getter.Getter implementation that does not download submodules
+// A custom getter.Getter implementation that only downloads submodules when asked to
 // A number of AWS modules have submodules that do not download correctly
 
 type GitGetter struct {
 	getter.GitGetter
+
+	// Folder of the shared bare mirror cache. When set, repositories are fetched
+	// into a mirror there and the requested ref is materialised from the mirror
+	// instead of cloning into every stage.
+	MirrorDir string
+
+	// Details of the download, such as the commit that was checked out, are
+	// reported here when it is set.
+	Record *DownloadRecord
+
+	// When Sparse is set only Subdir (the module path after the double-slash)
+	// and the directories its relative module sources reference are
+	// materialised, rather than the whole repository.
+	Sparse bool
+	Subdir string
+
+	// The commit each ref resolves to is recorded in Lock when it is set. In
+	// Locked mode the locked commit is checked out instead, and the download
+	// fails if the ref has moved since it was locked.
+	Lock   *LockFile
+	Locked bool
+
+	// Whether submodules are fetched when the source doesn't say with a
+	// submodules query parameter.
+	Submodules bool
+
+	// Files terrastage generated into dst, relative to it. When an existing
+	// clone is updated these may have been changed and are never cleaned.
+	Generated []string
+
+	// How to authenticate to the remote, on top of an sshkey query parameter
+	Auth GitAuthOptions
+
+	// A clone of the same source that this run already fetched. An existing
+	// clone at dst is updated from it rather than from the remote, so modules
+	// sharing a source only fetch it over the network once.
+	SharedClone string
+
+	// Which signatures the checked out ref must carry, and the keys trusted
+	// to make them. Refs that aren't signed by a trusted key are never
+	// staged.
+	Signatures GitSignatureOptions
 }
 
-var defaultBranchRegexp = regexp.MustCompile(`\s->\sorigin/(.*)`)
 var lsRemoteSymRefRegexp = regexp.MustCompile(`ref: refs/heads/([^\s]+).*`)
 
 func (g *GitGetter) Get(dst string, u *url.URL) error {
@@ -57,6 +99,7 @@ func (g *GitGetter) Get(dst string, u *url.URL) error {
 	// Extract some query parameters we use
 	var ref, sshKey string
 	depth := 0 // 0 means "don't use shallow clone"
+	submodules := g.Submodules
 	q := u.Query()
 	if len(q) > 0 {
 		ref = q.Get("ref")
@@ -70,6 +113,15 @@ func (g *GitGetter) Get(dst string, u *url.URL) error {
 		}
 		q.Del("depth")
 
+		if q.Has("submodules") {
+			fetch, err := strconv.ParseBool(q.Get("submodules"))
+			if err != nil {
+				return fmt.Errorf("invalid submodules value %q; use true or false", q.Get("submodules"))
+			}
+			submodules = fetch
+		}
+		q.Del("submodules")
+
 		// Copy the URL
 		var newU url.URL = *u
 		u = &newU
@@ -110,32 +162,93 @@ func (g *GitGetter) Get(dst string, u *url.URL) error {
 		}
 	}
 
+	// Work out how to authenticate to the remote. HTTPS credentials are passed
+	// to git through its environment, which needs git 2.31 or newer.
+	auth := newGitAuth(g.Auth, u, sshKeyFile)
+	if g.Record != nil {
+		g.Record.AuthSource = auth.source
+	}
+	if len(auth.config) > 0 {
+		if err := checkGitVersion(ctx, "2.31"); err != nil {
+			return fmt.Errorf("Error using credentials from %s: %v", auth.source, err)
+		}
+	}
+
+	// In locked mode check out exactly the locked commit rather than whatever
+	// the ref points to now
+	requestedRef := ref
+	if g.Lock != nil && g.Locked {
+		lockedCommit, err := g.lockedRef(ctx, u, auth, ref)
+		if err != nil {
+			return err
+		}
+		ref = lockedCommit
+	}
+
+	// Materialise from the shared mirror cache if one is configured. git
+	// archive can't include submodules, so sources that need them are cloned
+	// directly instead.
+	if g.MirrorDir != "" && !submodules {
+		if err := g.getFromMirror(ctx, dst, auth, u, ref, requestedRef); err != nil {
+			return err
+		}
+		return g.recordResolvedCommit(u, requestedRef, dst)
+	}
+
 	// Clone or update the repository
 	_, err := os.Stat(dst)
 	if err != nil && !os.IsNotExist(err) {
 		return err
 	}
-	if err == nil {
-		err = g.update(ctx, dst, sshKeyFile, ref, depth)
+	updated := err == nil
+	if updated {
+		err = g.update(ctx, dst, auth, u, ref, requestedRef, depth)
+	} else if g.sparse() {
+		err = g.sparseClone(ctx, dst, auth, u, ref, depth)
 	} else {
-		err = g.clone(ctx, dst, sshKeyFile, u, ref, depth)
+		err = g.clone(ctx, dst, auth, u, ref, depth)
 	}
 	if err != nil {
 		return err
 	}
 
-	// Next: check out the proper tag/branch if it is specified, and checkout
-	if ref != "" {
+	// Next: check out the proper tag/branch of a new clone if it is specified
+	if ref != "" && !updated {
 		if err := g.checkout(ctx, dst, ref); err != nil {
 			return err
 		}
 	}
 
-	// Lastly, download any/all submodules.
-	//return g.fetchSubmodules(ctx, dst, sshKeyFile, depth)
+	// A new clone of a ref that isn't signed by a trusted key is removed
+	// again, so nothing unverified is left in the stage. Updated clones are
+	// verified before they are checked out.
+	if !updated && g.Signatures.Mode != "" {
+		commit, err := gitRevParse(dst, "HEAD")
+		if err == nil {
+			err = g.verifySignature(ctx, dst, u, commit, requestedRef)
+		}
+		if err != nil {
+			os.RemoveAll(dst)
+			return err
+		}
+	}
 
-	// We Are Not Downloading Submodules, Just End And Return Nil As No Errors Have Been Found
-	return nil
+	// Restrict the checkout to the module subdirectory and what it references
+	if g.sparse() {
+		if err := g.sparseCheckout(ctx, dst); err != nil {
+			return err
+		}
+	}
+
+	// Lastly, download any/all submodules. Some modules (a number of AWS ones)
+	// have submodules that don't download correctly, so this is opt in.
+	if submodules {
+		if err := g.fetchSubmodules(ctx, dst, auth, depth); err != nil {
+			return err
+		}
+	}
+
+	return g.recordResolvedCommit(u, requestedRef, dst)
 }
 
 func (g *GitGetter) checkout(ctx context.Context, dst string, ref string) error {
@@ -154,12 +267,29 @@ func (g *GitGetter) checkout(ctx context.Context, dst string, ref string) error
 // positives on short branch names that happen to also be "hex words".
 var gitCommitIDRegex = regexp.MustCompile("^[0-9a-fA-F]{7,40}$")
 
-func (g *GitGetter) clone(ctx context.Context, dst, sshKeyFile string, u *url.URL, ref string, depth int) error {
+// gitFullCommitIDRegex matches full commit IDs, which unlike abbreviated ones
+// can be fetched from a remote directly.
+var gitFullCommitIDRegex = regexp.MustCompile("^[0-9a-fA-F]{40}$")
+
+func (g *GitGetter) clone(ctx context.Context, dst string, auth *gitAuth, u *url.URL, ref string, depth int) error {
+	// A shallow clone of a commit can't be made with --branch, but the commit
+	// can be fetched by its full ID into a new repository instead. Servers that
+	// don't allow that get a full clone.
+	if depth > 0 && gitFullCommitIDRegex.MatchString(ref) {
+		if err := shallowFetchCommit(ctx, dst, auth, u, ref, depth); err == nil {
+			return g.checkout(ctx, dst, ref)
+		}
+		if err := os.RemoveAll(dst); err != nil {
+			return err
+		}
+		depth = 0
+	}
+
 	args := []string{"clone"}
 
 	originalRef := ref // we handle an unspecified ref differently than explicitly selecting the default branch below
 	if ref == "" {
-		ref = findRemoteDefaultBranch(ctx, u)
+		ref = findRemoteDefaultBranch(ctx, u, auth)
 	}
 	if depth > 0 {
 		args = append(args, "--depth", strconv.Itoa(depth))
@@ -168,7 +298,7 @@ func (g *GitGetter) clone(ctx context.Context, dst, sshKeyFile string, u *url.UR
 	args = append(args, u.String(), dst)
 
 	cmd := exec.CommandContext(ctx, "git", args...)
-	setupGitEnv(cmd, sshKeyFile)
+	setupGitEnv(cmd, auth)
 	err := getRunCommand(cmd)
 	if err != nil {
 		if depth > 0 && originalRef != "" {
@@ -178,7 +308,7 @@ func (g *GitGetter) clone(ctx context.Context, dst, sshKeyFile string, u *url.UR
 			// hard-coding assumptions about git's human-readable output, but
 			// we can at least try a heuristic.
 			if gitCommitIDRegex.MatchString(originalRef) {
-				return fmt.Errorf("%w (note that setting 'depth' requires 'ref' to be a branch or tag name)", err)
+				return fmt.Errorf("%w (note that setting 'depth' requires 'ref' to be a branch or tag name or a full commit ID)", err)
 			}
 		}
 		return err
@@ -193,69 +323,109 @@ func (g *GitGetter) clone(ctx context.Context, dst, sshKeyFile string, u *url.UR
 	return nil
 }
 
-func (g *GitGetter) update(ctx context.Context, dst, sshKeyFile, ref string, depth int) error {
-	// Determine if we're a branch. If we're NOT a branch, then we just
-	// switch to master prior to checking out
-	cmd := exec.CommandContext(ctx, "git", "show-ref", "-q", "--verify", "refs/heads/"+ref)
-	cmd.Dir = dst
+// shallowFetchCommit creates a repository at dst holding just the given commit,
+// and depth-1 of its ancestors, fetched by its full ID. Nothing is checked out.
+// This needs a server that allows fetching commits that aren't the tip of a
+// ref (uploadpack.allowReachableSHA1InWant, or protocol version 2).
+func shallowFetchCommit(ctx context.Context, dst string, auth *gitAuth, u *url.URL, commit string, depth int) error {
+	if err := os.MkdirAll(dst, os.ModePerm); err != nil {
+		return err
+	}
 
-	if getRunCommand(cmd) != nil {
-		// Not a branch, switch to default branch. This will also catch
-		// non-existent branches, in which case we want to switch to default
-		// and then checkout the proper branch later.
-		ref = findDefaultBranch(ctx, dst)
+	for _, args := range [][]string{
+		{"init", "-q"},
+		{"remote", "add", "origin", u.String()},
+		{"fetch", "--depth", strconv.Itoa(depth), "origin", commit},
+	} {
+		cmd := exec.CommandContext(ctx, "git", args...)
+		cmd.Dir = dst
+		setupGitEnv(cmd, auth)
+		if err := getRunCommand(cmd); err != nil {
+			return err
+		}
 	}
+	return nil
+}
 
-	// We have to be on a branch to pull
-	if err := g.checkout(ctx, dst, ref); err != nil {
+// update brings an existing clone at dst to the given ref, even when upstream
+// force-pushed, a tag moved or the previous checkout was a detached commit.
+// Everything is fetched, then dst is hard reset to what the ref resolves to
+// and untracked files terrastage didn't generate are cleaned. Anything that
+// would make that unsafe (dst isn't a clone of the same remote, or tracked
+// files were changed by someone other than terrastage) is an error instead, as
+// is a ref that isn't signed the way g.Signatures asks. requestedRef is the
+// ref the source asked for, which ref replaces with the locked commit in
+// locked mode. When g.SharedClone is set everything is fetched from it
+// instead of the remote.
+func (g *GitGetter) update(ctx context.Context, dst string, auth *gitAuth, u *url.URL, ref, requestedRef string, depth int) error {
+	if err := g.checkReusableClone(ctx, dst, u); err != nil {
 		return err
 	}
 
+	args := []string{"fetch", "--tags", "--prune", "--force"}
 	if depth > 0 {
-		cmd = exec.CommandContext(ctx, "git", "pull", "--depth", strconv.Itoa(depth), "--ff-only")
-	} else {
-		cmd = exec.CommandContext(ctx, "git", "pull", "--ff-only")
+		args = append(args, "--depth", strconv.Itoa(depth))
+	}
+	remote, refspecs := "origin", []string(nil)
+	if g.SharedClone != "" {
+		// The shared clone's remote branches are the remote's branches
+		remote, refspecs = g.SharedClone, []string{"+refs/remotes/origin/*:refs/remotes/origin/*"}
+	}
+	cmd := exec.CommandContext(ctx, "git", append(append(args, remote), refspecs...)...)
+	cmd.Dir = dst
+	setupGitEnv(cmd, auth)
+	if err := getRunCommand(cmd); err != nil {
+		return err
+	}
+
+	if ref == "" && g.SharedClone != "" {
+		ref, _ = gitOutput(ctx, g.SharedClone, "symbolic-ref", "--short", "refs/remotes/origin/HEAD")
+		ref = strings.TrimPrefix(ref, "origin/")
+	}
+	if ref == "" {
+		ref = findRemoteDefaultBranch(ctx, u, auth)
+	}
+	commit, branch, err := resolveFetchedRef(ctx, dst, auth, remote, ref, depth)
+	if err != nil {
+		return err
+	}
+	if err := g.verifySignature(ctx, dst, u, commit, requestedRef); err != nil {
+		return err
 	}
 
+	// Branches stay checked out as a branch, anything else is detached
+	if branch != "" {
+		cmd = exec.CommandContext(ctx, "git", "checkout", "--force", "-B", branch, commit)
+	} else {
+		cmd = exec.CommandContext(ctx, "git", "checkout", "--force", "--detach", commit)
+	}
 	cmd.Dir = dst
-	setupGitEnv(cmd, sshKeyFile)
-	return getRunCommand(cmd)
+	if err := getRunCommand(cmd); err != nil {
+		return err
+	}
+
+	return g.cleanUntracked(ctx, dst)
 }
 
 // fetchSubmodules downloads any configured submodules recursively.
-func (g *GitGetter) fetchSubmodules(ctx context.Context, dst, sshKeyFile string, depth int) error {
+func (g *GitGetter) fetchSubmodules(ctx context.Context, dst string, auth *gitAuth, depth int) error {
 	args := []string{"submodule", "update", "--init", "--recursive"}
 	if depth > 0 {
 		args = append(args, "--depth", strconv.Itoa(depth))
 	}
 	cmd := exec.CommandContext(ctx, "git", args...)
 	cmd.Dir = dst
-	setupGitEnv(cmd, sshKeyFile)
+	setupGitEnv(cmd, auth)
 	return getRunCommand(cmd)
 }
 
-// findDefaultBranch checks the repo's origin remote for its default branch
-// (generally "master"). "master" is returned if an origin default branch
-// can't be determined.
-func findDefaultBranch(ctx context.Context, dst string) string {
-	var stdoutbuf bytes.Buffer
-	cmd := exec.CommandContext(ctx, "git", "branch", "-r", "--points-at", "refs/remotes/origin/HEAD")
-	cmd.Dir = dst
-	cmd.Stdout = &stdoutbuf
-	err := cmd.Run()
-	matches := defaultBranchRegexp.FindStringSubmatch(stdoutbuf.String())
-	if err != nil || matches == nil {
-		return "master"
-	}
-	return matches[len(matches)-1]
-}
-
 // findRemoteDefaultBranch checks the remote repo's HEAD symref to return the remote repo's
 // default branch. "master" is returned if no HEAD symref exists.
-func findRemoteDefaultBranch(ctx context.Context, u *url.URL) string {
+func findRemoteDefaultBranch(ctx context.Context, u *url.URL, auth *gitAuth) string {
 	var stdoutbuf bytes.Buffer
 	cmd := exec.CommandContext(ctx, "git", "ls-remote", "--symref", u.String(), "HEAD")
 	cmd.Stdout = &stdoutbuf
+	setupGitEnv(cmd, auth)
 	err := cmd.Run()
 	matches := lsRemoteSymRefRegexp.FindStringSubmatch(stdoutbuf.String())
 	if err != nil || matches == nil {
@@ -264,43 +434,43 @@ func findRemoteDefaultBranch(ctx context.Context, u *url.URL) string {
 	return matches[len(matches)-1]
 }
 
-// setupGitEnv sets up the environment for the given command. This is used to
-// pass configuration data to git and ssh and enables advanced cloning methods.
-func setupGitEnv(cmd *exec.Cmd, sshKeyFile string) {
-	// If there's no sshKeyFile argument to deal with, we can skip this
-	// entirely.
-	if sshKeyFile == "" {
-		return
-	}
-	const gitSSHCommand = "GIT_SSH_COMMAND="
-	var sshCmd []string
-
-	// If we have an existing GIT_SSH_COMMAND, we need to append our options.
-	// We will also remove our old entry to make sure the behavior is the same
-	// with versions of Go < 1.9.
-	env := os.Environ()
-	for i, v := range env {
-		if strings.HasPrefix(v, gitSSHCommand) && len(v) > len(gitSSHCommand) {
-			sshCmd = []string{v}
-
-			env[i], env[len(env)-1] = env[len(env)-1], env[i]
-			env = env[:len(env)-1]
-			break
-		}
+// resolveHeadCommit returns the commit checked out in the given directory, or an
+// empty string if the directory isn't a git repository.
+func resolveHeadCommit(dst string) string {
+	if _, err := os.Stat(filepath.Join(dst, ".git")); err != nil {
+		return ""
+	}
+	commit, err := gitRevParse(dst, "HEAD")
+	if err != nil {
+		return ""
 	}
+	return commit
+}
 
-	if len(sshCmd) == 0 {
-		sshCmd = []string{gitSSHCommand + "ssh"}
+// gitRevParse returns the object ID that the given revision resolves to in
+// the repository at dst. Without the git binary go-git is used instead.
+func gitRevParse(dst, rev string) (string, error) {
+	if _, err := exec.LookPath("git"); err != nil {
+		return goGitRevParse(dst, rev)
 	}
 
-	// We have an SSH key temp file configured, tell ssh about this.
-	if runtime.GOOS == "windows" {
-		sshKeyFile = strings.Replace(sshKeyFile, `\`, `/`, -1)
+	var stdoutbuf bytes.Buffer
+	cmd := exec.Command("git", "rev-parse", "--verify", "--quiet", rev)
+	cmd.Dir = dst
+	cmd.Stdout = &stdoutbuf
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("could not resolve %s in %s: %w", rev, dst, err)
 	}
-	sshCmd = append(sshCmd, "-i", sshKeyFile)
-	env = append(env, strings.Join(sshCmd, " "))
+	return strings.TrimSpace(stdoutbuf.String()), nil
+}
 
-	cmd.Env = env
+// setupGitEnv sets up the environment for the given command. This is used to
+// pass configuration data to git and ssh and enables advanced cloning methods.
+// Credentials are passed this way so they never appear in command lines.
+func setupGitEnv(cmd *exec.Cmd, auth *gitAuth) {
+	if env := auth.env(); env != nil {
+		cmd.Env = env
+	}
 }
 
 // checkGitVersion is used to check the version of git installed on the system
@@ -356,13 +526,21 @@ func getRunCommand(cmd *exec.Cmd) error {
 	if exiterr, ok := err.(*exec.ExitError); ok {
 		// The program has exited with an exit code != 0
 		if status, ok := exiterr.Sys().(syscall.WaitStatus); ok {
-			return fmt.Errorf(
-				"%s exited with %d: %s",
-				cmd.Path,
-				status.ExitStatus(),
-				buf.String())
+			return GitCommandFailed{Path: cmd.Path, ExitStatus: status.ExitStatus(), Output: buf.String()}
 		}
 	}
 
 	return fmt.Errorf("error running %s: %s", cmd.Path, buf.String())
 }
+
+// GitCommandFailed is returned when a git command exits with an error, with
+// everything it printed.
+type GitCommandFailed struct {
+	Path       string
+	ExitStatus int
+	Output     string
+}
+
+func (err GitCommandFailed) Error() string {
+	return fmt.Sprintf("%s exited with %d: %s", err.Path, err.ExitStatus, err.Output)
+}
@@ -0,0 +1,630 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/plumbing/transport/client"
+	githttp "github.com/go-git/go-git/v5/plumbing/transport/http"
+	"github.com/go-git/go-git/v5/plumbing/transport/server"
+	gitssh "github.com/go-git/go-git/v5/plumbing/transport/ssh"
+	"github.com/go-git/go-git/v5/storage/memory"
+	"github.com/hashicorp/go-getter"
+)
+
+// Names Of The Git Backends That Can Be Selected With -git-backend
+const (
+	GitBackendExec  = "git"
+	GitBackendGoGit = "go-git"
+)
+
+// The Ref Commits That Were Fetched By ID Are Stored Under, So They Aren't Garbage Collected Before They Are Checked
+// Out
+const goGitFetchedCommitRef = "refs/terrastage/fetched"
+
+// A getter.Getter Implementation Of Git Sources On Top Of go-git, A Pure Go Implementation Of Git, For Machines Without
+// The Git Binary.   It Supports The Same Query Parameters As GitGetter, But Not The Shared Mirror Cache, Sparse
+// Checkouts Or Credential Helpers, Which All Need The Git Binary.
+type GoGitGetter struct {
+	getter.GitGetter
+
+	// Details Of The Download, Such As The Commit That Was Checked Out, Are Reported Here When It Is Set
+	Record *DownloadRecord
+
+	// The Commit Each Ref Resolves To Is Recorded In Lock When It Is Set.   In Locked Mode The Locked Commit Is Checked
+	// Out Instead, And The Download Fails If The Ref Has Moved Since It Was Locked.
+	Lock   *LockFile
+	Locked bool
+
+	// Whether Submodules Are Fetched When The Source Doesn't Say With A submodules Query Parameter
+	Submodules bool
+
+	// Files Terrastage Generated Into dst, Relative To It.   When An Existing Clone Is Updated These May Have Been
+	// Changed And Are Never Cleaned.
+	Generated []string
+
+	// How To Authenticate To The Remote, On Top Of An sshkey Query Parameter
+	Auth GitAuthOptions
+
+	// Which Signatures The Checked Out Ref Must Carry, And The GPG Keyring Trusted To Make Them.   go-git Can't Verify
+	// SSH Signatures.
+	Signatures GitSignatureOptions
+}
+
+// go-git Runs git-upload-pack For file:// Remotes.   Without Git The In-Process Server go-git Ships With Is Used
+// Instead, Once For The Whole Run.
+var useInProcessFileTransport sync.Once
+
+func (g *GoGitGetter) Get(dst string, u *url.URL) error {
+	ctx := g.Context()
+
+	if g.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, g.Timeout)
+		defer cancel()
+	}
+
+	if portStr := u.Port(); portStr != "" {
+		if _, err := strconv.ParseUint(portStr, 10, 16); err != nil {
+			return fmt.Errorf("invalid port number %q; if using the \"scp-like\" git address scheme where a colon introduces the path instead, remove the ssh:// portion and use just the git:: prefix", portStr)
+		}
+	}
+
+	// Extract Some Query Parameters We Use
+	var ref, sshKey string
+	depth := 0 // 0 Means "Don't Use Shallow Clone"
+	submodules := g.Submodules
+	q := u.Query()
+	if len(q) > 0 {
+		ref = q.Get("ref")
+		q.Del("ref")
+
+		sshKey = q.Get("sshkey")
+		q.Del("sshkey")
+
+		if n, err := strconv.Atoi(q.Get("depth")); err == nil {
+			depth = n
+		}
+		q.Del("depth")
+
+		if q.Has("submodules") {
+			fetch, err := strconv.ParseBool(q.Get("submodules"))
+			if err != nil {
+				return fmt.Errorf("invalid submodules value %q; use true or false", q.Get("submodules"))
+			}
+			submodules = fetch
+		}
+		q.Del("submodules")
+
+		// Copy The URL
+		var newU url.URL = *u
+		u = &newU
+		u.RawQuery = q.Encode()
+	}
+
+	var sshKeyPEM []byte
+	if sshKey != "" {
+		raw, err := base64.StdEncoding.DecodeString(sshKey)
+		if err != nil {
+			return err
+		}
+		sshKeyPEM = raw
+	}
+
+	auth, authSource, err := newGoGitAuth(g.Auth, u, sshKeyPEM)
+	if err != nil {
+		return err
+	}
+	if g.Record != nil {
+		g.Record.AuthSource = authSource
+	}
+
+	// The In-Process Server Can't Make Shallow Packs, And A Shallow Copy Of A Local Repository Saves Next To Nothing
+	// Anyway
+	if u.Scheme == "file" && !gitUploadPackAvailable() {
+		useInProcessFileTransport.Do(func() {
+			client.InstallProtocol("file", server.DefaultServer)
+		})
+		depth = 0
+	}
+
+	// Find Out What The Ref Points To On The Remote, The Same Way git ls-remote Would
+	remote := git.NewRemote(memory.NewStorage(), &config.RemoteConfig{Name: git.DefaultRemoteName, URLs: []string{u.String()}})
+	refs, err := remote.ListContext(ctx, &git.ListOptions{Auth: auth, PeelingOption: git.AppendPeeled})
+	if err != nil {
+		return fmt.Errorf("listing the refs of %s failed: %w", u.Redacted(), err)
+	}
+	commit, refName := goGitListedRef(refs, ref)
+
+	// In Locked Mode Check Out Exactly The Locked Commit Rather Than Whatever The Ref Points To Now
+	if g.Lock != nil && g.Locked {
+		commit, err = checkLockedRef(g.Lock, u, ref, commit)
+		if err != nil {
+			return err
+		}
+	}
+	if commit == "" {
+		if !gitCommitIDRegex.MatchString(ref) {
+			return fmt.Errorf("ref %q was not found in %s", ref, u.Redacted())
+		}
+		commit = strings.ToLower(ref)
+	}
+
+	// Clone Or Update The Repository
+	_, err = os.Stat(dst)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	var repo *git.Repository
+	if err == nil {
+		repo, err = g.openReusableClone(dst, u)
+		if err != nil {
+			return err
+		}
+	} else {
+		repo, err = git.PlainInit(dst, false)
+		if err == nil {
+			_, err = repo.CreateRemote(&config.RemoteConfig{Name: git.DefaultRemoteName, URLs: []string{u.String()}})
+		}
+		if err != nil {
+			os.RemoveAll(dst)
+			return err
+		}
+		defer func() {
+			if err != nil {
+				os.RemoveAll(dst)
+			}
+		}()
+	}
+
+	hash, err := goGitFetch(ctx, repo, auth, commit, refName, depth)
+	if err != nil {
+		return fmt.Errorf("fetching %s from %s failed: %w", lockFileRef(ref), u.Redacted(), err)
+	}
+	if err = g.verifySignature(ctx, repo, auth, u, hash, refName, depth); err != nil {
+		return err
+	}
+	if err = goGitCheckout(repo, dst, hash, refName); err != nil {
+		return err
+	}
+	if err = g.cleanUntracked(repo, dst); err != nil {
+		return err
+	}
+
+	// Lastly, Download Any/All Submodules.   Some Modules (A Number Of AWS Ones) Have Submodules That Don't Download
+	// Correctly, So This Is Opt In.
+	if submodules {
+		if err = goGitUpdateSubmodules(ctx, repo, auth, depth); err != nil {
+			return err
+		}
+	}
+
+	if g.Record != nil {
+		g.Record.ResolvedCommit = hash.String()
+	}
+	if g.Lock != nil && !g.Locked {
+		g.Lock.Record(u, ref, hash.String())
+	}
+	return nil
+}
+
+// Returns True If go-git Can Run git-upload-pack For file:// Remotes, Either From The PATH Or Git's Exec Path
+func gitUploadPackAvailable() bool {
+	if _, err := exec.LookPath(transport.UploadPackServiceName); err == nil {
+		return true
+	}
+	_, err := exec.LookPath("git")
+	return err == nil
+}
+
+// Work Out How To Authenticate To The Remote u, The Same Way newGitAuth Does For The Git Binary, And Return Where
+// HTTPS Credentials Came From For Logging.   sshKeyPEM Is The Key From The sshkey Query Parameter, Which Wins Over The
+// Configured Key File.   A nil AuthMethod Leaves It To go-git, Which Uses The SSH Agent For SSH Remotes.
+func newGoGitAuth(options GitAuthOptions, u *url.URL, sshKeyPEM []byte) (transport.AuthMethod, string, error) {
+	switch u.Scheme {
+	case "https":
+		// Credentials In The URL Itself Are Used By go-git As They Are
+		if u.Host == "" || u.User != nil {
+			return nil, "", nil
+		}
+		if credentials, ok := findHTTPCredentials(u.Host); ok {
+			return &githttp.BasicAuth{Username: credentials.User, Password: credentials.Password}, credentials.Source, nil
+		}
+		return nil, "", nil
+
+	case "ssh":
+		user := u.User.Username()
+		if user == "" {
+			user = "git"
+		}
+
+		if sshKeyPEM == nil && options.SSHKeyFile != "" {
+			raw, err := os.ReadFile(options.SSHKeyFile)
+			if err != nil {
+				return nil, "", err
+			}
+			sshKeyPEM = raw
+		}
+
+		var auth *gitssh.PublicKeys
+		var err error
+		if sshKeyPEM == nil {
+			if options.KnownHostsFile == "" {
+				return nil, "", nil
+			}
+			agent, err := gitssh.NewSSHAgentAuth(user)
+			if err != nil {
+				return nil, "", err
+			}
+			agent.HostKeyCallback, err = gitssh.NewKnownHostsCallback(options.KnownHostsFile)
+			return agent, "", err
+		}
+		if auth, err = gitssh.NewPublicKeys(user, sshKeyPEM, ""); err != nil {
+			return nil, "", fmt.Errorf("Error using ssh key: %v", err)
+		}
+		if options.KnownHostsFile != "" {
+			if auth.HostKeyCallback, err = gitssh.NewKnownHostsCallback(options.KnownHostsFile); err != nil {
+				return nil, "", err
+			}
+		}
+		return auth, "", nil
+	}
+
+	return nil, "", nil
+}
+
+// Return The Commit ref Points To Among The Refs A Remote Listed, And The Full Name Of The Ref.   Tags Are Peeled To
+// The Commit They Point To And Win Over Branches Of The Same Name, Like lsRemoteRef.   An Empty ref Resolves To The
+// Remote's HEAD, Named After The Branch HEAD Points To When The Remote Says So.   Both Are Empty If The Remote Has No
+// Such Ref.
+func goGitListedRef(refs []*plumbing.Reference, ref string) (string, string) {
+	byName := map[string]*plumbing.Reference{}
+	for _, listed := range refs {
+		byName[listed.Name().String()] = listed
+	}
+
+	if ref == "" || ref == "HEAD" {
+		head, ok := byName["HEAD"]
+		if !ok {
+			return "", ""
+		}
+		if head.Type() == plumbing.SymbolicReference {
+			if target, ok := byName[head.Target().String()]; ok {
+				return target.Hash().String(), target.Name().String()
+			}
+			return "", ""
+		}
+		return head.Hash().String(), ""
+	}
+
+	names := []string{ref}
+	if !strings.HasPrefix(ref, "refs/") {
+		names = []string{"refs/tags/" + ref, "refs/heads/" + ref}
+	}
+	for _, name := range names {
+		if peeled, ok := byName[name+"^{}"]; ok {
+			return peeled.Hash().String(), name
+		}
+		if listed, ok := byName[name]; ok && listed.Type() == plumbing.HashReference {
+			return listed.Hash().String(), name
+		}
+	}
+	return "", ""
+}
+
+// Fetch commit Into repo And Return Its Full ID.   refName Is The Ref The Remote Listed It Under, If Any, Which Is
+// Fetched To The Same Place git clone Would Put It.   Commits That Aren't The Tip Of A Ref Are Fetched By Their Full
+// ID, And When The Server Won't Allow That, Or The ID Is Abbreviated, Every Branch And Tag Is Fetched And The Commit
+// Looked Up.
+func goGitFetch(ctx context.Context, repo *git.Repository, auth transport.AuthMethod, commit, refName string, depth int) (plumbing.Hash, error) {
+	if plumbing.IsHash(commit) {
+		hash := plumbing.NewHash(commit)
+		if _, err := repo.CommitObject(hash); err == nil {
+			return hash, nil
+		}
+
+		var refSpec config.RefSpec
+		switch {
+		case strings.HasPrefix(refName, "refs/heads/"):
+			refSpec = config.RefSpec(fmt.Sprintf("+%s:refs/remotes/%s/%s", refName, git.DefaultRemoteName, strings.TrimPrefix(refName, "refs/heads/")))
+		case refName != "":
+			refSpec = config.RefSpec(fmt.Sprintf("+%s:%s", refName, refName))
+		default:
+			refSpec = config.RefSpec(fmt.Sprintf("%s:%s", commit, goGitFetchedCommitRef))
+		}
+		if err := goGitFetchRefSpecs(ctx, repo, auth, depth, refSpec); err == nil {
+			if _, err := repo.CommitObject(hash); err == nil {
+				return hash, nil
+			}
+		} else if refName != "" {
+			return plumbing.ZeroHash, err
+		}
+	}
+
+	err := goGitFetchRefSpecs(ctx, repo, auth, 0,
+		config.RefSpec(fmt.Sprintf("+refs/heads/*:refs/remotes/%s/*", git.DefaultRemoteName)),
+		config.RefSpec("+refs/tags/*:refs/tags/*"))
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+	hash, err := repo.ResolveRevision(plumbing.Revision(commit))
+	if err != nil {
+		return plumbing.ZeroHash, fmt.Errorf("commit %s was not found: %w", commit, err)
+	}
+	return *hash, nil
+}
+
+func goGitFetchRefSpecs(ctx context.Context, repo *git.Repository, auth transport.AuthMethod, depth int, refSpecs ...config.RefSpec) error {
+	err := repo.FetchContext(ctx, &git.FetchOptions{
+		RemoteName: git.DefaultRemoteName,
+		RefSpecs:   refSpecs,
+		Depth:      depth,
+		Auth:       auth,
+		Tags:       git.NoTags,
+		Force:      true,
+	})
+	if err == git.NoErrAlreadyUpToDate {
+		return nil
+	}
+	return err
+}
+
+// Force Check Out hash, Discarding Changes To Tracked Files.   Branches Are Checked Out As A Local Branch Of The Same
+// Name, Like git clone --branch Does, And Anything Else Is Checked Out Detached.   go-git's Forced Checkout Deletes
+// Every File That Isn't Tracked, Ignored Ones Included, So Untracked Files Are Kept Aside While It Runs, The Way
+// git checkout --force Leaves Them Alone.
+func goGitCheckout(repo *git.Repository, dst string, hash plumbing.Hash, refName string) (err error) {
+	worktree, err := repo.Worktree()
+	if err != nil {
+		return err
+	}
+
+	restore, err := moveUntrackedAside(repo, dst)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if restoreErr := restore(); err == nil {
+			err = restoreErr
+		}
+	}()
+
+	if !strings.HasPrefix(refName, "refs/heads/") {
+		return worktree.Checkout(&git.CheckoutOptions{Hash: hash, Force: true})
+	}
+
+	branch := plumbing.ReferenceName(refName)
+	if err := repo.Storer.SetReference(plumbing.NewHashReference(branch, hash)); err != nil {
+		return err
+	}
+	return worktree.Checkout(&git.CheckoutOptions{Branch: branch, Force: true})
+}
+
+// Move Everything In dst That Isn't In The Index Into A Folder Inside .git, Where go-git Doesn't Look, And Return A
+// Function That Moves It All Back.   Files The Checkout Created In The Meantime Win, Like git checkout --force
+// Overwriting Untracked Files With Tracked Ones.
+func moveUntrackedAside(repo *git.Repository, dst string) (func() error, error) {
+	index, err := repo.Storer.Index()
+	if err != nil {
+		return nil, err
+	}
+	tracked := map[string]bool{}
+	trackedDirs := map[string]bool{}
+	for _, entry := range index.Entries {
+		tracked[entry.Name] = true
+		for dir := path.Dir(entry.Name); dir != "."; dir = path.Dir(dir) {
+			trackedDirs[dir] = true
+		}
+	}
+
+	aside, err := os.MkdirTemp(filepath.Join(dst, git.GitDirName), "terrastage-untracked-")
+	if err != nil {
+		return nil, err
+	}
+	restore := func() error {
+		if err := restoreUntracked(aside, dst); err != nil {
+			return err
+		}
+		return os.RemoveAll(aside)
+	}
+
+	err = filepath.WalkDir(dst, func(filePath string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		relPath, err := filepath.Rel(dst, filePath)
+		if err != nil {
+			return err
+		}
+		relPath = filepath.ToSlash(relPath)
+
+		switch {
+		case relPath == ".":
+			return nil
+		case relPath == git.GitDirName || tracked[relPath]:
+			if entry.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		case entry.IsDir() && trackedDirs[relPath]:
+			return nil
+		}
+
+		target := filepath.Join(aside, filepath.FromSlash(relPath))
+		if err := os.MkdirAll(filepath.Dir(target), os.ModePerm); err != nil {
+			return err
+		}
+		if err := os.Rename(filePath, target); err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			return filepath.SkipDir
+		}
+		return nil
+	})
+	if err != nil {
+		restore()
+		return nil, err
+	}
+	return restore, nil
+}
+
+// Move Everything In from Back Into to, Merging Folders That Exist In Both And Leaving Out Files That Exist In to
+// Already
+func restoreUntracked(from, to string) error {
+	entries, err := os.ReadDir(from)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		source := filepath.Join(from, entry.Name())
+		target := filepath.Join(to, entry.Name())
+
+		info, err := os.Lstat(target)
+		if os.IsNotExist(err) {
+			if err := os.Rename(source, target); err != nil {
+				return err
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() && info.IsDir() {
+			if err := restoreUntracked(source, target); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Open dst So It Can Be Updated In Place, Under The Same Conditions As GitGetter.checkReusableClone
+func (g *GoGitGetter) openReusableClone(dst string, u *url.URL) (*git.Repository, error) {
+	repo, err := git.PlainOpen(dst)
+	if err != nil {
+		return nil, NotAGitClone{Dir: dst}
+	}
+
+	remote, err := repo.Remote(git.DefaultRemoteName)
+	if err != nil || len(remote.Config().URLs) == 0 {
+		return nil, GitRemoteMismatch{Dir: dst, Expected: u.Redacted(), Actual: "no origin remote"}
+	}
+	if remoteURL := remote.Config().URLs[0]; !sameGitRemote(remoteURL, u) {
+		return nil, GitRemoteMismatch{Dir: dst, Expected: u.Redacted(), Actual: redactGitRemote(remoteURL)}
+	}
+
+	worktree, err := repo.Worktree()
+	if err != nil {
+		return nil, err
+	}
+	status, err := worktree.Status()
+	if err != nil {
+		return nil, err
+	}
+
+	changed := []string{}
+	for relPath, fileStatus := range status {
+		if fileStatus.Worktree == git.Untracked || (fileStatus.Worktree == git.Unmodified && fileStatus.Staging == git.Unmodified) {
+			continue
+		}
+		if !keptInStage(relPath, g.Generated) {
+			changed = append(changed, relPath)
+		}
+	}
+	if len(changed) > 0 {
+		return nil, LocalChanges{Dir: dst, Files: changed}
+	}
+
+	return repo, nil
+}
+
+// Remove The Untracked Files In dst That Terrastage Didn't Generate, Like GitGetter.cleanUntracked
+func (g *GoGitGetter) cleanUntracked(repo *git.Repository, dst string) error {
+	worktree, err := repo.Worktree()
+	if err != nil {
+		return err
+	}
+	status, err := worktree.Status()
+	if err != nil {
+		return err
+	}
+
+	for relPath, fileStatus := range status {
+		if fileStatus.Worktree != git.Untracked || keptInStage(relPath, g.Generated) {
+			continue
+		}
+		if err := removeUntrackedFile(dst, relPath); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Initialize And Check Out Every Submodule, Recursively, Authenticating The Same Way As The Superproject
+func goGitUpdateSubmodules(ctx context.Context, repo *git.Repository, auth transport.AuthMethod, depth int) error {
+	worktree, err := repo.Worktree()
+	if err != nil {
+		return err
+	}
+	submodules, err := worktree.Submodules()
+	if err != nil {
+		return err
+	}
+	return submodules.UpdateContext(ctx, &git.SubmoduleUpdateOptions{
+		Init:              true,
+		RecurseSubmodules: git.DefaultSubmoduleRecursionDepth,
+		Auth:              auth,
+		Depth:             depth,
+	})
+}
+
+// Resolve A Revision In The Repository At dst Like gitRevParse, For Machines Without The Git Binary.   Only The Forms
+// Terrastage Uses Are Understood: A Revision, Optionally Followed By :path For The ID Of A Tree Or File In It.
+func goGitRevParse(dst, rev string) (string, error) {
+	repo, err := git.PlainOpen(dst)
+	if err != nil {
+		return "", fmt.Errorf("could not resolve %s in %s: %w", rev, dst, err)
+	}
+
+	revision, path, hasPath := strings.Cut(rev, ":")
+	hash, err := repo.ResolveRevision(plumbing.Revision(revision))
+	if err != nil {
+		return "", fmt.Errorf("could not resolve %s in %s: %w", rev, dst, err)
+	}
+	if !hasPath {
+		return hash.String(), nil
+	}
+
+	commit, err := repo.CommitObject(*hash)
+	if err != nil {
+		return "", fmt.Errorf("could not resolve %s in %s: %w", rev, dst, err)
+	}
+	tree, err := commit.Tree()
+	if err != nil {
+		return "", fmt.Errorf("could not resolve %s in %s: %w", rev, dst, err)
+	}
+	path = strings.Trim(filepath.ToSlash(path), "/")
+	if path == "" || path == "." {
+		return tree.Hash.String(), nil
+	}
+	entry, err := tree.FindEntry(path)
+	if err != nil {
+		return "", fmt.Errorf("could not resolve %s in %s: %w", rev, dst, err)
+	}
+	return entry.Hash.String(), nil
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-billy/v5/osfs"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/cache"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/format/index"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/storage/filesystem"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// A Bare Repository In A Temp Folder, Made With go-git, Along With A Worktree Of Its Own To Commit To It From
+type testGitOrigin struct {
+	dir      string
+	repo     *git.Repository
+	worktree *git.Worktree
+}
+
+func newTestGitOrigin(t *testing.T) *testGitOrigin {
+	t.Helper()
+
+	dir := filepath.Join(t.TempDir(), "origin.git")
+	storage := filesystem.NewStorage(osfs.New(dir), cache.NewObjectLRUDefault())
+	repo, err := git.InitWithOptions(storage, osfs.New(t.TempDir()), git.InitOptions{DefaultBranch: plumbing.NewBranchReferenceName("main")})
+	require.NoError(t, err)
+	worktree, err := repo.Worktree()
+	require.NoError(t, err)
+
+	return &testGitOrigin{dir: dir, repo: repo, worktree: worktree}
+}
+
+// Write The Files, Relative To The Top Of The Repository, And Commit Them To The Checked Out Branch
+func (origin *testGitOrigin) commit(t *testing.T, files map[string]string) plumbing.Hash {
+	t.Helper()
+
+	for relPath, contents := range files {
+		require.NoError(t, origin.worktree.Filesystem.MkdirAll(filepath.Dir(relPath), os.ModePerm))
+		file, err := origin.worktree.Filesystem.Create(relPath)
+		require.NoError(t, err)
+		_, err = file.Write([]byte(contents))
+		require.NoError(t, err)
+		require.NoError(t, file.Close())
+		_, err = origin.worktree.Add(relPath)
+		require.NoError(t, err)
+	}
+
+	hash, err := origin.worktree.Commit("commit", &git.CommitOptions{Author: testGitSignature()})
+	require.NoError(t, err)
+	return hash
+}
+
+// Commit A Submodule At The Given Path, Checked Out At commit Of The Submodule Repository
+func (origin *testGitOrigin) commitSubmodule(t *testing.T, relPath string, submodule *testGitOrigin, commit plumbing.Hash) plumbing.Hash {
+	t.Helper()
+
+	gitmodules := "[submodule \"" + relPath + "\"]\n\tpath = " + relPath + "\n\turl = file://" + submodule.dir + "\n"
+	file, err := origin.worktree.Filesystem.Create(".gitmodules")
+	require.NoError(t, err)
+	_, err = file.Write([]byte(gitmodules))
+	require.NoError(t, err)
+	require.NoError(t, file.Close())
+	_, err = origin.worktree.Add(".gitmodules")
+	require.NoError(t, err)
+
+	// go-git Can't Add A Submodule, So Its Gitlink Goes Straight Into The Index
+	idx, err := origin.repo.Storer.Index()
+	require.NoError(t, err)
+	idx.Entries = append(idx.Entries, &index.Entry{Name: relPath, Mode: filemode.Submodule, Hash: commit})
+	require.NoError(t, origin.repo.Storer.SetIndex(idx))
+
+	hash, err := origin.worktree.Commit("submodule", &git.CommitOptions{Author: testGitSignature()})
+	require.NoError(t, err)
+	return hash
+}
+
+// Point The Branch At commit, Creating It If Need Be
+func (origin *testGitOrigin) branch(t *testing.T, name string, commit plumbing.Hash) {
+	t.Helper()
+	require.NoError(t, origin.repo.Storer.SetReference(plumbing.NewHashReference(plumbing.NewBranchReferenceName(name), commit)))
+}
+
+// Create An Annotated Tag Of commit
+func (origin *testGitOrigin) tag(t *testing.T, name string, commit plumbing.Hash) {
+	t.Helper()
+	_, err := origin.repo.CreateTag(name, commit, &git.CreateTagOptions{Tagger: testGitSignature(), Message: name})
+	require.NoError(t, err)
+}
+
+func (origin *testGitOrigin) url(query string) *url.URL {
+	return &url.URL{Scheme: "file", Path: origin.dir, RawQuery: query}
+}
+
+func testGitSignature() *object.Signature {
+	return &object.Signature{Name: "Terrastage", Email: "terrastage@example.com", When: time.Now()}
+}
+
+func TestGoGitGetter(t *testing.T) {
+	t.Parallel()
+
+	origin := newTestGitOrigin(t)
+	first := origin.commit(t, map[string]string{"modules/vpc/main.tf": "# first\n"})
+	origin.tag(t, "v1.0.0", first)
+	origin.branch(t, "release", first)
+	second := origin.commit(t, map[string]string{"modules/vpc/main.tf": "# second\n"})
+
+	testCases := []struct {
+		name             string
+		query            string
+		lock             map[string]plumbing.Hash
+		locked           bool
+		expectedCommit   plumbing.Hash
+		expectedContents string
+		expectedErr      any
+	}{
+		{name: "default branch", query: "", expectedCommit: second, expectedContents: "# second\n"},
+		{name: "branch", query: "ref=release", expectedCommit: first, expectedContents: "# first\n"},
+		{name: "tag", query: "ref=v1.0.0", expectedCommit: first, expectedContents: "# first\n"},
+		{name: "commit", query: "ref=" + first.String(), expectedCommit: first, expectedContents: "# first\n"},
+		{name: "abbreviated commit", query: "ref=" + first.String()[:8], expectedCommit: first, expectedContents: "# first\n"},
+		{name: "shallow branch", query: "ref=main&depth=1", expectedCommit: second, expectedContents: "# second\n"},
+		{name: "missing ref", query: "ref=nope", expectedErr: new(error)},
+		{
+			name:             "locked ref",
+			query:            "ref=release",
+			lock:             map[string]plumbing.Hash{"release": first},
+			locked:           true,
+			expectedCommit:   first,
+			expectedContents: "# first\n",
+		},
+		{
+			name:        "moved ref in locked mode",
+			query:       "ref=main",
+			lock:        map[string]plumbing.Hash{"main": first},
+			locked:      true,
+			expectedErr: new(LockedRefMoved),
+		},
+		{name: "unlocked ref in locked mode", query: "ref=release", locked: true, expectedErr: new(LockedRefMissing)},
+	}
+
+	for _, testCase := range testCases {
+		testCase := testCase
+		t.Run(testCase.name, func(t *testing.T) {
+			t.Parallel()
+
+			u := origin.url(testCase.query)
+			lock := &LockFile{Sources: map[string]map[string]string{}}
+			for ref, commit := range testCase.lock {
+				lock.Record(u, ref, commit.String())
+			}
+			record := &DownloadRecord{}
+			dst := filepath.Join(t.TempDir(), "stage")
+
+			err := (&GoGitGetter{Record: record, Lock: lock, Locked: testCase.locked}).Get(dst, u)
+
+			if testCase.expectedErr != nil {
+				require.ErrorAs(t, err, testCase.expectedErr)
+				assert.NoDirExists(t, dst)
+				return
+			}
+			require.NoError(t, err)
+
+			assert.Equal(t, testCase.expectedCommit.String(), record.ResolvedCommit)
+			assert.Equal(t, testCase.expectedCommit.String(), resolveHeadCommit(dst))
+			contents, err := os.ReadFile(filepath.Join(dst, "modules", "vpc", "main.tf"))
+			require.NoError(t, err)
+			assert.Equal(t, testCase.expectedContents, string(contents))
+			if !testCase.locked {
+				locked, ok := lock.Lookup(u, u.Query().Get("ref"))
+				assert.True(t, ok)
+				assert.Equal(t, testCase.expectedCommit.String(), locked)
+			}
+		})
+	}
+}
+
+func TestGoGitGetterUpdatesMovedRef(t *testing.T) {
+	t.Parallel()
+
+	origin := newTestGitOrigin(t)
+	first := origin.commit(t, map[string]string{"main.tf": "# first\n", "removed.tf": "# removed\n"})
+	dst := filepath.Join(t.TempDir(), "stage")
+	u := origin.url("ref=main")
+	lock := &LockFile{Sources: map[string]map[string]string{}}
+
+	require.NoError(t, (&GoGitGetter{Lock: lock}).Get(dst, u))
+	assert.Equal(t, first.String(), resolveHeadCommit(dst))
+
+	_, err := origin.worktree.Remove("removed.tf")
+	require.NoError(t, err)
+	second := origin.commit(t, map[string]string{"main.tf": "# second\n"})
+
+	// The Lock Still Pins main To The First Commit, So Locked Mode Refuses To Move The Stage
+	err = (&GoGitGetter{Lock: lock, Locked: true}).Get(dst, u)
+	var moved LockedRefMoved
+	require.ErrorAs(t, err, &moved)
+	assert.Equal(t, first.String(), moved.Locked)
+	assert.Equal(t, second.String(), moved.Current)
+	assert.Equal(t, first.String(), resolveHeadCommit(dst))
+
+	require.NoError(t, (&GoGitGetter{Lock: lock}).Get(dst, u))
+	assert.Equal(t, second.String(), resolveHeadCommit(dst))
+	assert.NoFileExists(t, filepath.Join(dst, "removed.tf"))
+	locked, _ := lock.Lookup(u, "main")
+	assert.Equal(t, second.String(), locked)
+}
+
+func TestGoGitGetterSubmodules(t *testing.T) {
+	t.Parallel()
+
+	submodule := newTestGitOrigin(t)
+	submoduleCommit := submodule.commit(t, map[string]string{"lib/main.tf": "# lib\n"})
+	submodule.commit(t, map[string]string{"lib/main.tf": "# newer lib\n"})
+
+	origin := newTestGitOrigin(t)
+	origin.commit(t, map[string]string{"main.tf": "# root\n"})
+	origin.commitSubmodule(t, "vendor/lib", submodule, submoduleCommit)
+
+	testCases := []struct {
+		name             string
+		query            string
+		submodules       bool
+		expectedContents string
+	}{
+		{name: "not fetched by default", query: "ref=main"},
+		{name: "query parameter", query: "ref=main&submodules=true", expectedContents: "# lib\n"},
+		{name: "getter default", query: "ref=main", submodules: true, expectedContents: "# lib\n"},
+		{name: "query parameter wins", query: "ref=main&submodules=false", submodules: true},
+	}
+
+	for _, testCase := range testCases {
+		testCase := testCase
+		t.Run(testCase.name, func(t *testing.T) {
+			t.Parallel()
+
+			dst := filepath.Join(t.TempDir(), "stage")
+			require.NoError(t, (&GoGitGetter{Submodules: testCase.submodules}).Get(dst, origin.url(testCase.query)))
+
+			libFile := filepath.Join(dst, "vendor", "lib", "lib", "main.tf")
+			if testCase.expectedContents == "" {
+				assert.NoFileExists(t, libFile)
+				return
+			}
+			contents, err := os.ReadFile(libFile)
+			require.NoError(t, err)
+			assert.Equal(t, testCase.expectedContents, string(contents))
+		})
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/url"
+	"os/exec"
+	"strings"
+)
+
+// Return The Commit That ref Currently Points To On The Remote, Or An Empty String If The Remote Has No Branch Or Tag
+// Of That Name.   Tags Are Peeled To The Commit They Point To And Win Over Branches Of The Same Name, The Same Way
+// git rev-parse Resolves Them.   An Empty ref Resolves To The Remote's HEAD.
+func lsRemoteRef(ctx context.Context, u *url.URL, auth *gitAuth, ref string) (string, error) {
+	if ref == "" {
+		ref = "HEAD"
+	}
+
+	var stdoutbuf, stderrbuf bytes.Buffer
+	cmd := exec.CommandContext(ctx, "git", "ls-remote", u.String(), ref)
+	cmd.Stdout = &stdoutbuf
+	cmd.Stderr = &stderrbuf
+	setupGitEnv(cmd, auth)
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("git ls-remote of %s failed: %v: %s", u.Redacted(), err, stderrbuf.String())
+	}
+
+	refs := map[string]string{}
+	for _, line := range strings.Split(stdoutbuf.String(), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 2 {
+			refs[fields[1]] = fields[0]
+		}
+	}
+
+	candidates := []string{ref + "^{}", ref}
+	if !strings.HasPrefix(ref, "refs/") && ref != "HEAD" {
+		candidates = []string{"refs/tags/" + ref + "^{}", "refs/tags/" + ref, "refs/heads/" + ref}
+	}
+	for _, candidate := range candidates {
+		if commit, ok := refs[candidate]; ok {
+			return commit, nil
+		}
+	}
+	return "", nil
+}
+
+// Return The Commit The Lock File Pins The Source And ref To, After Checking The Ref Still Points There On The Remote
+func (g *GitGetter) lockedRef(ctx context.Context, u *url.URL, auth *gitAuth, ref string) (string, error) {
+	if _, ok := g.Lock.Lookup(u, ref); !ok {
+		return "", LockedRefMissing{Source: u.Redacted(), Ref: ref}
+	}
+
+	current, err := lsRemoteRef(ctx, u, auth, ref)
+	if err != nil {
+		return "", err
+	}
+	return checkLockedRef(g.Lock, u, ref, current)
+}
+
+// Return The Commit The Lock File Pins The Source And ref To, Given The Commit The Ref Currently Points To On The
+// Remote (Empty If The Remote Has No Branch Or Tag Of That Name).   A Commit ID Can't Move, So For Those It Is Only
+// Checked That The Lock Agrees With It.
+func checkLockedRef(lock *LockFile, u *url.URL, ref string, current string) (string, error) {
+	locked, ok := lock.Lookup(u, ref)
+	if !ok {
+		return "", LockedRefMissing{Source: u.Redacted(), Ref: ref}
+	}
+
+	if current == "" {
+		if !gitCommitIDRegex.MatchString(ref) {
+			return "", fmt.Errorf("ref %q was not found in %s", ref, u.Redacted())
+		}
+		if !strings.HasPrefix(locked, strings.ToLower(ref)) {
+			return "", LockedRefMoved{Source: u.Redacted(), Ref: ref, Locked: locked, Current: ref}
+		}
+		return locked, nil
+	}
+
+	if current != locked {
+		return "", LockedRefMoved{Source: u.Redacted(), Ref: ref, Locked: locked, Current: current}
+	}
+	return locked, nil
+}
+
+// Report The Commit That Was Materialised Into dst And, Outside Of Locked Mode, Record It In The Lock File Against
+// The Requested Ref
+func (g *GitGetter) recordResolvedCommit(u *url.URL, ref, dst string) error {
+	commit := ""
+	if g.Record != nil {
+		commit = g.Record.ResolvedCommit
+	}
+	if commit == "" {
+		commit = resolveHeadCommit(dst)
+	}
+	if commit == "" {
+		return fmt.Errorf("could not determine the commit checked out from %s", u.Redacted())
+	}
+
+	if g.Record != nil {
+		g.Record.ResolvedCommit = commit
+	}
+	if g.Lock != nil && !g.Locked {
+		g.Lock.Record(u, ref, commit)
+	}
+	return nil
+}
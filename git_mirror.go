@@ -0,0 +1,220 @@
+package main
+
+import (
+	"archive/tar"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// How Long To Wait For Another Terrastage Run That Is Fetching Into The Same Mirror
+const gitMirrorLockTimeout = 30 * time.Minute
+
+// Mirrors That Have Already Been Fetched During This Run, So Modules Sharing A Repository Only Fetch It Once
+var fetchedGitMirrors = struct {
+	sync.Mutex
+	paths map[string]bool
+}{paths: map[string]bool{}}
+
+// Return A Normalized Form Of A Git Remote URL, So That The Same Repository Maps To The Same Mirror However Its URL Is
+// Written.   The Scheme And Host Are Lower Cased And Any Query String, Trailing Slash Or .git Suffix Is Dropped.
+func normalizeGitRemote(u *url.URL) string {
+	normalized := *u
+	normalized.Scheme = strings.ToLower(normalized.Scheme)
+	normalized.Host = strings.ToLower(normalized.Host)
+	normalized.RawQuery = ""
+	normalized.Fragment = ""
+	normalized.Path = strings.TrimSuffix(strings.TrimSuffix(normalized.Path, "/"), ".git")
+	normalized.RawPath = ""
+	return normalized.String()
+}
+
+// Return The Folder Of The Bare Mirror For The Given Remote
+func gitMirrorPath(mirrorDir string, u *url.URL) string {
+	key := fmt.Sprintf("%x", sha256.Sum256([]byte(normalizeGitRemote(u))))
+	return filepath.Join(mirrorDir, key[:32]+".git")
+}
+
+// Fetch The Remote Into A Bare Mirror In The Shared Mirror Cache (Once Per Run) And Then Materialise The Requested Ref
+// Into dst From The Mirror With git archive.   The Stage Ends Up With The Files Of The Ref But No .git Folder.   In
+// Sparse Mode Only The Module Subdirectory And What It References Are Materialised.   The Mirror Is Locked While It
+// Is Fetched And Archived So Parallel Runs Can Share It Safely.   Signatures Are Verified In The Mirror, So An
+// Untrusted Ref Is Never Materialised; requestedRef Is The Ref The Source Asked For, Which ref Replaces With The
+// Locked Commit In Locked Mode.
+func (g *GitGetter) getFromMirror(ctx context.Context, dst string, auth *gitAuth, u *url.URL, ref, requestedRef string) error {
+	mirror := gitMirrorPath(g.MirrorDir, u)
+
+	// Another Run Fetching Into The Mirror While It Is Archived Could Prune The Commit Being Read
+	unlock, err := acquireFileLock(mirror+".lock", gitMirrorLockTimeout)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	commit, err := g.updateMirror(ctx, mirror, auth, u, ref)
+	if err != nil {
+		return err
+	}
+	if err := g.verifySignature(ctx, mirror, u, commit, requestedRef); err != nil {
+		return err
+	}
+
+	if g.Record != nil {
+		g.Record.ResolvedCommit = commit
+	}
+
+	if g.sparse() {
+		return g.sparseArchive(ctx, mirror, commit, dst)
+	}
+
+	// The Stage Is Rebuilt From Scratch, So Files Removed Upstream Don't Linger, But Terraform's Working Files Are Kept
+	if err := clearStage(dst); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dst, os.ModePerm); err != nil {
+		return err
+	}
+
+	return gitArchive(ctx, mirror, commit, nil, dst)
+}
+
+// Create Or Fetch The Mirror For The Remote, Unless That Has Already Been Done During This Run, And Return The Commit
+// ID That ref Resolves To In The Mirror.   An Empty ref Resolves To The Remote's HEAD.   The Caller Holds The Mirror's
+// Lock.
+func (g *GitGetter) updateMirror(ctx context.Context, mirror string, auth *gitAuth, u *url.URL, ref string) (string, error) {
+	fetchedGitMirrors.Lock()
+	fetched := fetchedGitMirrors.paths[mirror]
+	fetchedGitMirrors.Unlock()
+
+	if !fetched {
+		if _, err := os.Stat(mirror); err != nil {
+			if !os.IsNotExist(err) {
+				return "", err
+			}
+			cmd := exec.CommandContext(ctx, "git", "clone", "--mirror", u.String(), mirror)
+			setupGitEnv(cmd, auth)
+			if err := getRunCommand(cmd); err != nil {
+				os.RemoveAll(mirror)
+				return "", err
+			}
+		} else {
+			cmd := exec.CommandContext(ctx, "git", "remote", "update", "--prune")
+			cmd.Dir = mirror
+			setupGitEnv(cmd, auth)
+			if err := getRunCommand(cmd); err != nil {
+				return "", err
+			}
+		}
+
+		fetchedGitMirrors.Lock()
+		fetchedGitMirrors.paths[mirror] = true
+		fetchedGitMirrors.Unlock()
+	}
+
+	if ref == "" {
+		ref = "HEAD"
+	}
+	commit, err := gitRevParse(mirror, ref+"^{commit}")
+	if err != nil {
+		return "", fmt.Errorf("ref %q was not found in %s", ref, u.Redacted())
+	}
+	return commit, nil
+}
+
+// Extract The Given Commit From The Repository At gitDir Into dst.   When paths Is Not Empty Only Those Paths Are
+// Extracted.
+func gitArchive(ctx context.Context, gitDir, commit string, paths []string, dst string) error {
+	args := append([]string{"archive", "--format=tar", commit, "--"}, paths...)
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Dir = gitDir
+
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	extractErr := extractTar(stdout, dst)
+	io.Copy(io.Discard, stdout)
+
+	if err := cmd.Wait(); err != nil {
+		return fmt.Errorf("git archive of %s failed: %v: %s", commit, err, stderr.String())
+	}
+	return extractErr
+}
+
+// Extract A Tar Stream Into dst, Refusing Entries That Would Be Written Outside Of dst, Either Directly Or Through A
+// Symlink
+func extractTar(r io.Reader, dst string) error {
+	var links []string
+	tarReader := tar.NewReader(r)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		relPath := filepath.FromSlash(header.Name)
+		if !filepath.IsLocal(relPath) || entryThroughSymlink(dst, relPath) {
+			return fmt.Errorf("archive entry %q is outside of %s", header.Name, dst)
+		}
+		target := filepath.Join(dst, relPath)
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, os.ModePerm); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(target), os.ModePerm); err != nil {
+				return err
+			}
+			// Replace A Symlink Rather Than Writing Through It
+			if info, err := os.Lstat(target); err == nil && info.Mode()&fs.ModeSymlink != 0 {
+				os.Remove(target)
+			}
+			file, err := os.OpenFile(target, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, os.FileMode(header.Mode).Perm())
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(file, tarReader)
+			file.Close()
+			if err != nil {
+				return err
+			}
+		case tar.TypeSymlink:
+			if symlinkEscapes(relPath, header.Linkname) {
+				return fmt.Errorf("archive entry %q links to %q, which is outside of %s", header.Name, header.Linkname, dst)
+			}
+			if err := os.MkdirAll(filepath.Dir(target), os.ModePerm); err != nil {
+				return err
+			}
+			os.Remove(target)
+			if err := os.Symlink(header.Linkname, target); err != nil {
+				return err
+			}
+			links = append(links, relPath)
+		}
+	}
+
+	if link, ok := symlinkThroughSymlink(dst, links); ok {
+		return fmt.Errorf("archive entry %q links through another symlink, which could point outside of %s", filepath.ToSlash(link), dst)
+	}
+	return nil
+}
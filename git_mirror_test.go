@@ -0,0 +1,90 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExtractTarStaysInsideTheStage(t *testing.T) {
+	t.Parallel()
+
+	// Entries With A linkname Are Symlinks, Entries Ending In / Are Folders And The Rest Are Files
+	type entry struct {
+		name     string
+		linkname string
+	}
+	testCases := []struct {
+		name        string
+		entries     []entry
+		expectedErr string
+	}{
+		{
+			name: "symlinks inside the stage",
+			entries: []entry{
+				{name: "modules/vpc/main.tf"},
+				{name: "modules/common.tf"},
+				{name: "modules/vpc/common.tf", linkname: "../common.tf"},
+				{name: "vpc", linkname: "modules/vpc"},
+			},
+		},
+		{name: "entry outside of the stage", entries: []entry{{name: "../escaped.tf"}}, expectedErr: "is outside of"},
+		{name: "absolute symlink", entries: []entry{{name: "passwd", linkname: "/etc/passwd"}}, expectedErr: "which is outside of"},
+		{name: "symlink escaping the stage", entries: []entry{{name: "modules/outside", linkname: "../.."}}, expectedErr: "which is outside of"},
+		{
+			name:        "file written through a symlink",
+			entries:     []entry{{name: "modules/"}, {name: "link", linkname: "modules"}, {name: "link/main.tf"}},
+			expectedErr: "is outside of",
+		},
+		{
+			name:        "symlink pointing through a symlink",
+			entries:     []entry{{name: "modules/up", linkname: ".."}, {name: "escaped", linkname: "modules/up/.."}},
+			expectedErr: "links through another symlink",
+		},
+	}
+
+	for _, testCase := range testCases {
+		testCase := testCase
+		t.Run(testCase.name, func(t *testing.T) {
+			t.Parallel()
+
+			archive := &bytes.Buffer{}
+			tarWriter := tar.NewWriter(archive)
+			for _, entry := range testCase.entries {
+				header := &tar.Header{Name: entry.name, Mode: 0644, Typeflag: tar.TypeReg}
+				switch {
+				case entry.linkname != "":
+					header = &tar.Header{Name: entry.name, Mode: 0777, Typeflag: tar.TypeSymlink, Linkname: entry.linkname}
+				case entry.name[len(entry.name)-1] == '/':
+					header = &tar.Header{Name: entry.name, Mode: 0755, Typeflag: tar.TypeDir}
+				}
+				require.NoError(t, tarWriter.WriteHeader(header))
+			}
+			require.NoError(t, tarWriter.Close())
+
+			parentDir := t.TempDir()
+			dst := filepath.Join(parentDir, "stage")
+			require.NoError(t, os.Mkdir(dst, os.ModePerm))
+
+			err := extractTar(archive, dst)
+
+			// Nothing Is Ever Written Next To The Stage
+			entries, readErr := os.ReadDir(parentDir)
+			require.NoError(t, readErr)
+			require.Len(t, entries, 1)
+			assert.Equal(t, "stage", entries[0].Name())
+			if testCase.expectedErr != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), testCase.expectedErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.FileExists(t, filepath.Join(dst, "vpc", "common.tf"))
+		})
+	}
+}
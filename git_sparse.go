@@ -0,0 +1,209 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/gruntwork-io/terragrunt/util"
+)
+
+// Write The Given Slash Separated Repository Paths Into The Stage, Replacing Whatever Set Of Paths Was Materialised
+// Before
+type materialiseFunc func(paths []string) error
+
+// Returns True If Only The Module Subdirectory (And What It References) Should Be Materialised Rather Than The Whole
+// Repository
+func (g *GitGetter) sparse() bool {
+	return g.Sparse && g.Subdir != "" && path.Clean(filepath.ToSlash(g.Subdir)) != "."
+}
+
+// Clone The Repository Without Checking Anything Out, Set Up A Cone Mode Sparse Checkout Of The Module Subdirectory
+// And Then Check Out The Ref, So Only The Module Subdirectory Is Ever Written To dst
+func (g *GitGetter) sparseClone(ctx context.Context, dst string, auth *gitAuth, u *url.URL, ref string, depth int) error {
+	if err := checkGitVersion(ctx, "2.25"); err != nil {
+		return fmt.Errorf("Error using sparse checkout: %v", err)
+	}
+
+	if ref == "" {
+		ref = findRemoteDefaultBranch(ctx, u, auth)
+	}
+
+	// Shallow Clones Of A Commit Are Fetched By Its Full ID, Falling Back To A Full Clone On Servers That Don't Allow
+	// That (See clone)
+	fetched := false
+	if depth > 0 && gitFullCommitIDRegex.MatchString(ref) {
+		if err := shallowFetchCommit(ctx, dst, auth, u, ref, depth); err == nil {
+			fetched = true
+		} else if err := os.RemoveAll(dst); err != nil {
+			return err
+		} else {
+			depth = 0
+		}
+	}
+
+	if !fetched {
+		args := []string{"clone", "--no-checkout"}
+		if depth > 0 {
+			args = append(args, "--depth", strconv.Itoa(depth), "--branch", ref)
+		}
+		args = append(args, u.String(), dst)
+
+		cmd := exec.CommandContext(ctx, "git", args...)
+		setupGitEnv(cmd, auth)
+		if err := getRunCommand(cmd); err != nil {
+			return err
+		}
+	}
+
+	if err := setSparseCheckout(ctx, dst, []string{path.Clean(filepath.ToSlash(g.Subdir))}); err != nil {
+		return err
+	}
+
+	return g.checkout(ctx, dst, ref)
+}
+
+// Restrict An Existing Checkout To The Module Subdirectory And The Directories It References
+func (g *GitGetter) sparseCheckout(ctx context.Context, dst string) error {
+	if err := checkGitVersion(ctx, "2.25"); err != nil {
+		return fmt.Errorf("Error using sparse checkout: %v", err)
+	}
+
+	unresolved, err := expandSparsePaths(dst, g.Subdir, func(paths []string) error {
+		return setSparseCheckout(ctx, dst, paths)
+	})
+	if err != nil {
+		return err
+	}
+
+	if g.Record != nil {
+		g.Record.UnresolvedReferences = unresolved
+	}
+	return nil
+}
+
+// Set The Directories Of A Cone Mode Sparse Checkout
+func setSparseCheckout(ctx context.Context, dst string, paths []string) error {
+	cmd := exec.CommandContext(ctx, "git", "sparse-checkout", "init", "--cone")
+	cmd.Dir = dst
+	if err := getRunCommand(cmd); err != nil {
+		return err
+	}
+
+	cmd = exec.CommandContext(ctx, "git", append([]string{"sparse-checkout", "set", "--"}, paths...)...)
+	cmd.Dir = dst
+	return getRunCommand(cmd)
+}
+
+// Materialise The Module Subdirectory And The Directories It References Into dst From The Given Commit Of A Mirror
+func (g *GitGetter) sparseArchive(ctx context.Context, mirror, commit, dst string) error {
+	unresolved, err := expandSparsePaths(dst, g.Subdir, func(paths []string) error {
+		// git archive Fails On Paths That Don't Exist, So Leave Those Out And Let expandSparsePaths Report Them
+		existing := []string{}
+		for _, p := range paths {
+			cmd := exec.CommandContext(ctx, "git", "cat-file", "-e", commit+":"+p)
+			cmd.Dir = mirror
+			if cmd.Run() == nil {
+				existing = append(existing, p)
+			}
+		}
+
+		if err := clearStage(dst); err != nil {
+			return err
+		}
+		if err := os.MkdirAll(dst, os.ModePerm); err != nil {
+			return err
+		}
+		if len(existing) == 0 {
+			return nil
+		}
+		return gitArchive(ctx, mirror, commit, existing, dst)
+	})
+	if err != nil {
+		return err
+	}
+
+	if g.Record != nil {
+		g.Record.UnresolvedReferences = unresolved
+	}
+	return nil
+}
+
+// Materialise The Module Subdirectory And Then Follow The Relative Module Sources (./ And ../) Found In It,
+// Materialising Every Directory They Reference, Until No New Directories Are Found.   References That Leave The
+// Repository Or Don't Exist In It Can't Be Included, So They Are Returned To Be Reported.
+func expandSparsePaths(dst, subdir string, materialise materialiseFunc) ([]string, error) {
+	subdir = path.Clean(filepath.ToSlash(subdir))
+	paths := []string{subdir}
+	if err := materialise(paths); err != nil {
+		return nil, err
+	}
+
+	unresolved := []string{}
+	visited := map[string]bool{}
+	worklist := []string{subdir}
+
+	for len(worklist) > 0 {
+		dir := worklist[0]
+		worklist = worklist[1:]
+		if visited[dir] {
+			continue
+		}
+		visited[dir] = true
+
+		dirPath := filepath.Join(dst, filepath.FromSlash(dir))
+		if !util.IsDir(dirPath) {
+			continue
+		}
+
+		calls, err := findModuleCalls(dirPath)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, call := range calls {
+			if !isLocalModuleSource(call.Source) {
+				continue
+			}
+
+			reference := fmt.Sprintf("module %q in %s references %s", call.Name, path.Join(dir, filepath.Base(call.File)), call.Source)
+			target := resolveModuleSource(dir, call.Source)
+			if escapesDir(target) {
+				unresolved = append(unresolved, reference+", which is outside of the repository")
+				continue
+			}
+
+			if !coveredByPaths(target, paths) {
+				paths = append(paths, target)
+				if err := materialise(paths); err != nil {
+					return nil, err
+				}
+			}
+
+			if !util.IsDir(filepath.Join(dst, filepath.FromSlash(target))) {
+				unresolved = append(unresolved, reference+", which does not exist in the repository")
+				continue
+			}
+
+			worklist = append(worklist, target)
+		}
+	}
+
+	return unresolved, nil
+}
+
+// Returns True If target Is One Of The paths Or Inside One
+func coveredByPaths(target string, paths []string) bool {
+	for _, p := range paths {
+		if p == "." || target == p || strings.HasPrefix(target, p+"/") {
+			return true
+		}
+	}
+	return false
+}
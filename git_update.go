@@ -0,0 +1,201 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Make Sure dst Can Be Updated In Place: It Must Be The Top Of A Clone Of The Same Remote, And The Only Tracked Files
+// That Differ From The Checkout Must Be Ones Terrastage Generated (Or Terraform's Own Working Files), Since Those Are
+// Overwritten On Every Stage Anyway
+func (g *GitGetter) checkReusableClone(ctx context.Context, dst string, u *url.URL) error {
+	toplevel, err := gitOutput(ctx, dst, "rev-parse", "--show-toplevel")
+	if err != nil || !sameDir(toplevel, dst) {
+		return NotAGitClone{Dir: dst}
+	}
+
+	remote, err := gitOutput(ctx, dst, "remote", "get-url", "origin")
+	if err != nil {
+		return GitRemoteMismatch{Dir: dst, Expected: u.Redacted(), Actual: "no origin remote"}
+	}
+	if !sameGitRemote(remote, u) {
+		return GitRemoteMismatch{Dir: dst, Expected: u.Redacted(), Actual: redactGitRemote(remote)}
+	}
+
+	status, err := gitOutput(ctx, dst, "status", "--porcelain", "-z", "--untracked-files=no")
+	if err != nil {
+		return err
+	}
+
+	changed := []string{}
+	entries := strings.Split(status, "\x00")
+	for i := 0; i < len(entries); i++ {
+		entry := entries[i]
+		if len(entry) < 4 {
+			continue
+		}
+		// Renames And Copies Are Followed By The Path They Came From
+		if entry[0] == 'R' || entry[0] == 'C' {
+			i++
+		}
+		if !keptInStage(entry[3:], g.Generated) {
+			changed = append(changed, entry[3:])
+		}
+	}
+	if len(changed) > 0 {
+		return LocalChanges{Dir: dst, Files: changed}
+	}
+
+	return nil
+}
+
+// Return The Commit That ref Resolves To After A Fetch, And The Branch Name When ref Is A Branch.   Tags Win Over
+// Branches Of The Same Name, The Same Way git rev-parse Resolves Them.   Refs Outside Of The Fetch Refspec (A Shallow
+// Clone Only Fetches Its Own Branch) And Commits That Aren't The Tip Of A Ref Are Fetched On Their Own From remote.
+func resolveFetchedRef(ctx context.Context, dst string, auth *gitAuth, remote string, ref string, depth int) (string, string, error) {
+	if gitCommitIDRegex.MatchString(ref) {
+		if commit, err := gitRevParse(dst, ref+"^{commit}"); err == nil {
+			return commit, "", nil
+		}
+	}
+	if commit, err := gitRevParse(dst, "refs/tags/"+ref+"^{commit}"); err == nil {
+		return commit, "", nil
+	}
+	if commit, err := gitRevParse(dst, "refs/remotes/origin/"+ref+"^{commit}"); err == nil {
+		return commit, ref, nil
+	}
+
+	args := []string{"fetch"}
+	if depth > 0 {
+		args = append(args, "--depth", strconv.Itoa(depth))
+	}
+	cmd := exec.CommandContext(ctx, "git", append(args, remote, ref)...)
+	cmd.Dir = dst
+	setupGitEnv(cmd, auth)
+	if err := getRunCommand(cmd); err != nil {
+		return "", "", fmt.Errorf("ref %q was not found in the origin of %s: %w", ref, dst, err)
+	}
+
+	commit, err := gitRevParse(dst, "FETCH_HEAD^{commit}")
+	if err != nil {
+		return "", "", err
+	}
+	return commit, "", nil
+}
+
+// Remove The Untracked Files In dst That Terrastage Didn't Generate, So Files Deleted Upstream Or Left Behind By An
+// Older Ref Don't Linger In The Stage.   Ignored Files, Bookkeeping Files And Terraform's Own Working Files Are Left
+// Alone, And So Are The Directories Holding Them.
+func (g *GitGetter) cleanUntracked(ctx context.Context, dst string) error {
+	untracked, err := gitOutput(ctx, dst, "ls-files", "--others", "--exclude-standard", "-z")
+	if err != nil {
+		return err
+	}
+
+	for _, relPath := range strings.Split(untracked, "\x00") {
+		if relPath == "" || keptInStage(relPath, g.Generated) {
+			continue
+		}
+		if err := removeUntrackedFile(dst, relPath); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Remove An Untracked File From dst, Then Every Directory Above It That Is Left Empty, Like git clean -d Does
+func removeUntrackedFile(dst string, relPath string) error {
+	if err := os.Remove(filepath.Join(dst, filepath.FromSlash(relPath))); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	for dir := path.Dir(relPath); dir != "."; dir = path.Dir(dir) {
+		entries, err := os.ReadDir(filepath.Join(dst, filepath.FromSlash(dir)))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if len(entries) > 0 {
+			return nil
+		}
+		if err := os.Remove(filepath.Join(dst, filepath.FromSlash(dir))); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// Run Git In dir And Return What It Printed, Without The Trailing Newline
+func gitOutput(ctx context.Context, dir string, args ...string) (string, error) {
+	var stdoutbuf, stderrbuf bytes.Buffer
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Dir = dir
+	cmd.Stdout = &stdoutbuf
+	cmd.Stderr = &stderrbuf
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("git %s in %s failed: %v: %s", args[0], dir, err, stderrbuf.String())
+	}
+	return strings.TrimSuffix(stdoutbuf.String(), "\n"), nil
+}
+
+// Returns True If Both Paths Are The Same Directory Once Symlinks Are Resolved
+func sameDir(a, b string) bool {
+	a, errA := filepath.EvalSymlinks(a)
+	b, errB := filepath.EvalSymlinks(b)
+	return errA == nil && errB == nil && filepath.Clean(a) == filepath.Clean(b)
+}
+
+// Returns True If The Remote URL Of A Clone Is The Remote u Points To
+func sameGitRemote(remote string, u *url.URL) bool {
+	remoteURL, err := url.Parse(remote)
+	if err != nil {
+		return remote == u.String()
+	}
+	return gitRemoteKey(remoteURL) == gitRemoteKey(u)
+}
+
+// Hide Any Password In A Remote URL So It Can Be Reported
+func redactGitRemote(remote string) string {
+	remoteURL, err := url.Parse(remote)
+	if err != nil {
+		return remote
+	}
+	return remoteURL.Redacted()
+}
+
+type NotAGitClone struct {
+	Dir string
+}
+
+func (err NotAGitClone) Error() string {
+	return fmt.Sprintf("Stage %s already exists but is not a git clone, so it can't be updated. Run with -source-update to download the source again.", err.Dir)
+}
+
+type GitRemoteMismatch struct {
+	Dir      string
+	Expected string
+	Actual   string
+}
+
+func (err GitRemoteMismatch) Error() string {
+	return fmt.Sprintf("Stage %s is a clone of %s, not %s, so it can't be updated. Run with -source-update to download the source again.", err.Dir, err.Actual, err.Expected)
+}
+
+type LocalChanges struct {
+	Dir   string
+	Files []string
+}
+
+func (err LocalChanges) Error() string {
+	return fmt.Sprintf("Stage %s has changes to tracked files that terrastage didn't make: %s. Updating would discard them, so save them elsewhere and run with -source-update to download the source again.", err.Dir, strings.Join(err.Files, ", "))
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGitGetterUpdatesFromSharedClone(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name  string
+		query string
+	}{
+		{name: "default branch", query: ""},
+		{name: "branch", query: "ref=main"},
+		{name: "tag", query: "ref=v2.0.0"},
+	}
+
+	for _, testCase := range testCases {
+		testCase := testCase
+		t.Run(testCase.name, func(t *testing.T) {
+			t.Parallel()
+
+			origin := newTestGitOrigin(t)
+			first := origin.commit(t, map[string]string{"main.tf": "# first\n", "removed.tf": "# removed\n"})
+			origin.tag(t, "v2.0.0", first)
+			u := origin.url(testCase.query)
+			dst := filepath.Join(t.TempDir(), "stage")
+			require.NoError(t, (&GitGetter{}).Get(dst, u))
+
+			_, err := origin.worktree.Remove("removed.tf")
+			require.NoError(t, err)
+			second := origin.commit(t, map[string]string{"main.tf": "# second\n"})
+			require.NoError(t, origin.repo.DeleteTag("v2.0.0"))
+			origin.tag(t, "v2.0.0", second)
+			sharedClone := filepath.Join(t.TempDir(), "shared")
+			require.NoError(t, (&GitGetter{}).Get(sharedClone, u))
+
+			// With The Remote Gone, The Stage Can Only Be Updated From The Shared Clone
+			require.NoError(t, os.Rename(origin.dir, origin.dir+".gone"))
+			record := &DownloadRecord{}
+			require.NoError(t, (&GitGetter{SharedClone: sharedClone, Record: record}).Get(dst, u))
+
+			assert.Equal(t, second.String(), resolveHeadCommit(dst))
+			assert.Equal(t, second.String(), record.ResolvedCommit)
+			contents, err := os.ReadFile(filepath.Join(dst, "main.tf"))
+			require.NoError(t, err)
+			assert.Equal(t, "# second\n", string(contents))
+			assert.NoFileExists(t, filepath.Join(dst, "removed.tf"))
+		})
+	}
+}
+
+func TestGitGetterCleansEmptiedDirectories(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	origin := t.TempDir()
+	git := func(args ...string) {
+		_, err := gitOutput(ctx, origin, append([]string{"-c", "user.name=Terrastage", "-c", "user.email=terrastage@example.com"}, args...)...)
+		require.NoError(t, err)
+	}
+	writeFiles := func(dir string, files map[string]string) {
+		for relPath, contents := range files {
+			require.NoError(t, os.MkdirAll(filepath.Dir(filepath.Join(dir, relPath)), 0755))
+			require.NoError(t, os.WriteFile(filepath.Join(dir, relPath), []byte(contents), 0644))
+		}
+	}
+
+	git("init")
+	writeFiles(origin, map[string]string{"main.tf": "# first\n", "modules/old/main.tf": "# old\n", "modules/kept/main.tf": "# kept\n"})
+	git("add", "-A")
+	git("commit", "-m", "first")
+	u := &url.URL{Scheme: "file", Path: origin}
+	dst := filepath.Join(t.TempDir(), "stage")
+	require.NoError(t, (&GitGetter{}).Get(dst, u))
+
+	writeFiles(dst, map[string]string{
+		"modules/old/extra.tf":    "# untracked\n",
+		"scratch/nested/notes.tf": "# untracked\n",
+		"modules/kept/backend.tf": "# generated\n",
+	})
+
+	git("rm", "-q", "modules/old/main.tf", "modules/kept/main.tf")
+	writeFiles(origin, map[string]string{"main.tf": "# second\n"})
+	git("commit", "-qam", "second")
+	require.NoError(t, (&GitGetter{Generated: []string{"modules/kept/backend.tf"}}).Get(dst, u))
+
+	assert.NoDirExists(t, filepath.Join(dst, "modules", "old"))
+	assert.NoDirExists(t, filepath.Join(dst, "scratch"))
+	assert.FileExists(t, filepath.Join(dst, "modules", "kept", "backend.tf"))
+	assert.FileExists(t, filepath.Join(dst, "main.tf"))
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+)
+
+// What -git-verify-signatures Requires To Be Signed By A Trusted Key Before A Git Source Is Staged
+const (
+	// The Commit That Is Checked Out
+	GitVerifyCommit = "commit"
+
+	// The Tag The Ref Names, Which Must Be An Annotated, Signed Tag
+	GitVerifyTag = "tag"
+)
+
+// Which Signatures Git Sources Must Carry And Which Keys Are Trusted To Make Them, Set From The Command Line
+type GitSignatureOptions struct {
+	// GitVerifyCommit, GitVerifyTag Or Empty To Stage Unsigned Refs
+	Mode string
+
+	// An SSH Allowed Signers File (See ssh-keygen(1)) Of The SSH Keys Trusted To Sign
+	AllowedSignersFile string
+
+	// A GPG Public Keyring (gpg --export --armor) Of The Keys Trusted To Sign
+	KeyringFile string
+}
+
+// Check That commit, Or The Tag ref Names When Tags Are Verified, In The Repository At gitDir Is Signed By One Of
+// The Trusted Keys.   Only The Trusted Keys Are Consulted: gpg Runs Against A Keyring Of Its Own Holding Just The
+// Configured Keyring, And SSH Signatures Are Checked Against The Configured Allowed Signers File Alone, Whatever The
+// User's Git Config Says.
+func verifyGitSignature(ctx context.Context, gitDir string, options GitSignatureOptions, source string, commit string, ref string) error {
+	if options.Mode == "" {
+		return nil
+	}
+
+	object := "commit " + commit
+	args := []string{"verify-commit", commit}
+	if options.Mode == GitVerifyTag {
+		tag, err := gitTagForCommit(gitDir, ref, commit)
+		if err != nil {
+			return GitRefNotSignedTag{Source: source, Ref: ref, Reason: err.Error()}
+		}
+		object = "tag " + ref
+		args = []string{"verify-tag", tag}
+	}
+
+	if options.AllowedSignersFile != "" {
+		if err := checkGitVersion(ctx, "2.34"); err != nil {
+			return fmt.Errorf("could not verify ssh signatures: %w", err)
+		}
+	}
+
+	keys, err := os.MkdirTemp("", "terrastage-gpg-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(keys)
+	if err := os.Chmod(keys, 0700); err != nil {
+		return err
+	}
+
+	if options.KeyringFile != "" {
+		cmd := exec.CommandContext(ctx, "gpg", "--batch", "--quiet", "--homedir", keys, "--import", options.KeyringFile)
+		if err := getRunCommand(cmd); err != nil {
+			return fmt.Errorf("could not import the keyring %s: %w", options.KeyringFile, err)
+		}
+	}
+
+	allowedSigners := options.AllowedSignersFile
+	if allowedSigners == "" {
+		allowedSigners = filepath.Join(keys, "allowed_signers")
+		if err := os.WriteFile(allowedSigners, nil, 0600); err != nil {
+			return err
+		}
+	}
+
+	var stderrbuf bytes.Buffer
+	config := []string{
+		"-c", "gpg.ssh.allowedSignersFile=" + allowedSigners,
+		"-c", "gpg.minTrustLevel=undefined",
+	}
+	cmd := exec.CommandContext(ctx, "git", append(config, args...)...)
+	cmd.Dir = gitDir
+	cmd.Env = append(os.Environ(), "GNUPGHOME="+keys)
+	cmd.Stderr = &stderrbuf
+	if err := cmd.Run(); err != nil {
+		// Git Says Nothing When There Is No Signature At All
+		reason := strings.TrimSpace(stderrbuf.String())
+		if reason == "" {
+			reason = "it isn't signed"
+		}
+		return GitSignatureNotTrusted{Source: source, Object: object, Reason: reason}
+	}
+	return nil
+}
+
+// Check The Signature Of commit, Or The Tag ref Names, In The Repository At gitDir The Way g.Signatures Says, And
+// Report What Was Verified In The Download Record
+func (g *GitGetter) verifySignature(ctx context.Context, gitDir string, u *url.URL, commit string, ref string) error {
+	if err := verifyGitSignature(ctx, gitDir, g.Signatures, u.Redacted(), commit, ref); err != nil {
+		return err
+	}
+	if g.Record != nil {
+		g.Record.VerifiedSignature = g.Signatures.Mode
+	}
+	return nil
+}
+
+// Check The Signature Of hash, Or Of The Tag refName Names, In repo Before It Is Checked Out, The Way g.Signatures
+// Says.   go-git Only Verifies GPG Signatures, Against The Keyring Alone.   The Tag Is Fetched Again So A Tag That
+// Moved Upstream Isn't Verified In Its Old Place.
+func (g *GoGitGetter) verifySignature(ctx context.Context, repo *git.Repository, auth transport.AuthMethod, u *url.URL, hash plumbing.Hash, refName string, depth int) error {
+	if g.Signatures.Mode == "" {
+		return nil
+	}
+
+	object := "commit " + hash.String()
+	var signature string
+	var verify func(string) error
+	if g.Signatures.Mode == GitVerifyTag {
+		if !strings.HasPrefix(refName, "refs/tags/") {
+			return GitRefNotSignedTag{Source: u.Redacted(), Ref: plumbing.ReferenceName(refName).Short(), Reason: "it doesn't name a tag"}
+		}
+		tagName := plumbing.ReferenceName(refName).Short()
+		object = "tag " + tagName
+		if err := goGitFetchRefSpecs(ctx, repo, auth, depth, config.RefSpec(fmt.Sprintf("+%s:%s", refName, refName))); err != nil {
+			return err
+		}
+		reference, err := repo.Reference(plumbing.ReferenceName(refName), false)
+		if err != nil {
+			return err
+		}
+		tag, err := repo.TagObject(reference.Hash())
+		if err != nil {
+			return GitRefNotSignedTag{Source: u.Redacted(), Ref: tagName, Reason: "it is a lightweight tag, which can't be signed"}
+		}
+		tagged, err := tag.Commit()
+		if err != nil {
+			return err
+		}
+		if tagged.Hash != hash {
+			return GitRefNotSignedTag{Source: u.Redacted(), Ref: tagName, Reason: fmt.Sprintf("the tag points at %s, not the commit %s being staged", tagged.Hash, hash)}
+		}
+		signature = tag.PGPSignature
+		verify = func(keyring string) error {
+			_, err := tag.Verify(keyring)
+			return err
+		}
+	} else {
+		commit, err := repo.CommitObject(hash)
+		if err != nil {
+			return err
+		}
+		signature = commit.PGPSignature
+		verify = func(keyring string) error {
+			_, err := commit.Verify(keyring)
+			return err
+		}
+	}
+
+	if signature == "" {
+		return GitSignatureNotTrusted{Source: u.Redacted(), Object: object, Reason: "it isn't signed"}
+	}
+	if strings.HasPrefix(signature, "-----BEGIN SSH SIGNATURE-----") {
+		return GitSignatureNotTrusted{Source: u.Redacted(), Object: object, Reason: fmt.Sprintf("it has an ssh signature, which the %s git backend can't verify; use -git-backend %s", GitBackendGoGit, GitBackendExec)}
+	}
+	keyring, err := os.ReadFile(g.Signatures.KeyringFile)
+	if err != nil {
+		return err
+	}
+	if err := verify(string(keyring)); err != nil {
+		return GitSignatureNotTrusted{Source: u.Redacted(), Object: object, Reason: err.Error()}
+	}
+
+	if g.Record != nil {
+		g.Record.VerifiedSignature = g.Signatures.Mode
+	}
+	return nil
+}
+
+// Return The Full Name Of The Tag ref Names, After Checking It Is An Annotated Tag That Still Points At commit
+func gitTagForCommit(gitDir string, ref string, commit string) (string, error) {
+	if ref == "" || strings.HasPrefix(ref, "refs/heads/") {
+		return "", fmt.Errorf("it doesn't name a tag")
+	}
+	tag := ref
+	if !strings.HasPrefix(tag, "refs/tags/") {
+		tag = "refs/tags/" + tag
+	}
+	tagged, err := gitRevParse(gitDir, tag+"^{commit}")
+	if err != nil {
+		return "", fmt.Errorf("it doesn't name a tag")
+	}
+	if tagged != commit {
+		return "", fmt.Errorf("the tag points at %s, not the commit %s being staged", tagged, commit)
+	}
+	if object, err := gitRevParse(gitDir, tag); err != nil || object == tagged {
+		return "", fmt.Errorf("it is a lightweight tag, which can't be signed")
+	}
+	return tag, nil
+}
+
+type GitSignatureNotTrusted struct {
+	Source string
+	Object string
+	Reason string
+}
+
+func (err GitSignatureNotTrusted) Error() string {
+	return fmt.Sprintf("The %s of %s is not signed by a trusted key, so it was not staged: %s", err.Object, err.Source, err.Reason)
+}
+
+type GitRefNotSignedTag struct {
+	Source string
+	Ref    string
+	Reason string
+}
+
+func (err GitRefNotSignedTag) Error() string {
+	return fmt.Sprintf("Ref %q of %s can't be staged with -git-verify-signatures %s, which only stages signed tags: %s", err.Ref, err.Source, GitVerifyTag, err.Reason)
+}
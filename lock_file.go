@@ -0,0 +1,168 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gruntwork-io/go-commons/errors"
+	"github.com/gruntwork-io/terragrunt/util"
+)
+
+// Default Name Of The Lock File, Written To The Root Of The Stage Directory Unless -lockfile Is Given
+const DefaultLockFileName = "terrastage.lock.json"
+
+// How Long To Wait For Another Run Saving The Same Lock File
+const lockFileLockTimeout = 5 * time.Minute
+
+// The Ref Recorded For A Git Source Without A ref Query Parameter (The Remote's Default Branch)
+const defaultRefLockKey = "HEAD"
+
+// Records The Commit Each Git Source's Ref Resolved To, So Staging Can Be Reproduced Later With -locked.
+// Sources Are Keyed By Their Normalized Remote URL, Then By Ref.
+type LockFile struct {
+	Sources map[string]map[string]string `json:"sources"`
+
+	path    string
+	changed bool
+	mutex   sync.Mutex
+}
+
+// Load The Lock File At The Given Path, Or Start An Empty One If It Doesn't Exist Yet
+func loadLockFile(path string) (*LockFile, error) {
+	lockFile := &LockFile{Sources: map[string]map[string]string{}, path: path}
+	if !util.FileExists(path) {
+		return lockFile, nil
+	}
+
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.WithStackTrace(err)
+	}
+	if err := json.Unmarshal(contents, lockFile); err != nil {
+		return nil, errors.WithStackTrace(fmt.Errorf("could not parse lock file %s: %w", path, err))
+	}
+	if lockFile.Sources == nil {
+		lockFile.Sources = map[string]map[string]string{}
+	}
+
+	return lockFile, nil
+}
+
+// Identify A Git Source By Its Normalized Remote URL.  Any Forced Getter Prefix (git::) Is Dropped So The
+// URL Seen By The Git Getter And The Canonical Source URL Give The Same Key.   Passwords Are Left Out.
+func gitRemoteKey(u *url.URL) string {
+	remote := *u
+	if _, scheme := getForcedGetter(remote.Scheme); scheme != "" {
+		remote.Scheme = scheme
+	}
+	if remote.User != nil {
+		remote.User = url.User(remote.User.Username())
+	}
+	return normalizeGitRemote(&remote)
+}
+
+func lockFileRef(ref string) string {
+	if ref == "" {
+		return defaultRefLockKey
+	}
+	return ref
+}
+
+// Return The Locked Commit For A Source And Ref
+func (lockFile *LockFile) Lookup(u *url.URL, ref string) (string, bool) {
+	lockFile.mutex.Lock()
+	defer lockFile.mutex.Unlock()
+
+	commit, ok := lockFile.Sources[gitRemoteKey(u)][lockFileRef(ref)]
+	return commit, ok
+}
+
+// Record The Commit A Source And Ref Resolved To
+func (lockFile *LockFile) Record(u *url.URL, ref string, commit string) {
+	lockFile.mutex.Lock()
+	defer lockFile.mutex.Unlock()
+
+	key := gitRemoteKey(u)
+	if lockFile.Sources[key] == nil {
+		lockFile.Sources[key] = map[string]string{}
+	}
+	if lockFile.Sources[key][lockFileRef(ref)] != commit {
+		lockFile.Sources[key][lockFileRef(ref)] = commit
+		lockFile.changed = true
+	}
+}
+
+// Write The Lock File If Anything Was Recorded.   Entries Are Merged Into Whatever Is On Disk Under A
+// File Lock, So Parallel Runs Sharing The Same Lock File Don't Lose Each Other's Entries.
+func (lockFile *LockFile) Save() error {
+	lockFile.mutex.Lock()
+	defer lockFile.mutex.Unlock()
+
+	if !lockFile.changed {
+		return nil
+	}
+
+	unlock, err := acquireFileLock(lockFile.path+".lock", lockFileLockTimeout)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	onDisk, err := loadLockFile(lockFile.path)
+	if err != nil {
+		return err
+	}
+	for key, refs := range lockFile.Sources {
+		if onDisk.Sources[key] == nil {
+			onDisk.Sources[key] = map[string]string{}
+		}
+		for ref, commit := range refs {
+			onDisk.Sources[key][ref] = commit
+		}
+	}
+
+	contents, err := json.MarshalIndent(onDisk, "", "  ")
+	if err != nil {
+		return errors.WithStackTrace(err)
+	}
+	if err := os.MkdirAll(filepath.Dir(lockFile.path), os.ModePerm); err != nil {
+		return errors.WithStackTrace(err)
+	}
+	if err := os.WriteFile(lockFile.path, append(contents, '\n'), 0644); err != nil {
+		return errors.WithStackTrace(err)
+	}
+
+	lockFile.changed = false
+	return nil
+}
+
+// Returns True If The Source Is Downloaded With The Git Getter
+func isGitSource(u *url.URL) bool {
+	return u.Scheme == "git" || strings.HasPrefix(u.Scheme, "git::")
+}
+
+type LockedRefMissing struct {
+	Source string
+	Ref    string
+}
+
+func (err LockedRefMissing) Error() string {
+	return fmt.Sprintf("Source %s ref %s is not in the lock file. Run without -locked to resolve and record it.", err.Source, lockFileRef(err.Ref))
+}
+
+type LockedRefMoved struct {
+	Source  string
+	Ref     string
+	Locked  string
+	Current string
+}
+
+func (err LockedRefMoved) Error() string {
+	return fmt.Sprintf("Source %s ref %s is locked to %s but now points to %s. Run without -locked to update the lock file.", err.Source, lockFileRef(err.Ref), err.Locked, err.Current)
+}
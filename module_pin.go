@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/gruntwork-io/go-commons/errors"
+	"github.com/gruntwork-io/terragrunt/options"
+	"github.com/gruntwork-io/terragrunt/util"
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclwrite"
+	"github.com/zclconf/go-cty/cty"
+)
+
+// Rewrite The version Of Every Terraform Registry Module Block In The Staged Code That Is A Constraint (Or Missing)
+// To The Exact Version It Resolves To, So The Staged Code Installs Exactly What Was Reviewed.   Constraints Are
+// Resolved Against The Registry's Versions API And Recorded In The Lock File, Or Taken From The Lock File In Locked
+// Mode.   Offline They Are Taken From The Bundle, Or From The Lock File For Modules The Bundle Has No Version Of.
+// Modules Referenced With Relative Paths Inside stageDir Are Followed.   The Files Rewritten Are Added To rewritten.
+func pinModuleVersions(terragruntOptions *options.TerragruntOptions, stageOptions *StageOptions, stageDir string, rewritten map[string]bool) error {
+	ctx := context.Background()
+	locked := stageOptions.Locked || stageOptions.Bundle != nil
+
+	registries := map[string]*registryClient{}
+	modulesURLs := map[string]*url.URL{}
+
+	return walkStagedModuleCalls(terragruntOptions.Logger, terragruntOptions.WorkingDir, stageDir, func(dir string, call ModuleCall) (string, error) {
+		host, modulePath, _, ok := parseRegistryModuleSource(call.Source)
+		if !ok || isExactRegistryVersion(call.Version) {
+			return "", nil
+		}
+		if host == "" {
+			host = defaultRegistryHost
+		}
+
+		// Offline, The Version Comes From The Bundle Before The Lock File, Checked Against (Or Recorded In) The Lock
+		// File Like The Bundle's Own Sources
+		if stageOptions.Bundle != nil {
+			pinned, ok, err := stageOptions.Bundle.moduleVersion(call, dir, terragruntOptions)
+			if err != nil {
+				return "", err
+			}
+			if ok {
+				if err := lockBundledSource(stageOptions, registryLockURL(host, modulePath), registryConstraint(call.Version), pinned); err != nil {
+					return "", err
+				}
+				return "", pinModuleVersion(terragruntOptions, call, host, modulePath, pinned, rewritten)
+			}
+		}
+
+		// Every Registry Host Is Discovered Once, And Not At All When The Versions Come From The Lock File
+		registry, ok := registries[host]
+		if !ok {
+			var err error
+			registry, err = newRegistryClient(host)
+			if err != nil {
+				return "", err
+			}
+			if !locked {
+				modulesURLs[host], err = registry.discoverModules(ctx, stageOptions.RegistryHosts[host])
+				if err != nil {
+					return "", err
+				}
+			}
+			registries[host] = registry
+		}
+
+		pinned, err := registry.moduleVersion(ctx, modulesURLs[host], modulePath, call.Version, stageOptions.Lock, locked)
+		if err != nil {
+			return "", err
+		}
+		return "", pinModuleVersion(terragruntOptions, call, host, modulePath, pinned, rewritten)
+	})
+}
+
+func pinModuleVersion(terragruntOptions *options.TerragruntOptions, call ModuleCall, host string, modulePath string, pinned string, rewritten map[string]bool) error {
+	terragruntOptions.Logger.Infof("Pinning module %q in %s to version %s of %s/%s (%s)", call.Name, call.File, pinned, host, modulePath, registryConstraint(call.Version))
+	if err := rewriteModuleAttributes(call, map[string]string{"version": pinned}, nil); err != nil {
+		return err
+	}
+	rewritten[call.File] = true
+	return nil
+}
+
+// Pin The version Query Parameter Of The Stage's Own tfr Source In The Staged Terragrunt Config To The Version It
+// Resolved To, When It Is A Constraint Written As A Literal String.   A Source Built From Expressions Is Left As It
+// Is, With A Warning.
+func pinStageSource(terragruntOptions *options.TerragruntOptions, downloadDir string, source string, rewritten map[string]bool) error {
+	sourceURL, err := url.Parse(source)
+	if err != nil {
+		return nil
+	}
+	if _, _, ok := registryLockKey(sourceURL); !ok {
+		return nil
+	}
+
+	metadata, err := readStageMetadata(filepath.Join(downloadDir, StageMetadataFile))
+	if err != nil {
+		return err
+	}
+	if metadata.ResolvedVersion == "" {
+		return nil
+	}
+
+	configFile := filepath.Join(terragruntOptions.WorkingDir, filepath.Base(terragruntOptions.TerragruntConfigPath))
+	if !util.FileExists(configFile) || strings.HasSuffix(configFile, ".json") {
+		terragruntOptions.Logger.Warnf("Could not pin the stage source %s, there is no staged terragrunt.hcl", source)
+		return nil
+	}
+	contents, err := os.ReadFile(configFile)
+	if err != nil {
+		return errors.WithStackTrace(err)
+	}
+	info, err := os.Stat(configFile)
+	if err != nil {
+		return errors.WithStackTrace(err)
+	}
+	file, diags := hclwrite.ParseConfig(contents, configFile, hcl.InitialPos)
+	if diags.HasErrors() {
+		return errors.WithStackTrace(diags)
+	}
+
+	pinnedSource := pinnedRegistrySource(source, metadata.ResolvedVersion)
+	for _, block := range file.Body().Blocks() {
+		if block.Type() != "terraform" {
+			continue
+		}
+		attribute := block.Body().GetAttribute("source")
+		if attribute == nil {
+			continue
+		}
+		if strings.TrimSpace(string(attribute.Expr().BuildTokens(nil).Bytes())) != fmt.Sprintf("%q", source) {
+			terragruntOptions.Logger.Warnf("Could not pin the stage source %s in %s, it isn't a literal string", source, configFile)
+			return nil
+		}
+
+		terragruntOptions.Logger.Infof("Pinning the stage source in %s to %s", configFile, pinnedSource)
+		block.Body().SetAttributeValue("source", cty.StringVal(pinnedSource))
+		if err := os.WriteFile(configFile, file.Bytes(), info.Mode()); err != nil {
+			return errors.WithStackTrace(err)
+		}
+		rewritten[configFile] = true
+		return nil
+	}
+	return nil
+}
+
+// The Source With Its version Query Parameter Set To An Exact Version, The Rest Of It As It Was
+func pinnedRegistrySource(source string, pinned string) string {
+	base, query, _ := strings.Cut(source, "?")
+	values, err := url.ParseQuery(query)
+	if err != nil {
+		values = url.Values{}
+	}
+	values.Set("version", pinned)
+	return base + "?" + values.Encode()
+}
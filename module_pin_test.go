@@ -0,0 +1,126 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gruntwork-io/terragrunt/options"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPinModuleVersionsOffline(t *testing.T) {
+	t.Parallel()
+
+	const code = `module "vpc" {
+  source  = "registry.example.com/acme/vpc/aws//modules/subnets"
+  version = "~> 3.0"
+}
+`
+	lockURL := registryLockURL("registry.example.com", "acme/vpc/aws")
+
+	testCases := []struct {
+		name            string
+		bundledVersion  string
+		lockedVersion   string
+		locked          bool
+		expectedVersion string
+		expectedErr     any
+	}{
+		{name: "bundled version", bundledVersion: "3.1.0", expectedVersion: "3.1.0"},
+		{name: "bundled version before the lock file", bundledVersion: "3.1.0", lockedVersion: "3.0.0", expectedVersion: "3.1.0"},
+		{name: "bundled version matching the lock file with -locked", bundledVersion: "3.1.0", lockedVersion: "3.1.0", locked: true, expectedVersion: "3.1.0"},
+		{name: "bundled version moved from the lock file with -locked", bundledVersion: "3.1.0", lockedVersion: "3.0.0", locked: true, expectedErr: new(LockedRefMoved)},
+		{name: "lock file when the bundle has no version", lockedVersion: "3.0.0", expectedVersion: "3.0.0"},
+		{name: "neither", expectedErr: new(LockedRefMissing)},
+	}
+
+	for _, testCase := range testCases {
+		testCase := testCase
+		t.Run(testCase.name, func(t *testing.T) {
+			t.Parallel()
+
+			dir := t.TempDir()
+			mainFile := filepath.Join(dir, "main.tf")
+			require.NoError(t, os.WriteFile(mainFile, []byte(code), 0644))
+
+			terragruntOptions := options.NewTerragruntOptions()
+			terragruntOptions.WorkingDir = dir
+			stageOptions := NewStageOptions()
+			stageOptions.Locked = testCase.locked
+			stageOptions.Lock = &LockFile{Sources: map[string]map[string]string{}}
+			if testCase.lockedVersion != "" {
+				stageOptions.Lock.Record(lockURL, "~> 3.0", testCase.lockedVersion)
+			}
+			// The Bundle Keys Registry Modules By Their tfr Source, Constraint Included And Subdir Left Out
+			bundle := &SourceBundle{path: "bundle.tar.gz", index: BundleIndex{Sources: map[string]BundledSource{}}}
+			if testCase.bundledVersion != "" {
+				bundle.index.Sources["tfr://registry.example.com/acme/vpc/aws?version=~%3E+3.0"] = BundledSource{Dir: "sources/vpc", ResolvedVersion: testCase.bundledVersion}
+			}
+			stageOptions.Bundle = bundle
+			rewritten := map[string]bool{}
+
+			err := pinModuleVersions(terragruntOptions, stageOptions, dir, rewritten)
+
+			contents, readErr := os.ReadFile(mainFile)
+			require.NoError(t, readErr)
+			if testCase.expectedErr != nil {
+				require.ErrorAs(t, err, testCase.expectedErr)
+				assert.Equal(t, code, string(contents))
+				return
+			}
+			require.NoError(t, err)
+			assert.Contains(t, string(contents), `version = "`+testCase.expectedVersion+`"`)
+			assert.Equal(t, map[string]bool{mainFile: true}, rewritten)
+			locked, ok := stageOptions.Lock.Lookup(lockURL, "~> 3.0")
+			assert.True(t, ok)
+			assert.Equal(t, testCase.expectedVersion, locked)
+		})
+	}
+}
+
+func TestPinModuleVersionsStaysInsideTheStage(t *testing.T) {
+	t.Parallel()
+
+	const registryModule = `module "vpc" {
+  source  = "registry.example.com/acme/vpc/aws"
+  version = "~> 3.0"
+}
+`
+	root := t.TempDir()
+	outsideFile := filepath.Join(root, "live", "modules", "network", "main.tf")
+	writeTestTerraformFiles(t, root, map[string]string{"live/modules/network/main.tf": registryModule})
+
+	stageDir := filepath.Join(root, "stage", "app")
+	writeTestTerraformFiles(t, stageDir, map[string]string{
+		"live/main.tf": `module "inside" {
+  source = "../modules/network"
+}
+
+module "outside" {
+  source = "../../../live/modules/network"
+}
+`,
+		"modules/network/main.tf": registryModule,
+	})
+
+	terragruntOptions := options.NewTerragruntOptions()
+	terragruntOptions.WorkingDir = filepath.Join(stageDir, "live")
+	stageOptions := NewStageOptions()
+	stageOptions.Locked = true
+	stageOptions.Lock = &LockFile{Sources: map[string]map[string]string{}}
+	stageOptions.Lock.Record(registryLockURL("registry.example.com", "acme/vpc/aws"), "~> 3.0", "3.1.0")
+	rewritten := map[string]bool{}
+
+	require.NoError(t, pinModuleVersions(terragruntOptions, stageOptions, stageDir, rewritten))
+
+	insideFile := filepath.Join(stageDir, "modules", "network", "main.tf")
+	assert.Equal(t, map[string]bool{insideFile: true}, rewritten)
+	contents, err := os.ReadFile(insideFile)
+	require.NoError(t, err)
+	assert.Contains(t, string(contents), `version = "3.1.0"`)
+	contents, err = os.ReadFile(outsideFile)
+	require.NoError(t, err)
+	assert.Equal(t, registryModule, string(contents))
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/gruntwork-io/go-commons/errors"
+	"github.com/gruntwork-io/terragrunt/util"
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclparse"
+	"github.com/sirupsen/logrus"
+	"github.com/zclconf/go-cty/cty"
+)
+
+// A Module Block Found In Terraform Code
+type ModuleCall struct {
+	// Name Of The Module Block
+	Name string
+
+	// The Literal Value Of The source Attribute
+	Source string
+
+	// The Literal Value Of The version Attribute, If Any
+	Version string
+
+	// The File The Module Block Is In
+	File string
+}
+
+// Schema For The Parts Of Terraform Code We Care About When Looking For Module Calls
+var moduleFileSchema = &hcl.BodySchema{
+	Blocks: []hcl.BlockHeaderSchema{
+		{Type: "module", LabelNames: []string{"name"}},
+	},
+}
+
+var moduleBlockSchema = &hcl.BodySchema{
+	Attributes: []hcl.AttributeSchema{
+		{Name: "source"},
+		{Name: "version"},
+	},
+}
+
+// Find Every Module Block In The Terraform Files (*.tf And *.tf.json) Of A Directory.  Like Terraform
+// Itself This Only Looks At The Files Directly In The Directory, Not In Subdirectories.
+func findModuleCalls(dir string) ([]ModuleCall, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.tf"))
+	if err != nil {
+		return nil, errors.WithStackTrace(err)
+	}
+	jsonFiles, err := filepath.Glob(filepath.Join(dir, "*.tf.json"))
+	if err != nil {
+		return nil, errors.WithStackTrace(err)
+	}
+
+	parser := hclparse.NewParser()
+	calls := []ModuleCall{}
+
+	for _, file := range append(files, jsonFiles...) {
+		var hclFile *hcl.File
+		var diags hcl.Diagnostics
+		if strings.HasSuffix(file, ".json") {
+			hclFile, diags = parser.ParseJSONFile(file)
+		} else {
+			hclFile, diags = parser.ParseHCLFile(file)
+		}
+		if diags.HasErrors() {
+			return nil, errors.WithStackTrace(diags)
+		}
+
+		content, _, diags := hclFile.Body.PartialContent(moduleFileSchema)
+		if diags.HasErrors() {
+			return nil, errors.WithStackTrace(diags)
+		}
+
+		for _, block := range content.Blocks {
+			blockContent, _, diags := block.Body.PartialContent(moduleBlockSchema)
+			if diags.HasErrors() {
+				return nil, errors.WithStackTrace(diags)
+			}
+
+			call := ModuleCall{Name: block.Labels[0], File: file}
+			if call.Source, err = literalAttribute(blockContent.Attributes["source"]); err != nil {
+				return nil, err
+			}
+			if call.Version, err = literalAttribute(blockContent.Attributes["version"]); err != nil {
+				return nil, err
+			}
+			calls = append(calls, call)
+		}
+	}
+
+	return calls, nil
+}
+
+// Return The Literal String Value Of An Attribute.  Terraform Requires Module Sources And Versions To Be
+// Literal Strings, So Anything Else Is An Error.
+func literalAttribute(attribute *hcl.Attribute) (string, error) {
+	if attribute == nil {
+		return "", nil
+	}
+
+	value, diags := attribute.Expr.Value(nil)
+	if diags.HasErrors() || !value.Type().Equals(cty.String) || value.IsNull() {
+		return "", errors.WithStackTrace(fmt.Errorf("%s: %s must be a literal string", attribute.Range, attribute.Name))
+	}
+
+	return value.AsString(), nil
+}
+
+// Returns True If The Module Source Is A Relative Local Path (./ Or ../).  Windows Style Separators
+// Are Accepted Too, Since Terraform Accepts Them.
+func isLocalModuleSource(source string) bool {
+	source = filepath.ToSlash(strings.ReplaceAll(source, `\`, "/"))
+	return strings.HasPrefix(source, "./") || strings.HasPrefix(source, "../")
+}
+
+// Resolve A Relative Module Source Against The Slash Separated Directory It Was Found In.   Returns The
+// Cleaned, Slash Separated Result, Which Starts With ../ If It Escapes The Directory It Is Relative To.
+func resolveModuleSource(dir string, source string) string {
+	source = strings.ReplaceAll(source, `\`, "/")
+	return path.Clean(path.Join(dir, source))
+}
+
+// Returns True If The Slash Separated, Cleaned Path Escapes The Directory It Is Relative To
+func escapesDir(relPath string) bool {
+	return relPath == ".." || strings.HasPrefix(relPath, "../")
+}
+
+// Call visit With Every Module Block That Has A Remote Source In The Staged Code, Starting From workingDir And
+// Following Local Module Sources, Plus Any Folder visit Returns (Such As A Module It Vendored).   Only Folders Inside
+// stageDir Are Followed: A Local Source That Points Outside Of It Would Lead To Sibling Stages Or The Original Code,
+// Which Terrastage Must Never Rewrite, So It Is Skipped With A Warning.
+func walkStagedModuleCalls(logger logrus.FieldLogger, workingDir string, stageDir string, visit func(dir string, call ModuleCall) (string, error)) error {
+	visited := map[string]bool{}
+	queue := []string{workingDir}
+	for len(queue) > 0 {
+		dir := queue[0]
+		queue = queue[1:]
+		if visited[dir] {
+			continue
+		}
+		visited[dir] = true
+
+		calls, err := findModuleCalls(dir)
+		if err != nil {
+			return err
+		}
+		for _, call := range calls {
+			if call.Source == "" {
+				continue
+			}
+			if !isLocalModuleSource(call.Source) {
+				target, err := visit(dir, call)
+				if err != nil {
+					return err
+				}
+				if target != "" {
+					queue = append(queue, target)
+				}
+				continue
+			}
+
+			target := filepath.Join(dir, filepath.FromSlash(strings.ReplaceAll(call.Source, `\`, "/")))
+			if !util.IsDir(target) {
+				continue
+			}
+			if !insideStage(target, stageDir) {
+				logger.Warnf("Not following module %q in %s, its source %s points outside of the stage", call.Name, call.File, call.Source)
+				continue
+			}
+			queue = append(queue, target)
+		}
+	}
+	return nil
+}
+
+// Returns True If dir Is stageDir Or A Folder Inside It, Both As Written And With Symlinks Resolved
+func insideStage(dir string, stageDir string) bool {
+	within := func(dir string, stageDir string) bool {
+		relPath, err := filepath.Rel(stageDir, dir)
+		return err == nil && !escapesDir(filepath.ToSlash(relPath))
+	}
+	if !within(dir, stageDir) {
+		return false
+	}
+	resolvedDir, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return false
+	}
+	resolvedStageDir, err := filepath.EvalSymlinks(stageDir)
+	if err != nil {
+		return false
+	}
+	return within(resolvedDir, resolvedStageDir)
+}
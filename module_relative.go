@@ -0,0 +1,210 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/gruntwork-io/go-commons/errors"
+	"github.com/gruntwork-io/terragrunt/util"
+	"github.com/sirupsen/logrus"
+)
+
+// A Folder Of The Stage Laid Out Like A Folder On Disk, So Relative Module Sources In It Resolve The Same Way As
+// They Do There, As Long As They Stay Inside It.   The Folder On Disk Is Unknown ("") For Remote Sources.
+type stageRegion struct {
+	stageDir  string
+	sourceDir string
+}
+
+// A Folder Copied Into The Stage Because A Relative Module Source Referenced It From Outside Of The Stage
+type CopiedReference struct {
+	// The Copy, Relative To The Stage Subdirectory
+	Dir string `json:"dir"`
+
+	// The Folder It Was Copied From
+	Source string `json:"source"`
+}
+
+// Make Relative Module Sources (../) In The Staged Code Resolve Inside The Stage.   A Relative Source Is Resolved
+// Against Where The File It Is In Came From: The Local Source Folder For Files Of The Source, The Terragrunt
+// Working Directory For Files Terragrunt Copied From There (copiedFiles), Or The Referenced Folder For Files Of
+// Folders Copied By Earlier References.   When That Points Outside Of What Was Staged, The Folder Is Copied Into
+// The modules Folder Of The Stage Working Directory (Or Found In The Stage, When It Is Part Of The Source) And The
+// Source Is Rewritten To Point At It.   Copied Folders Are Followed, So Their Relative Sources Are Fixed Too, And
+// Copies From Earlier Runs Are Refreshed.   The Files Written Or Rewritten Are Added To rewritten.   Returns The
+// References That Couldn't Be Fixed, Because They Escape A Remote Source Or The Folder Doesn't Exist.
+func fixRelativeModuleSources(logger logrus.FieldLogger, workingDir string, downloadDir string, sourceRoot string, terragruntDir string, copiedFiles []string, rewritten map[string]bool) ([]string, error) {
+	metadataFile := filepath.Join(downloadDir, StageMetadataFile)
+	metadata, err := readStageMetadata(metadataFile)
+	if err != nil {
+		return nil, err
+	}
+
+	copied := map[string]bool{}
+	for _, file := range copiedFiles {
+		copied[file] = true
+	}
+	sourceRegion := stageRegion{stageDir: downloadDir, sourceDir: sourceRoot}
+	terragruntRegion := stageRegion{stageDir: workingDir, sourceDir: terragruntDir}
+	regions := []stageRegion{sourceRegion}
+	queue := []string{workingDir}
+
+	// Refresh The Copies Made By Earlier Runs, The Folders They Came From May Have Changed Since
+	copies := []CopiedReference{}
+	for _, reference := range metadata.CopiedReferences {
+		stageDir := filepath.Join(downloadDir, filepath.FromSlash(reference.Dir))
+		if util.IsDir(reference.Source) {
+			if err := copyReferencedDir(reference.Source, stageDir, rewritten); err != nil {
+				return nil, err
+			}
+		} else if !util.IsDir(stageDir) {
+			continue
+		}
+		copies = append(copies, reference)
+		regions = append(regions, stageRegion{stageDir: stageDir, sourceDir: reference.Source})
+		queue = append(queue, stageDir)
+	}
+
+	// The Region A Staged File Is In: Files Copied From The Terragrunt Working Directory Have Their Own, Otherwise It
+	// Is The Innermost Folder Copied For A Reference Containing The File, Or Else The Source
+	regionOf := func(file string) stageRegion {
+		if copied[file] {
+			return terragruntRegion
+		}
+		region := sourceRegion
+		for _, candidate := range regions[1:] {
+			if _, ok := pathWithin(file, candidate.stageDir); ok && len(candidate.stageDir) > len(region.stageDir) {
+				region = candidate
+			}
+		}
+		return region
+	}
+
+	unresolved := []string{}
+	visited := map[string]bool{}
+	for len(queue) > 0 {
+		dir := queue[0]
+		queue = queue[1:]
+		if visited[dir] {
+			continue
+		}
+		visited[dir] = true
+
+		calls, err := findModuleCalls(dir)
+		if err != nil {
+			return nil, err
+		}
+		for _, call := range calls {
+			if !isLocalModuleSource(call.Source) {
+				continue
+			}
+
+			region := regionOf(call.File)
+			relDir, _ := pathWithin(dir, region.stageDir)
+			resolved := resolveModuleSource(relDir, call.Source)
+			if !escapesDir(resolved) {
+				queue = append(queue, filepath.Join(region.stageDir, filepath.FromSlash(resolved)))
+				continue
+			}
+
+			reference := fmt.Sprintf("%s (module %q in %s)", call.Source, call.Name, call.File)
+			if region.sourceDir == "" {
+				unresolved = append(unresolved, reference)
+				continue
+			}
+			original := filepath.Join(region.sourceDir, filepath.FromSlash(resolved))
+			if !util.IsDir(original) {
+				unresolved = append(unresolved, reference)
+				continue
+			}
+
+			// Point At The Folder Where It Already Is In The Stage, Or Copy It In
+			target := ""
+			for _, candidate := range regions {
+				if candidate.sourceDir == "" {
+					continue
+				}
+				if relPath, ok := pathWithin(original, candidate.sourceDir); ok {
+					target = filepath.Join(candidate.stageDir, filepath.FromSlash(relPath))
+					break
+				}
+			}
+			if target == "" {
+				target = filepath.Join(workingDir, VendoredModulesDir, vendoredPackageName(original, original))
+				relTarget, err := filepath.Rel(downloadDir, target)
+				if err != nil {
+					return nil, errors.WithStackTrace(err)
+				}
+				logger.Infof("Copying %s into the stage, module %q in %s references it from outside of the stage", original, call.Name, call.File)
+				if err := copyReferencedDir(original, target, rewritten); err != nil {
+					return nil, err
+				}
+				copies = append(copies, CopiedReference{Dir: filepath.ToSlash(relTarget), Source: original})
+				regions = append(regions, stageRegion{stageDir: target, sourceDir: original})
+			}
+
+			relPath, err := filepath.Rel(dir, target)
+			if err != nil {
+				return nil, errors.WithStackTrace(err)
+			}
+			if err := rewriteModuleSource(call, localModulePath(relPath)); err != nil {
+				return nil, err
+			}
+			rewritten[call.File] = true
+			queue = append(queue, target)
+		}
+	}
+
+	metadata.CopiedReferences = copies
+	return unresolved, metadata.write(metadataFile)
+}
+
+// Copy A Referenced Folder Into The Stage, Replacing Any Earlier Copy.   Hidden Files And Folders (.git, .terraform,
+// .terragrunt-cache) Are Left Out, The Same As Terragrunt Does When Copying Local Sources.
+func copyReferencedDir(src string, dst string, rewritten map[string]bool) error {
+	if err := os.RemoveAll(dst); err != nil {
+		return errors.WithStackTrace(err)
+	}
+	err := copyDirectory(src, dst, func(relPath string) bool {
+		for _, part := range strings.Split(relPath, "/") {
+			if strings.HasPrefix(part, ".") {
+				return true
+			}
+		}
+		return false
+	})
+	if err != nil {
+		return errors.WithStackTrace(err)
+	}
+	return addFiles(rewritten, dst)
+}
+
+// Return The Slash Separated Path Of path Relative To dir, And Whether It Is Inside dir At All
+func pathWithin(path string, dir string) (string, bool) {
+	relPath, err := filepath.Rel(dir, path)
+	if err != nil {
+		return "", false
+	}
+	relPath = filepath.ToSlash(relPath)
+	return relPath, !escapesDir(relPath)
+}
+
+// The Folder On Disk A Local Source Is Copied From (The Part Before //, Or The Module Directory When Only That Is
+// Staged), Or "" For Remote Sources
+func localSourceRoot(source string, workingDir string, moduleOnly bool) string {
+	sourceURL, err := ToSourceUrl(source, workingDir)
+	if err != nil || !IsLocalSource(sourceURL) {
+		return ""
+	}
+	sourcePath := sourceURL.Path
+	if !moduleOnly {
+		sourcePath = strings.SplitN(sourcePath, "//", 2)[0]
+	}
+	root, err := util.CanonicalPath(sourcePath, "")
+	if err != nil {
+		return ""
+	}
+	return root
+}
@@ -0,0 +1,296 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/gruntwork-io/go-commons/errors"
+	"github.com/gruntwork-io/terragrunt/config"
+	"github.com/gruntwork-io/terragrunt/options"
+	"github.com/gruntwork-io/terragrunt/util"
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclwrite"
+	"github.com/zclconf/go-cty/cty"
+)
+
+// Folder In The Stage Working Directory That Vendored Module Sources Are Downloaded Into
+const VendoredModulesDir = "modules"
+
+var (
+	registryNamePartRegexp = regexp.MustCompile(`^[0-9A-Za-z](?:[0-9A-Za-z-_]{0,62}[0-9A-Za-z])?$`)
+	exactVersionRegexp     = regexp.MustCompile(`^=?\s*v?[0-9]+\.[0-9]+\.[0-9]+([-+][0-9A-Za-z.-]+)?$`)
+	nonNameCharRegexp      = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+)
+
+// Download The Remote Sources Of Every Module Block In The Staged Code Into The modules Folder Of The Stage Working
+// Directory And Rewrite The source Attributes To Relative Paths, So Terraform Never Has To Fetch Them (Or Have The
+// Credentials To) At Init Time.   Modules Referenced With Relative Paths Inside stageDir And The Vendored Modules
+// Themselves Are Followed, So Nested Module Calls Are Vendored Too.   Every Package Is Downloaded Once, With The Same
+// Getters (And Git Options, Lock File And Retries) As The Stage Itself.   The Files Written Or Rewritten Are Added To
+// rewritten.
+func vendorModules(terragruntOptions *options.TerragruntOptions, stageOptions *StageOptions, terragruntConfig *config.TerragruntConfig, stageDir string, rewritten map[string]bool) error {
+	workingDir := terragruntOptions.WorkingDir
+	vendorDir := filepath.Join(workingDir, VendoredModulesDir)
+
+	vendored := map[string]string{}
+	return walkStagedModuleCalls(terragruntOptions.Logger, workingDir, stageDir, func(dir string, call ModuleCall) (string, error) {
+		source, err := vendoredModuleSource(call)
+		if err != nil {
+			return "", err
+		}
+
+		target, err := vendorModuleSource(terragruntOptions, stageOptions, terragruntConfig, source, dir, vendorDir, vendored, rewritten)
+		if err != nil {
+			return "", err
+		}
+
+		relPath, err := filepath.Rel(dir, target)
+		if err != nil {
+			return "", errors.WithStackTrace(err)
+		}
+		if err := rewriteModuleSource(call, localModulePath(relPath)); err != nil {
+			return "", err
+		}
+		rewritten[call.File] = true
+		return target, nil
+	})
+}
+
+// Download One Module Source Into The Vendor Folder, Unless It Was Already Downloaded By This Stage, And Return The
+// Folder Of The Module (The Package Folder Joined With The Part Of The Source After //).   Sources Outside Of The
+// Module Allowlist In The Terrastage Config Are An Error.
+func vendorModuleSource(terragruntOptions *options.TerragruntOptions, stageOptions *StageOptions, terragruntConfig *config.TerragruntConfig, source string, dir string, vendorDir string, vendored map[string]string, rewritten map[string]bool) (string, error) {
+	sourceURL, err := ToSourceUrl(source, dir)
+	if err != nil {
+		return "", err
+	}
+	if err := stageOptions.AllowedModuleSources.check(AllowedModuleSourcesBlock, sourceURL); err != nil {
+		return "", err
+	}
+	rootSourceURL, modulePath, err := SplitSourceUrl(sourceURL, terragruntOptions.Logger)
+	if err != nil {
+		return "", err
+	}
+
+	key := rootSourceURL.String()
+	packageDir, ok := vendored[key]
+	if !ok {
+		packageDir = filepath.Join(vendorDir, vendoredPackageName(rootSourceURL.Path, key))
+		packageSource := &Source{
+			CanonicalSourceURL: rootSourceURL,
+			DownloadDir:        packageDir,
+			WorkingDir:         filepath.Join(packageDir, filepath.FromSlash(modulePath)),
+			ModulePath:         modulePath,
+			Record:             &DownloadRecord{},
+			Policy:             stageOptions.AllowedModuleSources,
+			PolicyName:         AllowedModuleSourcesBlock,
+			Logger:             terragruntOptions.Logger,
+		}
+
+		// Vendored Packages Are Downloaded Fresh Whenever A Source Is Rewritten, And Their Version Control
+		// Folders Dropped So They Are Plain Files In The Stage
+		if err := os.RemoveAll(packageDir); err != nil {
+			return "", errors.WithStackTrace(err)
+		}
+		if err := downloadSource(packageSource, terragruntOptions, stageOptions, terragruntConfig); err != nil {
+			return "", DownloadingTerraformSourceErr{ErrMsg: err, Url: rootSourceURL.Redacted()}
+		}
+		if err := os.RemoveAll(filepath.Join(packageDir, ".git")); err != nil {
+			return "", errors.WithStackTrace(err)
+		}
+
+		if err := addFiles(rewritten, packageDir); err != nil {
+			return "", err
+		}
+		vendored[key] = packageDir
+	}
+
+	target := filepath.Join(packageDir, filepath.FromSlash(modulePath))
+	if !util.IsDir(target) {
+		return "", errors.WithStackTrace(WorkingDirNotFound{Source: sourceURL.Redacted(), Dir: target})
+	}
+	return target, nil
+}
+
+// The Source To Download For A Module Call: Its source, Or For A Terraform Registry Module The tfr:// URL Of Its
+// version, Which The tfr Getter Resolves When It Is A Constraint (Or The Newest Version When There Is None)
+func vendoredModuleSource(call ModuleCall) (string, error) {
+	host, modulePath, subdir, ok := parseRegistryModuleSource(call.Source)
+	if !ok {
+		if call.Version != "" {
+			return "", errors.WithStackTrace(fmt.Errorf("%s: module %q has a version but its source %q isn't a registry module", call.File, call.Name, call.Source))
+		}
+		return call.Source, nil
+	}
+
+	source := fmt.Sprintf("tfr://%s/%s", host, modulePath)
+	if subdir != "" {
+		source += "//" + subdir
+	}
+	if strings.TrimSpace(call.Version) == "" {
+		return source, nil
+	}
+	return source + "?version=" + url.QueryEscape(registryVersion(call.Version)), nil
+}
+
+// Parse A Terraform Registry Module Address, [hostname/]namespace/name/provider[//subdir].   The Host Is Left Empty
+// For The Public Registry, Which The tfr Getter Defaults To.
+func parseRegistryModuleSource(source string) (string, string, string, bool) {
+	if strings.Contains(source, "::") || strings.Contains(source, "://") || strings.HasPrefix(source, "/") {
+		return "", "", "", false
+	}
+
+	address, subdir := source, ""
+	if i := strings.Index(source, "//"); i >= 0 {
+		address, subdir = source[:i], source[i+2:]
+	}
+
+	parts := strings.Split(address, "/")
+	host := ""
+	if len(parts) == 4 {
+		host, parts = parts[0], parts[1:]
+		if !strings.Contains(host, ".") && host != "localhost" && !strings.HasPrefix(host, "localhost:") {
+			return "", "", "", false
+		}
+	}
+	if len(parts) != 3 {
+		return "", "", "", false
+	}
+	for _, part := range parts {
+		if !registryNamePartRegexp.MatchString(part) {
+			return "", "", "", false
+		}
+	}
+	if host == "registry.terraform.io" {
+		host = ""
+	}
+
+	return host, strings.Join(parts, "/"), subdir, true
+}
+
+// Name Of The Vendor Folder For A Package: The Last Element Of Its Path, Plus A Hash Of The Full Source So Different
+// Refs And Repos With The Same Name Don't Collide
+func vendoredPackageName(sourcePath string, key string) string {
+	name := strings.TrimSuffix(path.Base(strings.TrimRight(sourcePath, "/")), ".git")
+	name = strings.Trim(nonNameCharRegexp.ReplaceAllString(name, "-"), "-.")
+	if name == "" {
+		name = "module"
+	}
+	hash := sha256.Sum256([]byte(key))
+	return fmt.Sprintf("%s-%x", name, hash[:4])
+}
+
+// Terraform Only Treats A Module Source As A Local Path When It Starts With ./ Or ../
+func localModulePath(relPath string) string {
+	relPath = filepath.ToSlash(relPath)
+	if relPath == "." {
+		return "./"
+	}
+	if escapesDir(relPath) {
+		return relPath
+	}
+	return "./" + relPath
+}
+
+// Set The source Attribute Of A Module Block To A Local Path, Dropping Its version (Which Terraform Doesn't Allow For
+// Local Paths).   Everything Else In The File Is Left As It Was.
+func rewriteModuleSource(call ModuleCall, source string) error {
+	return rewriteModuleAttributes(call, map[string]string{"source": source}, []string{"version"})
+}
+
+// Set String Attributes Of A Module Block And Remove Others, Leaving Everything Else In The File As It Was
+func rewriteModuleAttributes(call ModuleCall, set map[string]string, remove []string) error {
+	contents, err := os.ReadFile(call.File)
+	if err != nil {
+		return errors.WithStackTrace(err)
+	}
+	info, err := os.Stat(call.File)
+	if err != nil {
+		return errors.WithStackTrace(err)
+	}
+
+	if strings.HasSuffix(call.File, ".json") {
+		contents, err = rewriteJSONModuleAttributes(contents, call.Name, set, remove)
+		if err != nil {
+			return errors.WithStackTrace(fmt.Errorf("%s: %w", call.File, err))
+		}
+	} else {
+		file, diags := hclwrite.ParseConfig(contents, call.File, hcl.InitialPos)
+		if diags.HasErrors() {
+			return errors.WithStackTrace(diags)
+		}
+		block := file.Body().FirstMatchingBlock("module", []string{call.Name})
+		if block == nil {
+			return errors.WithStackTrace(fmt.Errorf("%s: module %q not found", call.File, call.Name))
+		}
+		names := []string{}
+		for name := range set {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			block.Body().SetAttributeValue(name, cty.StringVal(set[name]))
+		}
+		for _, name := range remove {
+			block.Body().RemoveAttribute(name)
+		}
+		contents = file.Bytes()
+	}
+
+	return errors.WithStackTrace(os.WriteFile(call.File, contents, info.Mode().Perm()))
+}
+
+// The Same For A Module Block In A .tf.json File, Where module Is An Object (Or A List Of Objects) Keyed By Name
+func rewriteJSONModuleAttributes(contents []byte, name string, set map[string]string, remove []string) ([]byte, error) {
+	document := map[string]interface{}{}
+	if err := json.Unmarshal(contents, &document); err != nil {
+		return nil, err
+	}
+
+	found := false
+	rewrite := func(modules interface{}) {
+		moduleMap, ok := modules.(map[string]interface{})
+		if !ok {
+			return
+		}
+		blocks := []interface{}{moduleMap[name]}
+		if list, ok := moduleMap[name].([]interface{}); ok {
+			blocks = list
+		}
+		for _, block := range blocks {
+			if attributes, ok := block.(map[string]interface{}); ok {
+				for attribute, value := range set {
+					attributes[attribute] = value
+				}
+				for _, attribute := range remove {
+					delete(attributes, attribute)
+				}
+				found = true
+			}
+		}
+	}
+	if list, ok := document["module"].([]interface{}); ok {
+		for _, modules := range list {
+			rewrite(modules)
+		}
+	} else {
+		rewrite(document["module"])
+	}
+	if !found {
+		return nil, fmt.Errorf("module %q not found", name)
+	}
+
+	updated, err := json.MarshalIndent(document, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(updated, '\n'), nil
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gruntwork-io/terragrunt/options"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Write Terraform Files, Relative To dir, Creating Their Folders
+func writeTestTerraformFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+
+	for relPath, contents := range files {
+		file := filepath.Join(dir, filepath.FromSlash(relPath))
+		require.NoError(t, os.MkdirAll(filepath.Dir(file), os.ModePerm))
+		require.NoError(t, os.WriteFile(file, []byte(contents), 0644))
+	}
+}
+
+func TestVendorModulesStaysInsideTheStage(t *testing.T) {
+	t.Parallel()
+
+	// The Live Tree And A Sibling Stage Next To The Stage, Whose Remote Sources Would Fail To Download If They Were
+	// Ever Followed
+	const unreachable = `module "remote" {
+  source = "git::https://example.invalid/modules.git//vpc?ref=v1.0.0"
+}
+`
+	root := t.TempDir()
+	outsideFiles := map[string]string{
+		"live/modules/outside/main.tf": unreachable,
+		"stage/other/modules/main.tf":  unreachable,
+	}
+	writeTestTerraformFiles(t, root, outsideFiles)
+
+	stageDir := filepath.Join(root, "stage", "app")
+	writeTestTerraformFiles(t, stageDir, map[string]string{
+		"live/main.tf": `module "inside" {
+  source = "../modules/inside"
+}
+
+module "live" {
+  source = "../../../live/modules/outside"
+}
+
+module "sibling" {
+  source = "../../other/modules"
+}
+`,
+		"modules/inside/main.tf": `module "nested" {
+  source = "./nested"
+}
+`,
+		"modules/inside/nested/main.tf": "# nested\n",
+	})
+	require.NoError(t, os.Symlink(filepath.Join(root, "live", "modules", "outside"), filepath.Join(stageDir, "modules", "link")))
+	writeTestTerraformFiles(t, stageDir, map[string]string{"modules/inside/nested/link.tf": `module "link" {
+  source = "../../link"
+}
+`})
+
+	terragruntOptions := options.NewTerragruntOptions()
+	terragruntOptions.WorkingDir = filepath.Join(stageDir, "live")
+	rewritten := map[string]bool{}
+
+	require.NoError(t, vendorModules(terragruntOptions, NewStageOptions(), nil, stageDir, rewritten))
+
+	assert.Empty(t, rewritten)
+	for relPath, contents := range outsideFiles {
+		written, err := os.ReadFile(filepath.Join(root, filepath.FromSlash(relPath)))
+		require.NoError(t, err)
+		assert.Equal(t, contents, string(written), relPath)
+	}
+	assert.NoDirExists(t, filepath.Join(root, "live", "modules", "outside", VendoredModulesDir))
+	assert.NoDirExists(t, filepath.Join(root, "stage", "other", "modules", VendoredModulesDir))
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/gruntwork-io/go-commons/errors"
+	"github.com/gruntwork-io/terragrunt/util"
+)
+
+// The Username Docker Credential Helpers Return When The Secret Is An Identity (Refresh) Token Rather Than A Password
+const ociIdentityTokenUsername = "<token>"
+
+// Credentials For An OCI Registry, Taken From A Docker Config File Or A Credential Helper It Names
+type ociCredentials struct {
+	Username      string
+	Password      string
+	IdentityToken string
+
+	// Where The Credentials Came From (Never The Credentials Themselves)
+	Source string
+}
+
+// The Parts Of A Docker Config File (Or A Podman auth.json, Which Is Laid Out The Same) That Hold Credentials
+type dockerConfigFile struct {
+	Auths map[string]struct {
+		Auth          string `json:"auth"`
+		Username      string `json:"username"`
+		Password      string `json:"password"`
+		IdentityToken string `json:"identitytoken"`
+	} `json:"auths"`
+	CredHelpers map[string]string `json:"credHelpers"`
+	CredsStore  string            `json:"credsStore"`
+}
+
+// Find The Credentials For An OCI Registry Host The Way docker And podman Do: In REGISTRY_AUTH_FILE, Then
+// $XDG_RUNTIME_DIR/containers/auth.json, Then The Docker Config ($DOCKER_CONFIG/config.json Or ~/.docker/config.json).
+// A Credential Helper Named For The Host (credHelpers), Or For Every Host (credsStore), Is Asked Before The auths
+// Entries Of The Same File.   Returns nil When There Are No Credentials For The Host, So It Is Accessed Anonymously.
+func findOCICredentials(host string) (*ociCredentials, error) {
+	for _, configPath := range ociConfigPaths() {
+		if !util.FileExists(configPath) {
+			continue
+		}
+		contents, err := os.ReadFile(configPath)
+		if err != nil {
+			return nil, errors.WithStackTrace(err)
+		}
+		configFile := dockerConfigFile{}
+		if err := json.Unmarshal(contents, &configFile); err != nil {
+			return nil, errors.WithStackTrace(fmt.Errorf("could not parse registry auth file %s: %w", configPath, err))
+		}
+
+		helper := configFile.CredHelpers[host]
+		if helper == "" {
+			helper = configFile.CredsStore
+		}
+		if helper != "" {
+			credentials, err := credentialHelperCredentials(helper, host)
+			if err != nil || credentials != nil {
+				return credentials, err
+			}
+		}
+
+		for authHost, auth := range configFile.Auths {
+			if !strings.EqualFold(ociConfigHost(authHost), host) {
+				continue
+			}
+			credentials := &ociCredentials{Username: auth.Username, Password: auth.Password, IdentityToken: auth.IdentityToken, Source: configPath}
+			if auth.Auth != "" {
+				decoded, err := base64.StdEncoding.DecodeString(auth.Auth)
+				if err != nil {
+					return nil, errors.WithStackTrace(fmt.Errorf("%s: the auth for %s is not valid base64: %w", configPath, host, err))
+				}
+				credentials.Username, credentials.Password, _ = strings.Cut(string(decoded), ":")
+			}
+			if credentials.Username == "" && credentials.IdentityToken == "" {
+				continue
+			}
+			return credentials, nil
+		}
+	}
+	return nil, nil
+}
+
+// Ask A Docker Credential Helper (docker-credential-<helper>) For The Credentials Of A Host.   Helpers Say When They
+// Have None With An Error Message, Which Isn't An Error Here.
+func credentialHelperCredentials(helper string, host string) (*ociCredentials, error) {
+	program := "docker-credential-" + helper
+	command := exec.Command(program, "get")
+	command.Stdin = strings.NewReader(host)
+	var stdout, stderr bytes.Buffer
+	command.Stdout = &stdout
+	command.Stderr = &stderr
+	if err := command.Run(); err != nil {
+		output := strings.TrimSpace(stdout.String() + stderr.String())
+		if strings.Contains(strings.ToLower(output), "credentials not found") {
+			return nil, nil
+		}
+		return nil, errors.WithStackTrace(fmt.Errorf("credential helper %s failed for %s: %w: %s", program, host, err, output))
+	}
+
+	response := struct {
+		Username string `json:"Username"`
+		Secret   string `json:"Secret"`
+	}{}
+	if err := json.Unmarshal(stdout.Bytes(), &response); err != nil {
+		return nil, errors.WithStackTrace(fmt.Errorf("credential helper %s returned invalid credentials for %s: %w", program, host, err))
+	}
+	if response.Username == ociIdentityTokenUsername {
+		return &ociCredentials{IdentityToken: response.Secret, Source: program}, nil
+	}
+	return &ociCredentials{Username: response.Username, Password: response.Secret, Source: program}, nil
+}
+
+// Registry Auth Files In The Order They Are Searched
+func ociConfigPaths() []string {
+	paths := []string{}
+	if authFile := os.Getenv("REGISTRY_AUTH_FILE"); authFile != "" {
+		paths = append(paths, authFile)
+	}
+	if runtimeDir := os.Getenv("XDG_RUNTIME_DIR"); runtimeDir != "" {
+		paths = append(paths, filepath.Join(runtimeDir, "containers", "auth.json"))
+	}
+	if configDir := os.Getenv("DOCKER_CONFIG"); configDir != "" {
+		paths = append(paths, filepath.Join(configDir, "config.json"))
+	} else if home, err := os.UserHomeDir(); err == nil {
+		paths = append(paths, filepath.Join(home, ".docker", "config.json"))
+	}
+	return paths
+}
+
+// The Host Of An auths Key, Which Can Be A Bare Host Or A URL (e.g. https://index.docker.io/v1/)
+func ociConfigHost(key string) string {
+	if _, rest, ok := strings.Cut(key, "://"); ok {
+		key = rest
+	}
+	host, _, _ := strings.Cut(key, "/")
+	return host
+}
@@ -0,0 +1,584 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/gruntwork-io/go-commons/errors"
+	"github.com/hashicorp/go-getter"
+)
+
+// Media Types Of The Manifests A Module Artifact Can Be Pushed With, And Of The Indexes It Can't
+const (
+	ociManifestMediaType        = "application/vnd.oci.image.manifest.v1+json"
+	dockerManifestMediaType     = "application/vnd.docker.distribution.manifest.v2+json"
+	ociIndexMediaType           = "application/vnd.oci.image.index.v1+json"
+	dockerManifestListMediaType = "application/vnd.docker.distribution.manifest.list.v2+json"
+)
+
+// The Annotation Naming The File A Layer That Isn't An Archive Is Written To
+const ociTitleAnnotation = "org.opencontainers.image.title"
+
+// The Tag Pulled When A Source Names Neither A Tag Nor A Digest
+const ociDefaultTag = "latest"
+
+// Manifests Are Small, Anything Bigger Than This Isn't One
+const ociMaxManifestSize = 4 << 20
+
+var (
+	ociRepositoryRegexp = regexp.MustCompile(`^[a-z0-9]+((\.|_|__|-+)[a-z0-9]+)*(/[a-z0-9]+((\.|_|__|-+)[a-z0-9]+)*)*$`)
+	ociTagRegexp        = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9._-]{0,127}$`)
+	ociDigestRegexp     = regexp.MustCompile(`^(sha256:[a-f0-9]{64}|sha512:[a-f0-9]{128})$`)
+	ociChallengeRegexp  = regexp.MustCompile(`([A-Za-z_]+)="([^"]*)"`)
+)
+
+// A Custom getter.Getter For Module Artifacts In An OCI Registry
+// (oci::<host>/<repository>?tag=<tag> Or oci::<host>/<repository>?digest=sha256:<hex>).   The Manifest Is Pulled By
+// Tag Or Digest, And It And Every Layer Are Checked Against Their Digests Before Anything Is Written To The Stage.
+// Archive Layers (tar, tar+gzip, zip) Are Unpacked Into The Stage, Other Layers Are Written To The File Their Title
+// Annotation Names.   Registries Are Authenticated To With The Credentials In The Docker Config Files.   Plain HTTP
+// Registries Are Named With oci::http://<host>/<repository>.
+type OCIGetter struct {
+	// The Digest Pulled, And Where The Credentials Used Came From, Are Reported Here When It Is Set
+	Record *DownloadRecord
+
+	// The Digest Each Tag Points At Is Recorded In Lock When It Is Set.   In Locked Mode The Tag Must Still Point At
+	// The Locked Digest, And The Download Fails If The Tag Isn't Locked.
+	Lock   *LockFile
+	Locked bool
+
+	// Registries May Redirect Layers To Storage On Another Host, Which Must Be Allowed By Policy, The Allowlist
+	// Block Of The Terrastage Config Named PolicyName, When It Is Set
+	Policy     *SourcePolicy
+	PolicyName string
+
+	client *getter.Client
+}
+
+func (g *OCIGetter) SetClient(client *getter.Client) { g.client = client }
+
+func (g *OCIGetter) context() context.Context {
+	if g.client == nil || g.client.Ctx == nil {
+		return context.Background()
+	}
+	return g.client.Ctx
+}
+
+func (g *OCIGetter) ClientMode(u *url.URL) (getter.ClientMode, error) {
+	return getter.ClientModeDir, nil
+}
+
+func (g *OCIGetter) GetFile(dst string, u *url.URL) error {
+	return errors.WithStackTrace(fmt.Errorf("oci sources are modules, they can't be downloaded as a single file"))
+}
+
+func (g *OCIGetter) Get(dst string, u *url.URL) error {
+	ctx := g.context()
+
+	reference, err := parseOCIReference(u)
+	if err != nil {
+		return err
+	}
+	registry, err := newOCIClient(reference)
+	if err != nil {
+		return err
+	}
+	if registry.credentials != nil && g.Record != nil {
+		g.Record.AuthSource = registry.credentials.Source
+	}
+	registry.checkLocation = func(location *url.URL) error {
+		return g.Policy.checkLocation(g.PolicyName, u, location)
+	}
+
+	// A Tag Is Locked To The Digest It Points At, The Same As A Git Ref Is Locked To A Commit
+	expected, locked := reference.Digest, false
+	if expected == "" && g.Lock != nil && g.Locked {
+		lockedDigest, ok := g.Lock.Lookup(reference.lockURL(), reference.Tag)
+		if !ok {
+			return errors.WithStackTrace(LockedRefMissing{Source: reference.lockURL().String(), Ref: reference.Tag})
+		}
+		expected, locked = lockedDigest, true
+	}
+
+	manifest, digest, err := registry.manifest(ctx, reference, ociDigestAlgorithm(expected))
+	if err != nil {
+		return err
+	}
+	if expected != "" && digest != expected {
+		if locked {
+			return errors.WithStackTrace(LockedRefMoved{Source: reference.lockURL().String(), Ref: reference.Tag, Locked: expected, Current: digest})
+		}
+		return errors.WithStackTrace(OCIDigestMismatch{Content: "manifest of " + reference.String(), Expected: expected, Actual: digest})
+	}
+	if len(manifest.Layers) == 0 {
+		return errors.WithStackTrace(fmt.Errorf("oci artifact %s has no layers", reference))
+	}
+
+	// Every Layer Is Downloaded And Verified Before The Stage Is Touched
+	if err := os.MkdirAll(filepath.Dir(dst), os.ModePerm); err != nil {
+		return errors.WithStackTrace(err)
+	}
+	tmpDir, err := os.MkdirTemp(filepath.Dir(dst), ".terrastage-oci-")
+	if err != nil {
+		return errors.WithStackTrace(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	blobs := make([]string, len(manifest.Layers))
+	for i, layer := range manifest.Layers {
+		blobs[i] = filepath.Join(tmpDir, fmt.Sprintf("layer-%d", i))
+		if err := registry.blob(ctx, reference, layer, blobs[i]); err != nil {
+			return err
+		}
+	}
+
+	// The Tag Is Only Locked To The Digest Once Everything It Points At Has Been Verified
+	if g.Lock != nil && !g.Locked && reference.Digest == "" {
+		g.Lock.Record(reference.lockURL(), reference.Tag, digest)
+	}
+	if g.Record != nil {
+		g.Record.ResolvedDigest = digest
+	}
+
+	if err := clearStage(dst); err != nil {
+		return err
+	}
+	for i, layer := range manifest.Layers {
+		if err := unpackOCILayer(layer, blobs[i], dst); err != nil {
+			return errors.WithStackTrace(fmt.Errorf("unpacking layer %s of %s: %w", layer.Digest, reference, err))
+		}
+	}
+	return nil
+}
+
+// An Artifact In An OCI Registry, As Named By An oci Source
+type ociReference struct {
+	// https, Or http For Registries Named With oci::http://
+	Scheme     string
+	Host       string
+	Repository string
+	Tag        string
+	Digest     string
+}
+
+func parseOCIReference(u *url.URL) (ociReference, error) {
+	reference := ociReference{
+		Scheme:     u.Scheme,
+		Host:       u.Host,
+		Repository: strings.Trim(u.Path, "/"),
+		Tag:        u.Query().Get("tag"),
+		Digest:     u.Query().Get("digest"),
+	}
+	if reference.Scheme == "oci" {
+		reference.Scheme = "https"
+	}
+	if reference.Scheme != "https" && reference.Scheme != "http" {
+		return reference, errors.WithStackTrace(fmt.Errorf("oci source %s must be oci::<host>/<repository>, or oci::http://<host>/<repository> for a plain HTTP registry", u.Redacted()))
+	}
+	if reference.Host == "" || !ociRepositoryRegexp.MatchString(reference.Repository) {
+		return reference, errors.WithStackTrace(fmt.Errorf("oci source %s must name a registry host and a repository in lower case", u.Redacted()))
+	}
+	if reference.Tag != "" && !ociTagRegexp.MatchString(reference.Tag) {
+		return reference, errors.WithStackTrace(fmt.Errorf("oci source %s has an invalid tag %q", u.Redacted(), reference.Tag))
+	}
+	if reference.Digest != "" && !ociDigestRegexp.MatchString(reference.Digest) {
+		return reference, errors.WithStackTrace(fmt.Errorf("oci source %s has an invalid digest %q, it must be sha256:<hex> or sha512:<hex>", u.Redacted(), reference.Digest))
+	}
+	if reference.Tag == "" && reference.Digest == "" {
+		reference.Tag = ociDefaultTag
+	}
+	return reference, nil
+}
+
+// The Tag The Manifest Is Pulled By, Which Is Checked Against The Digest When There Is Both, Or Else The Digest
+func (reference ociReference) manifestReference() string {
+	if reference.Tag != "" {
+		return reference.Tag
+	}
+	return reference.Digest
+}
+
+// The URL The Tag Of An Artifact Is Locked Under In The Lock File, Whatever The Registry Is Reached With
+func (reference ociReference) lockURL() *url.URL {
+	return &url.URL{Scheme: "oci", Host: reference.Host, Path: "/" + reference.Repository}
+}
+
+func (reference ociReference) String() string {
+	if reference.Tag != "" {
+		return fmt.Sprintf("%s/%s:%s", reference.Host, reference.Repository, reference.Tag)
+	}
+	return fmt.Sprintf("%s/%s@%s", reference.Host, reference.Repository, reference.Digest)
+}
+
+// Return The URL An oci Source Is Locked Under In The Lock File And The Tag It Is Locked For, For oci Sources Pulled By
+// Tag Rather Than By Digest
+func ociLockKey(u *url.URL) (*url.URL, string, bool) {
+	if u.Scheme != "oci" && !strings.HasPrefix(u.Scheme, "oci::") {
+		return nil, "", false
+	}
+	reference, err := parseOCIReference(&url.URL{Scheme: strings.TrimPrefix(u.Scheme, "oci::"), Host: u.Host, Path: u.Path, RawQuery: u.RawQuery})
+	if err != nil || reference.Digest != "" {
+		return nil, "", false
+	}
+	return reference.lockURL(), reference.Tag, true
+}
+
+// Requests To The Repository Of An Artifact, With A Token From The Registry's Auth Service (Or Basic Auth) Once The
+// Registry Asks For One
+type ociClient struct {
+	baseURL       string
+	repository    string
+	credentials   *ociCredentials
+	authorization string
+
+	// Checks Where The Registry Redirects A Request To, When It Is Set
+	checkLocation func(location *url.URL) error
+}
+
+func newOCIClient(reference ociReference) (*ociClient, error) {
+	credentials, err := findOCICredentials(reference.Host)
+	if err != nil {
+		return nil, err
+	}
+	return &ociClient{
+		baseURL:     fmt.Sprintf("%s://%s/v2/%s", reference.Scheme, reference.Host, reference.Repository),
+		repository:  reference.Repository,
+		credentials: credentials,
+	}, nil
+}
+
+// The Parts Of An OCI Image Manifest That Matter For A Module Artifact
+type ociManifest struct {
+	MediaType string          `json:"mediaType"`
+	Layers    []ociDescriptor `json:"layers"`
+}
+
+type ociDescriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      string            `json:"digest"`
+	Size        int64             `json:"size"`
+	Annotations map[string]string `json:"annotations"`
+}
+
+// Pull The Manifest Of An Artifact And Return It With Its Digest, Calculated With The Given Algorithm From The Bytes
+// The Registry Served Rather Than Taken From The Registry's Word For It
+func (registry *ociClient) manifest(ctx context.Context, reference ociReference, algorithm string) (*ociManifest, string, error) {
+	manifestURL := registry.baseURL + "/manifests/" + reference.manifestReference()
+	response, err := registry.get(ctx, manifestURL, ociManifestMediaType, dockerManifestMediaType, ociIndexMediaType, dockerManifestListMediaType)
+	if err != nil {
+		return nil, "", err
+	}
+	defer response.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(response.Body, ociMaxManifestSize+1))
+	if err != nil {
+		return nil, "", errors.WithStackTrace(err)
+	}
+	if len(body) > ociMaxManifestSize {
+		return nil, "", errors.WithStackTrace(fmt.Errorf("the manifest of %s is bigger than %d bytes", reference, ociMaxManifestSize))
+	}
+
+	digest := ociDigest(algorithm, body)
+	if served := response.Header.Get("Docker-Content-Digest"); served != "" && ociDigestAlgorithm(served) == algorithm && served != digest {
+		return nil, "", errors.WithStackTrace(OCIDigestMismatch{Content: "manifest of " + reference.String(), Expected: served, Actual: digest})
+	}
+	if reference.Tag == "" && digest != reference.Digest {
+		return nil, "", errors.WithStackTrace(OCIDigestMismatch{Content: "manifest of " + reference.String(), Expected: reference.Digest, Actual: digest})
+	}
+
+	manifest := &ociManifest{}
+	if err := json.Unmarshal(body, manifest); err != nil {
+		return nil, "", errors.WithStackTrace(fmt.Errorf("the manifest of %s is invalid: %w", reference, err))
+	}
+	mediaType := manifest.MediaType
+	if mediaType == "" {
+		mediaType, _, _ = strings.Cut(response.Header.Get("Content-Type"), ";")
+	}
+	switch mediaType {
+	case ociIndexMediaType, dockerManifestListMediaType:
+		return nil, "", errors.WithStackTrace(fmt.Errorf("%s is an image index, not a module artifact", reference))
+	case ociManifestMediaType, dockerManifestMediaType, "":
+		return manifest, digest, nil
+	}
+	return nil, "", errors.WithStackTrace(fmt.Errorf("%s has an unsupported manifest media type %s", reference, mediaType))
+}
+
+// Download A Layer Into A File, Checking Its Size And Digest Against The Manifest
+func (registry *ociClient) blob(ctx context.Context, reference ociReference, layer ociDescriptor, dst string) error {
+	if !ociDigestRegexp.MatchString(layer.Digest) {
+		return errors.WithStackTrace(fmt.Errorf("the manifest of %s has a layer with an invalid digest %q", reference, layer.Digest))
+	}
+	response, err := registry.get(ctx, registry.baseURL+"/blobs/"+layer.Digest)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	file, err := os.Create(dst)
+	if err != nil {
+		return errors.WithStackTrace(err)
+	}
+	defer file.Close()
+
+	digester := ociHash(ociDigestAlgorithm(layer.Digest))
+	size, err := io.Copy(io.MultiWriter(file, digester), io.LimitReader(response.Body, layer.Size+1))
+	if err != nil {
+		return errors.WithStackTrace(err)
+	}
+	if size != layer.Size {
+		return errors.WithStackTrace(OCIDigestMismatch{Content: "layer " + layer.Digest + " of " + reference.String(), Expected: fmt.Sprintf("%d bytes", layer.Size), Actual: fmt.Sprintf("%d bytes", size)})
+	}
+	if digest := ociDigestAlgorithm(layer.Digest) + ":" + hex.EncodeToString(digester.Sum(nil)); digest != layer.Digest {
+		return errors.WithStackTrace(OCIDigestMismatch{Content: "layer " + layer.Digest + " of " + reference.String(), Expected: layer.Digest, Actual: digest})
+	}
+	return errors.WithStackTrace(file.Close())
+}
+
+// Send A GET To The Registry, Getting A Token (Or Using Basic Auth) The First Time The Registry Asks For One
+func (registry *ociClient) get(ctx context.Context, requestURL string, accept ...string) (*http.Response, error) {
+	for challenged := false; ; challenged = true {
+		request, err := http.NewRequestWithContext(ctx, http.MethodGet, requestURL, nil)
+		if err != nil {
+			return nil, errors.WithStackTrace(err)
+		}
+		for _, mediaType := range accept {
+			request.Header.Add("Accept", mediaType)
+		}
+		if registry.authorization != "" {
+			request.Header.Set("Authorization", registry.authorization)
+		}
+
+		response, err := (&http.Client{CheckRedirect: registry.checkRedirect}).Do(request)
+		if err != nil {
+			return nil, errors.WithStackTrace(err)
+		}
+		if response.StatusCode >= 200 && response.StatusCode <= 299 {
+			return response, nil
+		}
+		response.Body.Close()
+
+		if response.StatusCode == http.StatusUnauthorized && !challenged {
+			if err := registry.authorize(ctx, response.Header.Get("WWW-Authenticate")); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if response.StatusCode == http.StatusUnauthorized || response.StatusCode == http.StatusForbidden {
+			return nil, registry.authFailed(requestURL, response.Status)
+		}
+		return nil, errors.WithStackTrace(OCIRequestFailed{URL: requestURL, Status: response.StatusCode})
+	}
+}
+
+// Follow A Redirect Of The Registry (Like Go Does, Up To 10 Times) Only To A Location checkLocation Allows
+func (registry *ociClient) checkRedirect(request *http.Request, via []*http.Request) error {
+	if len(via) >= 10 {
+		return errors.WithStackTrace(fmt.Errorf("stopped after 10 redirects"))
+	}
+	if registry.checkLocation == nil {
+		return nil
+	}
+	return registry.checkLocation(request.URL)
+}
+
+// Answer The Registry's Challenge: Basic Auth With The Credentials, Or A Bearer Token From Its Auth Service
+func (registry *ociClient) authorize(ctx context.Context, challenge string) error {
+	scheme, _, _ := strings.Cut(challenge, " ")
+	params := map[string]string{}
+	for _, match := range ociChallengeRegexp.FindAllStringSubmatch(challenge, -1) {
+		params[strings.ToLower(match[1])] = match[2]
+	}
+
+	switch strings.ToLower(scheme) {
+	case "basic":
+		if registry.credentials == nil || registry.credentials.Username == "" {
+			return registry.authFailed(registry.baseURL, "basic auth required")
+		}
+		registry.authorization = "Basic " + base64.StdEncoding.EncodeToString([]byte(registry.credentials.Username+":"+registry.credentials.Password))
+		return nil
+	case "bearer":
+		token, err := registry.token(ctx, params)
+		if err != nil {
+			return err
+		}
+		registry.authorization = "Bearer " + token
+		return nil
+	}
+	return errors.WithStackTrace(fmt.Errorf("registry %s asked for unsupported authentication %q", registry.baseURL, challenge))
+}
+
+// Get A Pull Token For The Repository From The Registry's Auth Service, Anonymously When There Are No Credentials
+func (registry *ociClient) token(ctx context.Context, params map[string]string) (string, error) {
+	realm, err := url.Parse(params["realm"])
+	if err != nil || params["realm"] == "" {
+		return "", errors.WithStackTrace(fmt.Errorf("registry %s asked for a token without a valid realm", registry.baseURL))
+	}
+	scope := params["scope"]
+	if scope == "" {
+		scope = "repository:" + registry.repository + ":pull"
+	}
+
+	var request *http.Request
+	if registry.credentials != nil && registry.credentials.IdentityToken != "" {
+		form := url.Values{}
+		form.Set("grant_type", "refresh_token")
+		form.Set("refresh_token", registry.credentials.IdentityToken)
+		form.Set("service", params["service"])
+		form.Set("scope", scope)
+		form.Set("client_id", "terrastage")
+		request, err = http.NewRequestWithContext(ctx, http.MethodPost, realm.String(), strings.NewReader(form.Encode()))
+		if err != nil {
+			return "", errors.WithStackTrace(err)
+		}
+		request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	} else {
+		query := realm.Query()
+		if params["service"] != "" {
+			query.Set("service", params["service"])
+		}
+		query.Set("scope", scope)
+		realm.RawQuery = query.Encode()
+		request, err = http.NewRequestWithContext(ctx, http.MethodGet, realm.String(), nil)
+		if err != nil {
+			return "", errors.WithStackTrace(err)
+		}
+		if registry.credentials != nil && registry.credentials.Username != "" {
+			request.SetBasicAuth(registry.credentials.Username, registry.credentials.Password)
+		}
+	}
+
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return "", errors.WithStackTrace(err)
+	}
+	defer response.Body.Close()
+	if response.StatusCode == http.StatusUnauthorized || response.StatusCode == http.StatusForbidden {
+		return "", registry.authFailed(realm.Redacted(), response.Status)
+	}
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return "", errors.WithStackTrace(OCIRequestFailed{URL: realm.Redacted(), Status: response.StatusCode})
+	}
+
+	tokenResponse := struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}{}
+	if err := json.NewDecoder(response.Body).Decode(&tokenResponse); err != nil {
+		return "", errors.WithStackTrace(fmt.Errorf("the auth service of %s returned an invalid token: %w", registry.baseURL, err))
+	}
+	if tokenResponse.Token != "" {
+		return tokenResponse.Token, nil
+	}
+	if tokenResponse.AccessToken != "" {
+		return tokenResponse.AccessToken, nil
+	}
+	return "", errors.WithStackTrace(fmt.Errorf("the auth service of %s returned no token", registry.baseURL))
+}
+
+func (registry *ociClient) authFailed(requestURL string, status string) error {
+	source := ""
+	if registry.credentials != nil {
+		source = registry.credentials.Source
+	}
+	return errors.WithStackTrace(OCIAuthFailed{URL: requestURL, Status: status, CredentialsSource: source})
+}
+
+// Unpack A Verified Layer Into The Stage: Archives Are Extracted, Anything Else Is Written To Its Title
+func unpackOCILayer(layer ociDescriptor, blob string, dst string) error {
+	if archive := ociLayerArchive(layer.MediaType); archive != "" {
+		return errors.WithStackTrace(getter.Decompressors[archive].Decompress(dst, blob, true, 0))
+	}
+
+	title := filepath.FromSlash(layer.Annotations[ociTitleAnnotation])
+	if title == "" || !filepath.IsLocal(title) {
+		return errors.WithStackTrace(fmt.Errorf("layer media type %s isn't an archive and the layer has no %s annotation to name a file after", layer.MediaType, ociTitleAnnotation))
+	}
+	target := filepath.Join(dst, title)
+	if err := os.MkdirAll(filepath.Dir(target), os.ModePerm); err != nil {
+		return errors.WithStackTrace(err)
+	}
+	return errors.WithStackTrace(copyFile(blob, target, 0644))
+}
+
+// The go-getter Decompressor For A Layer Media Type, Or "" When The Layer Isn't An Archive
+func ociLayerArchive(mediaType string) string {
+	mediaType = strings.ToLower(mediaType)
+	switch {
+	case strings.HasSuffix(mediaType, "tar+gzip") || strings.HasSuffix(mediaType, "tar.gzip") || strings.HasSuffix(mediaType, "/x-gtar"):
+		return "tar.gz"
+	case strings.HasSuffix(mediaType, ".tar") || strings.HasSuffix(mediaType, "/x-tar"):
+		return "tar"
+	case strings.HasSuffix(mediaType, "/zip") || strings.HasSuffix(mediaType, "+zip"):
+		return "zip"
+	}
+	return ""
+}
+
+func ociDigestAlgorithm(digest string) string {
+	if strings.HasPrefix(digest, "sha512:") {
+		return "sha512"
+	}
+	return "sha256"
+}
+
+func ociHash(algorithm string) hash.Hash {
+	if algorithm == "sha512" {
+		return sha512.New()
+	}
+	return sha256.New()
+}
+
+func ociDigest(algorithm string, content []byte) string {
+	digester := ociHash(algorithm)
+	digester.Write(content)
+	return algorithm + ":" + hex.EncodeToString(digester.Sum(nil))
+}
+
+type OCIDigestMismatch struct {
+	Content  string
+	Expected string
+	Actual   string
+}
+
+func (err OCIDigestMismatch) Error() string {
+	return fmt.Sprintf("the %s doesn't match its digest: expected %s, got %s", err.Content, err.Expected, err.Actual)
+}
+
+type OCIAuthFailed struct {
+	URL               string
+	Status            string
+	CredentialsSource string
+}
+
+func (err OCIAuthFailed) Error() string {
+	if err.CredentialsSource == "" {
+		return fmt.Sprintf("oci request %s was refused (%s) and there are no credentials for the registry. Log in with docker login (or podman login) or add them to the docker config.", err.URL, err.Status)
+	}
+	return fmt.Sprintf("oci request %s was refused (%s) with the credentials from %s", err.URL, err.Status, err.CredentialsSource)
+}
+
+type OCIRequestFailed struct {
+	URL    string
+	Status int
+}
+
+func (err OCIRequestFailed) Error() string {
+	return fmt.Sprintf("oci request %s returned error: %d %s", err.URL, err.Status, http.StatusText(err.Status))
+}
+
+func (err OCIRequestFailed) StatusCode() int {
+	return err.Status
+}
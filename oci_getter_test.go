@@ -0,0 +1,448 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// The Repository Of Every Artifact In The Test Registry
+const testOCIRepository = "modules/vpc"
+
+// An OCI Registry On An httptest Server Serving The Artifacts Pushed To It.   With auth Set To basic, Every Request
+// Needs username And password, And With bearer, A Token From Its Auth Service, Which Needs Them When username Is Set.
+type testOCIRegistry struct {
+	server   *httptest.Server
+	host     string
+	auth     string
+	username string
+	password string
+
+	lock      sync.Mutex
+	manifests map[string][]byte
+	blobs     map[string][]byte
+
+	// Where Blobs Are Redirected To, Like A Registry Keeping Them In Storage On Another Host, When It Is Set
+	blobRedirect string
+}
+
+func newTestOCIRegistry(t *testing.T, auth string, username string, password string) *testOCIRegistry {
+	t.Helper()
+
+	registry := &testOCIRegistry{auth: auth, username: username, password: password, manifests: map[string][]byte{}, blobs: map[string][]byte{}}
+	registry.server = httptest.NewServer(http.HandlerFunc(registry.serveHTTP))
+	t.Cleanup(registry.server.Close)
+	registry.host = strings.TrimPrefix(registry.server.URL, "http://")
+	return registry
+}
+
+func (registry *testOCIRegistry) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/token" {
+		if user, pass, _ := r.BasicAuth(); registry.username != "" && (user != registry.username || pass != registry.password) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		fmt.Fprint(w, `{"token": "pull-token"}`)
+		return
+	}
+
+	switch registry.auth {
+	case "basic":
+		if user, pass, _ := r.BasicAuth(); user != registry.username || pass != registry.password {
+			w.Header().Set("WWW-Authenticate", `Basic realm="test"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+	case "bearer":
+		if r.Header.Get("Authorization") != "Bearer pull-token" {
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="test",scope="repository:%s:pull"`, registry.server.URL, testOCIRepository))
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+	}
+
+	registry.lock.Lock()
+	defer registry.lock.Unlock()
+	prefix := "/v2/" + testOCIRepository
+	switch {
+	case strings.HasPrefix(r.URL.Path, prefix+"/manifests/"):
+		manifest, ok := registry.manifests[strings.TrimPrefix(r.URL.Path, prefix+"/manifests/")]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write(manifest)
+	case strings.HasPrefix(r.URL.Path, prefix+"/blobs/") && registry.blobRedirect != "":
+		http.Redirect(w, r, registry.blobRedirect+r.URL.Path, http.StatusTemporaryRedirect)
+	case strings.HasPrefix(r.URL.Path, prefix+"/blobs/"):
+		blob, ok := registry.blobs[strings.TrimPrefix(r.URL.Path, prefix+"/blobs/")]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write(blob)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+// Push An Artifact With A Single tar+gzip Layer Holding The Files, Tagged tag, And Return The Digests Of Its Manifest
+// And Its Layer
+func (registry *testOCIRegistry) push(t *testing.T, tag string, files map[string]string) (string, string) {
+	t.Helper()
+
+	buffer := &bytes.Buffer{}
+	gzipWriter := gzip.NewWriter(buffer)
+	tarWriter := tar.NewWriter(gzipWriter)
+	for name, contents := range files {
+		require.NoError(t, tarWriter.WriteHeader(&tar.Header{Name: name, Mode: 0644, Typeflag: tar.TypeReg, Size: int64(len(contents))}))
+		_, err := tarWriter.Write([]byte(contents))
+		require.NoError(t, err)
+	}
+	require.NoError(t, tarWriter.Close())
+	require.NoError(t, gzipWriter.Close())
+	layer := buffer.Bytes()
+	layerDigest := ociDigest("sha256", layer)
+
+	manifest, err := json.Marshal(ociManifest{
+		MediaType: ociManifestMediaType,
+		Layers:    []ociDescriptor{{MediaType: "application/vnd.oci.image.layer.v1.tar+gzip", Digest: layerDigest, Size: int64(len(layer))}},
+	})
+	require.NoError(t, err)
+	return registry.pushManifest(tag, manifest), registry.pushBlob(layerDigest, layer)
+}
+
+func (registry *testOCIRegistry) pushManifest(tag string, manifest []byte) string {
+	registry.lock.Lock()
+	defer registry.lock.Unlock()
+	digest := ociDigest("sha256", manifest)
+	registry.manifests[tag] = manifest
+	registry.manifests[digest] = manifest
+	return digest
+}
+
+func (registry *testOCIRegistry) pushBlob(digest string, blob []byte) string {
+	registry.lock.Lock()
+	defer registry.lock.Unlock()
+	registry.blobs[digest] = blob
+	return digest
+}
+
+func (registry *testOCIRegistry) url(query string) *url.URL {
+	return &url.URL{Scheme: "http", Host: registry.host, Path: "/" + testOCIRepository, RawQuery: query}
+}
+
+// Point Every Registry Auth File At A Folder Of The Test's Own And Return The Path Of The Docker Config In It, Which
+// Doesn't Exist Until The Test Writes It
+func isolateOCICredentials(t *testing.T) string {
+	t.Helper()
+
+	configDir := t.TempDir()
+	t.Setenv("REGISTRY_AUTH_FILE", "")
+	t.Setenv("XDG_RUNTIME_DIR", "")
+	t.Setenv("DOCKER_CONFIG", configDir)
+	return filepath.Join(configDir, "config.json")
+}
+
+// Install A Docker Credential Helper Named terrastage-test On The PATH That Returns username And password For host
+func installTestCredentialHelper(t *testing.T, host string, username string, password string) {
+	t.Helper()
+
+	if runtime.GOOS == "windows" {
+		t.Skip("the test credential helper is a shell script")
+	}
+	binDir := t.TempDir()
+	script := fmt.Sprintf("#!/bin/sh\nread host\nif [ \"$host\" != %q ]; then echo 'credentials not found in native keychain'; exit 1; fi\necho '{\"Username\": %q, \"Secret\": %q}'\n", host, username, password)
+	require.NoError(t, os.WriteFile(filepath.Join(binDir, "docker-credential-terrastage-test"), []byte(script), 0755))
+	t.Setenv("PATH", binDir+string(os.PathListSeparator)+os.Getenv("PATH"))
+}
+
+// Not Parallel, The Tests Set Environment Variables
+func TestOCIGetterAuth(t *testing.T) {
+	testCases := []struct {
+		name               string
+		auth               string
+		dockerConfig       func(host string) string
+		credentialHelper   string
+		expectedAuthSource string
+		anonymousTokens    bool
+		expectedErr        string
+	}{
+		{name: "anonymous"},
+		{name: "anonymous bearer token", auth: "bearer", anonymousTokens: true},
+		{
+			name: "basic auth from auths",
+			auth: "basic",
+			dockerConfig: func(host string) string {
+				return fmt.Sprintf(`{"auths": {%q: {"auth": %q}}}`, host, base64.StdEncoding.EncodeToString([]byte("robot:s3cret")))
+			},
+			expectedAuthSource: "config.json",
+		},
+		{
+			name: "basic auth from auths keyed by URL",
+			auth: "basic",
+			dockerConfig: func(host string) string {
+				return fmt.Sprintf(`{"auths": {"http://%s/v2/": {"username": "robot", "password": "s3cret"}}}`, host)
+			},
+			expectedAuthSource: "config.json",
+		},
+		{
+			name: "bearer token with credentials",
+			auth: "bearer",
+			dockerConfig: func(host string) string {
+				return fmt.Sprintf(`{"auths": {%q: {"username": "robot", "password": "s3cret"}}}`, host)
+			},
+			expectedAuthSource: "config.json",
+		},
+		{
+			name: "credHelpers before auths",
+			auth: "basic",
+			dockerConfig: func(host string) string {
+				return fmt.Sprintf(`{"credHelpers": {%q: "terrastage-test"}, "auths": {%q: {"username": "robot", "password": "wrong"}}}`, host, host)
+			},
+			credentialHelper:   "registry",
+			expectedAuthSource: "docker-credential-terrastage-test",
+		},
+		{
+			name: "auths when the credential helper has none",
+			auth: "bearer",
+			dockerConfig: func(host string) string {
+				return fmt.Sprintf(`{"credsStore": "terrastage-test", "auths": {%q: {"username": "robot", "password": "s3cret"}}}`, host)
+			},
+			credentialHelper:   "other.example.com",
+			expectedAuthSource: "config.json",
+		},
+		{
+			name:        "no credentials",
+			auth:        "basic",
+			expectedErr: "(basic auth required) and there are no credentials for the registry",
+		},
+		{
+			name: "wrong credentials",
+			auth: "bearer",
+			dockerConfig: func(host string) string {
+				return fmt.Sprintf(`{"auths": {%q: {"username": "robot", "password": "wrong"}}}`, host)
+			},
+			expectedErr: "(401 Unauthorized) with the credentials from",
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			configPath := isolateOCICredentials(t)
+			username := ""
+			if testCase.auth != "" && !testCase.anonymousTokens {
+				username = "robot"
+			}
+			registry := newTestOCIRegistry(t, testCase.auth, username, "s3cret")
+			digest, _ := registry.push(t, "1.0.0", map[string]string{"main.tf": "# vpc\n"})
+			if testCase.dockerConfig != nil {
+				require.NoError(t, os.WriteFile(configPath, []byte(testCase.dockerConfig(registry.host)), 0600))
+			}
+			switch testCase.credentialHelper {
+			case "":
+			case "registry":
+				installTestCredentialHelper(t, registry.host, "robot", "s3cret")
+			default:
+				installTestCredentialHelper(t, testCase.credentialHelper, "robot", "s3cret")
+			}
+
+			record := &DownloadRecord{}
+			dst := filepath.Join(t.TempDir(), "stage")
+			err := (&OCIGetter{Record: record}).Get(dst, registry.url("tag=1.0.0"))
+
+			if testCase.expectedErr != "" {
+				var authFailed OCIAuthFailed
+				require.ErrorAs(t, err, &authFailed)
+				assert.Contains(t, err.Error(), testCase.expectedErr)
+				assert.NoDirExists(t, dst)
+				return
+			}
+			require.NoError(t, err)
+			contents, err := os.ReadFile(filepath.Join(dst, "main.tf"))
+			require.NoError(t, err)
+			assert.Equal(t, "# vpc\n", string(contents))
+			assert.Equal(t, digest, record.ResolvedDigest)
+			assert.True(t, strings.HasSuffix(record.AuthSource, testCase.expectedAuthSource), record.AuthSource)
+		})
+	}
+}
+
+// Not Parallel, The Tests Set Environment Variables
+func TestOCIGetterVerifiesBeforeTouchingTheStage(t *testing.T) {
+	testCases := []struct {
+		name        string
+		query       string
+		locked      bool
+		setup       func(t *testing.T, registry *testOCIRegistry, lock *LockFile, lockURL *url.URL)
+		expectedErr any
+		errContains string
+	}{
+		{
+			name:   "tag moved from its locked digest",
+			query:  "tag=1.0.0",
+			locked: true,
+			setup: func(t *testing.T, registry *testOCIRegistry, lock *LockFile, lockURL *url.URL) {
+				digest, _ := registry.push(t, "1.0.0", map[string]string{"main.tf": "# first\n"})
+				lock.Record(lockURL, "1.0.0", digest)
+				registry.push(t, "1.0.0", map[string]string{"main.tf": "# moved\n"})
+			},
+			expectedErr: new(LockedRefMoved),
+		},
+		{
+			name:   "tag missing from the lock file",
+			query:  "tag=1.0.0",
+			locked: true,
+			setup: func(t *testing.T, registry *testOCIRegistry, lock *LockFile, lockURL *url.URL) {
+				registry.push(t, "1.0.0", map[string]string{"main.tf": "# first\n"})
+			},
+			expectedErr: new(LockedRefMissing),
+		},
+		{
+			name:  "tampered layer",
+			query: "tag=1.0.0",
+			setup: func(t *testing.T, registry *testOCIRegistry, lock *LockFile, lockURL *url.URL) {
+				_, layerDigest := registry.push(t, "1.0.0", map[string]string{"main.tf": "# first\n"})
+				tampered := bytes.Clone(registry.blobs[layerDigest])
+				tampered[len(tampered)-1] ^= 0xff
+				registry.pushBlob(layerDigest, tampered)
+			},
+			expectedErr: new(OCIDigestMismatch),
+			errContains: "layer sha256:",
+		},
+		{
+			name:  "manifest that doesn't match the digest asked for",
+			query: "digest=sha256:" + strings.Repeat("0", 64),
+			setup: func(t *testing.T, registry *testOCIRegistry, lock *LockFile, lockURL *url.URL) {
+				registry.push(t, "1.0.0", map[string]string{"main.tf": "# first\n"})
+				registry.manifests["sha256:"+strings.Repeat("0", 64)] = registry.manifests["1.0.0"]
+			},
+			expectedErr: new(OCIDigestMismatch),
+			errContains: "manifest of",
+		},
+		{
+			name:  "image index",
+			query: "tag=1.0.0",
+			setup: func(t *testing.T, registry *testOCIRegistry, lock *LockFile, lockURL *url.URL) {
+				digest, _ := registry.push(t, "linux", map[string]string{"main.tf": "# first\n"})
+				index := fmt.Sprintf(`{"schemaVersion": 2, "mediaType": %q, "manifests": [{"mediaType": %q, "digest": %q, "size": 1}]}`, ociIndexMediaType, ociManifestMediaType, digest)
+				registry.pushManifest("1.0.0", []byte(index))
+			},
+			errContains: "is an image index, not a module artifact",
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			isolateOCICredentials(t)
+			registry := newTestOCIRegistry(t, "", "", "")
+			lock := &LockFile{Sources: map[string]map[string]string{}}
+			lockURL := &url.URL{Scheme: "oci", Host: registry.host, Path: "/" + testOCIRepository}
+			testCase.setup(t, registry, lock, lockURL)
+
+			// A Stage From An Earlier Run, Which Must Be Left As It Was
+			dst := filepath.Join(t.TempDir(), "stage")
+			require.NoError(t, os.MkdirAll(dst, os.ModePerm))
+			require.NoError(t, os.WriteFile(filepath.Join(dst, "main.tf"), []byte("# staged\n"), 0644))
+
+			lockedBefore := fmt.Sprint(lock.Sources)
+
+			err := (&OCIGetter{Lock: lock, Locked: testCase.locked}).Get(dst, registry.url(testCase.query))
+
+			require.Error(t, err)
+			if testCase.expectedErr != nil {
+				require.ErrorAs(t, err, testCase.expectedErr)
+			}
+			assert.Contains(t, err.Error(), testCase.errContains)
+			assert.Equal(t, lockedBefore, fmt.Sprint(lock.Sources), "nothing is locked to an artifact that failed to verify")
+			contents, readErr := os.ReadFile(filepath.Join(dst, "main.tf"))
+			require.NoError(t, readErr)
+			assert.Equal(t, "# staged\n", string(contents))
+			entries, readErr := os.ReadDir(filepath.Dir(dst))
+			require.NoError(t, readErr)
+			assert.Len(t, entries, 1, "the layers downloaded are removed again")
+		})
+	}
+}
+
+// Not Parallel, The Tests Set Environment Variables
+func TestOCIGetterLocksTags(t *testing.T) {
+	isolateOCICredentials(t)
+	registry := newTestOCIRegistry(t, "", "", "")
+	first, _ := registry.push(t, "1.0.0", map[string]string{"main.tf": "# first\n"})
+	lock := &LockFile{Sources: map[string]map[string]string{}}
+	lockURL := &url.URL{Scheme: "oci", Host: registry.host, Path: "/" + testOCIRepository}
+	dst := filepath.Join(t.TempDir(), "stage")
+
+	require.NoError(t, (&OCIGetter{Lock: lock}).Get(dst, registry.url("tag=1.0.0")))
+	locked, ok := lock.Lookup(lockURL, "1.0.0")
+	assert.True(t, ok)
+	assert.Equal(t, first, locked)
+
+	// The Tag Still Points At The Locked Digest, So Locked Mode Pulls It Again
+	record := &DownloadRecord{}
+	require.NoError(t, (&OCIGetter{Record: record, Lock: lock, Locked: true}).Get(dst, registry.url("tag=1.0.0")))
+	assert.Equal(t, first, record.ResolvedDigest)
+
+	// A Digest Is Pulled As It Is And Never Locked
+	second, _ := registry.push(t, "2.0.0", map[string]string{"main.tf": "# second\n"})
+	require.NoError(t, (&OCIGetter{Lock: lock, Locked: true}).Get(dst, registry.url("digest="+second)))
+	contents, err := os.ReadFile(filepath.Join(dst, "main.tf"))
+	require.NoError(t, err)
+	assert.Equal(t, "# second\n", string(contents))
+	_, ok = lock.Lookup(lockURL, "2.0.0")
+	assert.False(t, ok)
+}
+
+// Not Parallel, The Tests Set Environment Variables
+func TestOCIGetterChecksBlobRedirects(t *testing.T) {
+	testCases := []struct {
+		name    string
+		hosts   []string
+		allowed bool
+	}{
+		{name: "storage host allowed", hosts: []string{"127.0.0.1", "localhost"}, allowed: true},
+		{name: "storage host not allowed", hosts: []string{"127.0.0.1"}},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			isolateOCICredentials(t)
+			// The Registry Keeps Its Blobs On A Second Test Server, Reached As localhost Rather Than 127.0.0.1
+			storage := newTestOCIRegistry(t, "", "", "")
+			registry := newTestOCIRegistry(t, "", "", "")
+			registry.blobRedirect = strings.Replace(storage.server.URL, "127.0.0.1", "localhost", 1)
+			_, layerDigest := registry.push(t, "1.0.0", map[string]string{"main.tf": "# vpc\n"})
+			storage.pushBlob(layerDigest, registry.blobs[layerDigest])
+			dst := filepath.Join(t.TempDir(), "stage")
+
+			policy := &SourcePolicy{Hosts: testCase.hosts}
+			err := (&OCIGetter{Policy: policy, PolicyName: AllowedSourcesBlock}).Get(dst, registry.url("tag=1.0.0"))
+
+			if testCase.allowed {
+				require.NoError(t, err)
+				assert.FileExists(t, filepath.Join(dst, "main.tf"))
+				return
+			}
+			var notAllowed SourceNotAllowed
+			require.ErrorAs(t, err, &notAllowed)
+			assert.Contains(t, err.Error(), "it is downloaded from localhost/v2/"+testOCIRepository+"/blobs/")
+			assert.NoDirExists(t, dst)
+		})
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/gruntwork-io/go-commons/errors"
+	"github.com/gruntwork-io/terragrunt/util"
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclparse"
+	"github.com/zclconf/go-cty/cty"
+)
+
+// Prefix Of The Environment Variables Terraform Reads A Token Per Registry Host From, e.g. TF_TOKEN_app_terraform_io
+const registryTokenEnvPrefix = "TF_TOKEN_"
+
+// Schema For The credentials Blocks Of The Terraform CLI Config, The Rest Of The File Is Ignored
+var cliConfigSchema = &hcl.BodySchema{
+	Blocks: []hcl.BlockHeaderSchema{
+		{Type: "credentials", LabelNames: []string{"host"}},
+	},
+}
+
+var cliConfigCredentialsSchema = &hcl.BodySchema{
+	Attributes: []hcl.AttributeSchema{
+		{Name: "token"},
+	},
+}
+
+// The Environment Variable Terraform Reads The Token For A Host From: Dots Become Underscores And Dashes Become Double
+// Underscores
+func registryTokenEnvName(host string) string {
+	return registryTokenEnvPrefix + strings.ReplaceAll(strings.ReplaceAll(host, "-", "__"), ".", "_")
+}
+
+// Find The API Token For A Terraform Registry Host The Same Way Terraform Does: A TF_TOKEN_<host> Environment Variable,
+// Then A credentials Block In The CLI Config File (.terraformrc, Or TF_CLI_CONFIG_FILE), Then credentials.tfrc.json
+// (Written By terraform login).   Returns The Token And Where It Came From, Or Empty Strings When There Is None.
+func findRegistryToken(host string) (string, string, error) {
+	for _, variable := range os.Environ() {
+		name, token, _ := strings.Cut(variable, "=")
+		if !strings.HasPrefix(name, registryTokenEnvPrefix) || token == "" {
+			continue
+		}
+		envHost := strings.TrimPrefix(name, registryTokenEnvPrefix)
+		envHost = strings.ReplaceAll(strings.ReplaceAll(strings.ReplaceAll(envHost, "__", "\x00"), "_", "."), "\x00", "-")
+		if strings.EqualFold(envHost, host) {
+			return token, name, nil
+		}
+	}
+
+	if configFile := terraformCLIConfigPath(); util.FileExists(configFile) {
+		token, err := cliConfigToken(configFile, host)
+		if err != nil || token != "" {
+			return token, configFile, err
+		}
+	}
+
+	if credentialsFile := terraformCredentialsPath(); util.FileExists(credentialsFile) {
+		token, err := credentialsFileToken(credentialsFile, host)
+		if err != nil || token != "" {
+			return token, credentialsFile, err
+		}
+	}
+
+	return "", "", nil
+}
+
+// The Token In A credentials "<host>" Block Of A Terraform CLI Config File
+func cliConfigToken(path string, host string) (string, error) {
+	file, diags := hclparse.NewParser().ParseHCLFile(path)
+	if diags.HasErrors() {
+		return "", errors.WithStackTrace(fmt.Errorf("could not parse terraform CLI config %s: %w", path, diags))
+	}
+	content, _, diags := file.Body.PartialContent(cliConfigSchema)
+	if diags.HasErrors() {
+		return "", errors.WithStackTrace(fmt.Errorf("could not parse terraform CLI config %s: %w", path, diags))
+	}
+
+	for _, block := range content.Blocks {
+		if !strings.EqualFold(block.Labels[0], host) {
+			continue
+		}
+		attributes, _, diags := block.Body.PartialContent(cliConfigCredentialsSchema)
+		if diags.HasErrors() || attributes.Attributes["token"] == nil {
+			return "", errors.WithStackTrace(fmt.Errorf("%s: the credentials block for %s has no token", path, host))
+		}
+		value, diags := attributes.Attributes["token"].Expr.Value(nil)
+		if diags.HasErrors() || value.IsNull() || !value.IsKnown() || !value.Type().Equals(cty.String) {
+			return "", errors.WithStackTrace(fmt.Errorf("%s: the token for %s must be a literal string", path, host))
+		}
+		return value.AsString(), nil
+	}
+	return "", nil
+}
+
+// The Token For A Host In A credentials.tfrc.json File
+func credentialsFileToken(path string, host string) (string, error) {
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return "", errors.WithStackTrace(err)
+	}
+	credentialsFile := struct {
+		Credentials map[string]struct {
+			Token string `json:"token"`
+		} `json:"credentials"`
+	}{}
+	if err := json.Unmarshal(contents, &credentialsFile); err != nil {
+		return "", errors.WithStackTrace(fmt.Errorf("could not parse terraform credentials %s: %w", path, err))
+	}
+	for credentialsHost, credentials := range credentialsFile.Credentials {
+		if strings.EqualFold(credentialsHost, host) {
+			return credentials.Token, nil
+		}
+	}
+	return "", nil
+}
+
+// Path Of The Terraform CLI Config File: TF_CLI_CONFIG_FILE, Or .terraformrc In The Home Directory (terraform.rc In
+// %APPDATA% On Windows)
+func terraformCLIConfigPath() string {
+	if path := os.Getenv("TF_CLI_CONFIG_FILE"); path != "" {
+		return path
+	}
+	if runtime.GOOS == "windows" {
+		if appData := os.Getenv("APPDATA"); appData != "" {
+			return filepath.Join(appData, "terraform.rc")
+		}
+		return ""
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".terraformrc")
+}
+
+// Path Of The credentials.tfrc.json File terraform login Writes, In .terraform.d In The Home Directory (terraform.d
+// In %APPDATA% On Windows)
+func terraformCredentialsPath() string {
+	if runtime.GOOS == "windows" {
+		if appData := os.Getenv("APPDATA"); appData != "" {
+			return filepath.Join(appData, "terraform.d", "credentials.tfrc.json")
+		}
+		return ""
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".terraform.d", "credentials.tfrc.json")
+}
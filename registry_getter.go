@@ -0,0 +1,289 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/hashicorp/go-getter"
+)
+
+// The host of tfr sources that don't name one, e.g. tfr:///terraform-aws-modules/vpc/aws?version=5.0.0
+const defaultRegistryHost = "registry.terraform.io"
+
+// The service discovery document every registry host serves, and the service in it for modules
+const registryDiscoveryPath = "/.well-known/terraform.json"
+const registryModulesService = "modules.v1"
+
+// A custom getter.Getter for Terraform Registry module sources
+// (tfr://<host>/<namespace>/<name>/<provider>?version=<version>) that authenticates to private registries the way
+// terraform does, with a TF_TOKEN_<host> environment variable or the credentials in the terraform CLI config.
+// The registry's modules API is found with service discovery, a version constraint is resolved against its
+// versions API, and the package it points at is downloaded with the other getters.
+
+type RegistryGetter struct {
+	// Registry hosts served from somewhere else, such as an internal mirror: the host mapped to the base URL
+	// service discovery is done against instead of https://<host>. Credentials are still those of the host.
+	HostOverrides map[string]string
+
+	// Getters the module package is downloaded with, once the registry has said where it is
+	Getters map[string]getter.Getter
+
+	// Where the credentials used came from, and the version downloaded, are reported here when it is set
+	Record *DownloadRecord
+
+	// The version each constraint resolves to is recorded in Lock when it is set. In Locked mode the locked
+	// version is downloaded instead, and the download fails if the constraint isn't locked.
+	Lock   *LockFile
+	Locked bool
+
+	// Where the registry says a package is must be allowed by Policy, the allowlist block of the terrastage config
+	// named PolicyName, when it is set
+	Policy     *SourcePolicy
+	PolicyName string
+
+	client *getter.Client
+}
+
+func (g *RegistryGetter) SetClient(client *getter.Client) { g.client = client }
+
+func (g *RegistryGetter) context() context.Context {
+	if g.client == nil || g.client.Ctx == nil {
+		return context.Background()
+	}
+	return g.client.Ctx
+}
+
+func (g *RegistryGetter) ClientMode(u *url.URL) (getter.ClientMode, error) {
+	return getter.ClientModeDir, nil
+}
+
+func (g *RegistryGetter) GetFile(dst string, u *url.URL) error {
+	return fmt.Errorf("tfr sources are modules, they can't be downloaded as a single file")
+}
+
+func (g *RegistryGetter) Get(dst string, u *url.URL) error {
+	ctx := g.context()
+
+	host := u.Host
+	if host == "" {
+		host = defaultRegistryHost
+	}
+	modulePath := strings.Trim(u.Path, "/")
+	if len(strings.Split(modulePath, "/")) != 3 {
+		return fmt.Errorf("tfr source %s must be tfr://<host>/<namespace>/<name>/<provider>", u.Redacted())
+	}
+	registry, err := newRegistryClient(host)
+	if err != nil {
+		return err
+	}
+	if registry.tokenSource != "" && g.Record != nil {
+		g.Record.AuthSource = registry.tokenSource
+	}
+
+	modulesURL, err := registry.discoverModules(ctx, g.HostOverrides[host])
+	if err != nil {
+		return err
+	}
+	version, err := registry.moduleVersion(ctx, modulesURL, modulePath, u.Query().Get("version"), g.Lock, g.Locked)
+	if err != nil {
+		return err
+	}
+	if g.Record != nil {
+		g.Record.ResolvedVersion = version
+	}
+
+	downloadURL := modulesURL.ResolveReference(&url.URL{Path: path.Join(modulePath, version, "download")})
+	location, err := registry.packageLocation(ctx, downloadURL)
+	if err != nil {
+		return err
+	}
+	detected, err := getter.Detect(location, dst, getter.Detectors)
+	if err != nil {
+		return err
+	}
+	locationURL, err := parseSourceUrl(detected)
+	if err != nil {
+		return err
+	}
+	if err := g.Policy.checkLocation(g.PolicyName, u, locationURL); err != nil {
+		return err
+	}
+
+	client := &getter.Client{
+		Ctx:     ctx,
+		Src:     location,
+		Dst:     dst,
+		Mode:    getter.ClientModeDir,
+		Getters: registry.packageGetters(location, downloadURL, g.Getters),
+	}
+	if err := client.Get(); err != nil {
+		return fmt.Errorf("downloading the package of %s: %w", u.Redacted(), err)
+	}
+	return nil
+}
+
+// Requests to one registry host, with its token when there is one
+type registryClient struct {
+	host        string
+	token       string
+	tokenSource string
+}
+
+// newRegistryClient returns a client for a registry host with the host's token, if there is one
+func newRegistryClient(host string) (*registryClient, error) {
+	token, tokenSource, err := findRegistryToken(host)
+	if err != nil {
+		return nil, err
+	}
+	return &registryClient{host: host, token: token, tokenSource: tokenSource}, nil
+}
+
+// Find the base URL of the modules API with the host's service discovery document, which is fetched from the
+// override base URL when one is given
+func (registry *registryClient) discoverModules(ctx context.Context, override string) (*url.URL, error) {
+	base := "https://" + registry.host
+	if override != "" {
+		base = strings.TrimRight(override, "/")
+	}
+	discoveryURL, err := url.Parse(base + registryDiscoveryPath)
+	if err != nil {
+		return nil, err
+	}
+
+	body, _, err := registry.get(ctx, discoveryURL)
+	if err != nil {
+		return nil, err
+	}
+	services := map[string]interface{}{}
+	if err := json.Unmarshal(body, &services); err != nil {
+		return nil, fmt.Errorf("registry %s served an invalid service discovery document: %w", registry.host, err)
+	}
+	service, ok := services[registryModulesService].(string)
+	if !ok || service == "" {
+		return nil, fmt.Errorf("registry %s doesn't serve modules, its service discovery document has no %s", registry.host, registryModulesService)
+	}
+
+	serviceURL, err := url.Parse(service)
+	if err != nil {
+		return nil, fmt.Errorf("registry %s has an invalid %s URL %q: %w", registry.host, registryModulesService, service, err)
+	}
+	modulesURL := discoveryURL.ResolveReference(serviceURL)
+	if !strings.HasSuffix(modulesURL.Path, "/") {
+		modulesURL.Path += "/"
+	}
+	return modulesURL, nil
+}
+
+// Ask the registry where the package of a module version is. Registries answer with an X-Terraform-Get header, or
+// a JSON body with a location, which may be relative to the download URL.
+func (registry *registryClient) packageLocation(ctx context.Context, downloadURL *url.URL) (string, error) {
+	body, header, err := registry.get(ctx, downloadURL)
+	if err != nil {
+		return "", err
+	}
+
+	location := header.Get("X-Terraform-Get")
+	if location == "" && len(body) > 0 {
+		response := struct {
+			Location string `json:"location"`
+		}{}
+		if err := json.Unmarshal(body, &response); err == nil {
+			location = response.Location
+		}
+	}
+	if location == "" {
+		return "", fmt.Errorf("registry %s didn't say where to download %s from", registry.host, downloadURL.Redacted())
+	}
+
+	if strings.HasPrefix(location, "/") || strings.HasPrefix(location, "./") || strings.HasPrefix(location, "../") {
+		locationURL, err := url.Parse(location)
+		if err != nil {
+			return "", err
+		}
+		location = downloadURL.ResolveReference(locationURL).String()
+	}
+	return location, nil
+}
+
+// Packages the registry serves itself (from the host its modules API is on) are downloaded with its token too, any
+// other location gets the getters as they are
+func (registry *registryClient) packageGetters(location string, downloadURL *url.URL, getters map[string]getter.Getter) map[string]getter.Getter {
+	locationURL, err := url.Parse(location)
+	if err != nil || registry.token == "" || !strings.EqualFold(locationURL.Host, downloadURL.Host) {
+		return getters
+	}
+	if locationURL.Scheme != "http" && locationURL.Scheme != "https" {
+		return getters
+	}
+
+	header := http.Header{}
+	header.Set("Authorization", "Bearer "+registry.token)
+	withToken := map[string]getter.Getter{}
+	for getterName, getterValue := range getters {
+		withToken[getterName] = getterValue
+	}
+	withToken["http"] = &getter.HttpGetter{Netrc: true, Header: header}
+	withToken["https"] = &getter.HttpGetter{Netrc: true, Header: header}
+	return withToken
+}
+
+func (registry *registryClient) get(ctx context.Context, requestURL *url.URL) ([]byte, http.Header, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, requestURL.String(), nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	if registry.token != "" {
+		request.Header.Set("Authorization", "Bearer "+registry.token)
+	}
+
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer response.Body.Close()
+
+	body, err := io.ReadAll(response.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+	switch {
+	case response.StatusCode == http.StatusUnauthorized || response.StatusCode == http.StatusForbidden:
+		return nil, nil, RegistryAuthFailed{Host: registry.host, URL: requestURL.Redacted(), Status: response.Status, TokenSource: registry.tokenSource}
+	case response.StatusCode < 200 || response.StatusCode > 299:
+		return nil, nil, RegistryRequestFailed{URL: requestURL.Redacted(), Status: response.StatusCode}
+	}
+	return body, response.Header, nil
+}
+
+type RegistryAuthFailed struct {
+	Host        string
+	URL         string
+	Status      string
+	TokenSource string
+}
+
+func (err RegistryAuthFailed) Error() string {
+	if err.TokenSource == "" {
+		return fmt.Sprintf("registry request %s was refused (%s) and there is no token for %s. Set %s or add a credentials block for it to the terraform CLI config.", err.URL, err.Status, err.Host, registryTokenEnvName(err.Host))
+	}
+	return fmt.Sprintf("registry request %s was refused (%s) with the token for %s from %s", err.URL, err.Status, err.Host, err.TokenSource)
+}
+
+type RegistryRequestFailed struct {
+	URL    string
+	Status int
+}
+
+func (err RegistryRequestFailed) Error() string {
+	return fmt.Sprintf("registry request %s returned error: %d %s", err.URL, err.Status, http.StatusText(err.Status))
+}
+
+func (err RegistryRequestFailed) StatusCode() int {
+	return err.Status
+}
@@ -0,0 +1,308 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/go-getter"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Point Terraform's Config Files At An Empty Home Folder And Clear Any Registry Tokens In The Environment, So Tests
+// Only See The Credentials They Write.   Returns The Home Folder.
+func isolateRegistryCredentials(t *testing.T) string {
+	t.Helper()
+
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("APPDATA", home)
+	t.Setenv("TF_CLI_CONFIG_FILE", "")
+	for _, variable := range os.Environ() {
+		if name, _, _ := strings.Cut(variable, "="); strings.HasPrefix(name, registryTokenEnvPrefix) {
+			t.Setenv(name, "")
+		}
+	}
+	return home
+}
+
+func TestRegistryTokenEnvName(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, "TF_TOKEN_app_terraform_io", registryTokenEnvName("app.terraform.io"))
+	assert.Equal(t, "TF_TOKEN_my__registry_example_com", registryTokenEnvName("my-registry.example.com"))
+}
+
+// Not Parallel, The Tests Set Environment Variables
+func TestFindRegistryToken(t *testing.T) {
+	const host = "my-registry.example.com"
+
+	testCases := []struct {
+		name                string
+		env                 map[string]string
+		terraformrc         string
+		cliConfigFile       string
+		credentialsFile     string
+		expectedToken       string
+		expectedTokenSource string
+	}{
+		{name: "none"},
+		{
+			name:                "environment variable with a dash in the host",
+			env:                 map[string]string{"TF_TOKEN_my__registry_example_com": "from-env"},
+			expectedToken:       "from-env",
+			expectedTokenSource: "TF_TOKEN_my__registry_example_com",
+		},
+		{
+			name: "environment variable of another host",
+			env:  map[string]string{"TF_TOKEN_my_registry_example_com": "wrong-host"},
+		},
+		{
+			name:                "environment variable before the config files",
+			env:                 map[string]string{"TF_TOKEN_my__registry_example_com": "from-env"},
+			terraformrc:         `credentials "my-registry.example.com" { token = "from-terraformrc" }`,
+			credentialsFile:     `{"credentials": {"my-registry.example.com": {"token": "from-credentials"}}}`,
+			expectedToken:       "from-env",
+			expectedTokenSource: "TF_TOKEN_my__registry_example_com",
+		},
+		{
+			name:                ".terraformrc before credentials.tfrc.json",
+			terraformrc:         `credentials "my-registry.example.com" { token = "from-terraformrc" }`,
+			credentialsFile:     `{"credentials": {"my-registry.example.com": {"token": "from-credentials"}}}`,
+			expectedToken:       "from-terraformrc",
+			expectedTokenSource: ".terraformrc",
+		},
+		{
+			name:                "credentials.tfrc.json when .terraformrc has no credentials for the host",
+			terraformrc:         `credentials "app.terraform.io" { token = "other-host" }`,
+			credentialsFile:     `{"credentials": {"My-Registry.Example.com": {"token": "from-credentials"}}}`,
+			expectedToken:       "from-credentials",
+			expectedTokenSource: filepath.Join(".terraform.d", "credentials.tfrc.json"),
+		},
+		{
+			name:                "TF_CLI_CONFIG_FILE instead of .terraformrc",
+			terraformrc:         `credentials "my-registry.example.com" { token = "from-terraformrc" }`,
+			cliConfigFile:       `credentials "my-registry.example.com" { token = "from-cli-config-file" }`,
+			expectedToken:       "from-cli-config-file",
+			expectedTokenSource: "terraform.tfrc",
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			home := isolateRegistryCredentials(t)
+			for name, value := range testCase.env {
+				t.Setenv(name, value)
+			}
+			if testCase.terraformrc != "" {
+				require.NoError(t, os.WriteFile(filepath.Join(home, ".terraformrc"), []byte(testCase.terraformrc), 0600))
+			}
+			if testCase.cliConfigFile != "" {
+				cliConfigFile := filepath.Join(home, "terraform.tfrc")
+				require.NoError(t, os.WriteFile(cliConfigFile, []byte(testCase.cliConfigFile), 0600))
+				t.Setenv("TF_CLI_CONFIG_FILE", cliConfigFile)
+			}
+			if testCase.credentialsFile != "" {
+				require.NoError(t, os.MkdirAll(filepath.Join(home, ".terraform.d"), os.ModePerm))
+				require.NoError(t, os.WriteFile(filepath.Join(home, ".terraform.d", "credentials.tfrc.json"), []byte(testCase.credentialsFile), 0600))
+			}
+
+			token, tokenSource, err := findRegistryToken(host)
+
+			require.NoError(t, err)
+			assert.Equal(t, testCase.expectedToken, token)
+			if testCase.expectedTokenSource == "" {
+				assert.Empty(t, tokenSource)
+			} else {
+				assert.True(t, strings.HasSuffix(tokenSource, testCase.expectedTokenSource), tokenSource)
+			}
+		})
+	}
+}
+
+// A Module Package With A Single main.tf, As A Gzipped Tar Archive
+func testRegistryPackage(t *testing.T) []byte {
+	t.Helper()
+
+	contents := "# vpc\n"
+	buffer := &bytes.Buffer{}
+	gzipWriter := gzip.NewWriter(buffer)
+	tarWriter := tar.NewWriter(gzipWriter)
+	require.NoError(t, tarWriter.WriteHeader(&tar.Header{Name: "main.tf", Mode: 0644, Typeflag: tar.TypeReg, Size: int64(len(contents))}))
+	_, err := tarWriter.Write([]byte(contents))
+	require.NoError(t, err)
+	require.NoError(t, tarWriter.Close())
+	require.NoError(t, gzipWriter.Close())
+	return buffer.Bytes()
+}
+
+// A Registry Served Under /mirror/ That Has Versions 1.0.0, 1.2.0 And 2.0.0 Of acme/vpc/aws, Answering Downloads
+// With location In X-Terraform-Get.   Every Request But Service Discovery Needs token When It Is Set, Packages
+// Included.
+func newTestRegistry(t *testing.T, token string, location string) *httptest.Server {
+	t.Helper()
+
+	modulePackage := testRegistryPackage(t)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/mirror"+registryDiscoveryPath {
+			fmt.Fprint(w, `{"modules.v1": "/mirror/api/modules/"}`)
+			return
+		}
+		if token != "" && r.Header.Get("Authorization") != "Bearer "+token {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Path {
+		case "/mirror/api/modules/acme/vpc/aws/versions":
+			fmt.Fprint(w, `{"modules": [{"versions": [{"version": "1.0.0"}, {"version": "1.2.0"}, {"version": "2.0.0"}]}]}`)
+		case "/mirror/api/modules/acme/vpc/aws/1.2.0/download":
+			w.Header().Set("X-Terraform-Get", location)
+			w.WriteHeader(http.StatusNoContent)
+		case "/mirror/api/modules/acme/vpc/aws/1.2.0/vpc.tar.gz", "/files/vpc.tar.gz":
+			w.Write(modulePackage)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// Not Parallel, The Tests Set Environment Variables
+func TestRegistryGetter(t *testing.T) {
+	const host = "registry.example.com"
+
+	testCases := []struct {
+		name        string
+		token       string
+		serverToken string
+		location    string
+		expectedErr string
+	}{
+		{name: "location relative to the download URL", location: "./vpc.tar.gz"},
+		{name: "location relative to the host", location: "/files/vpc.tar.gz"},
+		{name: "token sent to the registry and its packages", token: "secret", serverToken: "secret", location: "./vpc.tar.gz"},
+		{
+			name:        "no token",
+			serverToken: "secret",
+			location:    "./vpc.tar.gz",
+			expectedErr: "(401 Unauthorized) and there is no token for registry.example.com. Set TF_TOKEN_registry_example_com or add a credentials block for it to the terraform CLI config.",
+		},
+		{
+			name:        "wrong token",
+			token:       "wrong",
+			serverToken: "secret",
+			location:    "./vpc.tar.gz",
+			expectedErr: "(401 Unauthorized) with the token for registry.example.com from TF_TOKEN_registry_example_com",
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			isolateRegistryCredentials(t)
+			if testCase.token != "" {
+				t.Setenv(registryTokenEnvName(host), testCase.token)
+			}
+			server := newTestRegistry(t, testCase.serverToken, testCase.location)
+			// The Registry Host Is Served From The Test Server, The Way -registry-host Sets It
+			hostOverrides := keyValueFlag{}
+			require.NoError(t, hostOverrides.Set(host+"="+server.URL+"/mirror/"))
+
+			record := &DownloadRecord{}
+			lock := &LockFile{Sources: map[string]map[string]string{}}
+			registryGetter := &RegistryGetter{
+				HostOverrides: hostOverrides,
+				Getters:       map[string]getter.Getter{"http": new(getter.HttpGetter)},
+				Record:        record,
+				Lock:          lock,
+			}
+			dst := filepath.Join(t.TempDir(), "stage")
+
+			sourceURL, err := url.Parse("tfr://" + host + "/acme/vpc/aws?version=~>+1.0")
+			require.NoError(t, err)
+
+			err = registryGetter.Get(dst, sourceURL)
+
+			if testCase.expectedErr != "" {
+				var authFailed RegistryAuthFailed
+				require.ErrorAs(t, err, &authFailed)
+				assert.Contains(t, err.Error(), testCase.expectedErr)
+				assert.NoDirExists(t, dst)
+				return
+			}
+			require.NoError(t, err)
+			contents, err := os.ReadFile(filepath.Join(dst, "main.tf"))
+			require.NoError(t, err)
+			assert.Equal(t, "# vpc\n", string(contents))
+			assert.Equal(t, "1.2.0", record.ResolvedVersion)
+			locked, ok := lock.Lookup(registryLockURL(host, "acme/vpc/aws"), "~> 1.0")
+			assert.True(t, ok)
+			assert.Equal(t, "1.2.0", locked)
+			if testCase.token != "" {
+				assert.Equal(t, registryTokenEnvName(host), record.AuthSource)
+			}
+		})
+	}
+}
+
+// Not Parallel, The Tests Set Environment Variables
+func TestRegistryGetterChecksPackageLocation(t *testing.T) {
+	const host = "registry.example.com"
+
+	testCases := []struct {
+		name      string
+		policy    *SourcePolicy
+		elsewhere bool
+		allowed   bool
+	}{
+		{name: "package on the registry", policy: &SourcePolicy{Hosts: []string{"127.0.0.1"}}, allowed: true},
+		{name: "package elsewhere on an allowed path", policy: &SourcePolicy{PathPrefixes: []string{"LOCALHOST/Files/"}}, elsewhere: true, allowed: true},
+		{name: "package elsewhere", policy: &SourcePolicy{Hosts: []string{"127.0.0.1"}}, elsewhere: true},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			isolateRegistryCredentials(t)
+			location := "./vpc.tar.gz"
+			if testCase.elsewhere {
+				// Another Test Registry Serves The Package, Reached As localhost Rather Than 127.0.0.1
+				packages := newTestRegistry(t, "", "")
+				location = strings.Replace(packages.URL, "127.0.0.1", "localhost", 1) + "/files/vpc.tar.gz"
+			}
+			server := newTestRegistry(t, "", location)
+			hostOverrides := keyValueFlag{}
+			require.NoError(t, hostOverrides.Set(host+"="+server.URL+"/mirror/"))
+
+			registryGetter := &RegistryGetter{
+				HostOverrides: hostOverrides,
+				Getters:       map[string]getter.Getter{"http": new(getter.HttpGetter)},
+				Policy:        testCase.policy,
+				PolicyName:    AllowedSourcesBlock,
+			}
+			dst := filepath.Join(t.TempDir(), "stage")
+			sourceURL, err := url.Parse("tfr://" + host + "/acme/vpc/aws?version=~>+1.0")
+			require.NoError(t, err)
+
+			err = registryGetter.Get(dst, sourceURL)
+
+			if testCase.allowed {
+				require.NoError(t, err)
+				assert.FileExists(t, filepath.Join(dst, "main.tf"))
+				return
+			}
+			var notAllowed SourceNotAllowed
+			require.ErrorAs(t, err, &notAllowed)
+			assert.Contains(t, err.Error(), "it is downloaded from localhost/files/vpc.tar.gz")
+			assert.NoDirExists(t, dst)
+		})
+	}
+}
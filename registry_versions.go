@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"path"
+	"strings"
+
+	version "github.com/hashicorp/go-version"
+)
+
+// The constraint of a registry module without a version: like terraform, the newest release is used
+const latestRegistryVersion = ">= 0.0.0"
+
+// A registry module version as written in a source or module block: an exact version without its leading =, or a
+// constraint as it is
+func registryVersion(moduleVersion string) string {
+	moduleVersion = strings.TrimSpace(moduleVersion)
+	if isExactRegistryVersion(moduleVersion) {
+		return strings.TrimLeft(moduleVersion, "= ")
+	}
+	return moduleVersion
+}
+
+// Returns true for an exact version, rather than a constraint that has to be resolved against the registry
+func isExactRegistryVersion(moduleVersion string) bool {
+	return exactVersionRegexp.MatchString(strings.TrimSpace(moduleVersion))
+}
+
+// registryLockKey returns the URL a registry module is locked under in the lock file and the constraint it is locked
+// for, for tfr sources whose version is a constraint rather than an exact version.
+func registryLockKey(u *url.URL) (*url.URL, string, bool) {
+	if u.Scheme != "tfr" {
+		return nil, "", false
+	}
+	constraint := u.Query().Get("version")
+	if isExactRegistryVersion(constraint) {
+		return nil, "", false
+	}
+	return registryLockURL(u.Host, strings.Trim(u.Path, "/")), registryConstraint(constraint), true
+}
+
+func registryLockURL(host string, modulePath string) *url.URL {
+	if host == "" {
+		host = defaultRegistryHost
+	}
+	return &url.URL{Scheme: "tfr", Host: host, Path: "/" + modulePath}
+}
+
+func registryConstraint(constraint string) string {
+	if strings.TrimSpace(constraint) == "" {
+		return latestRegistryVersion
+	}
+	return strings.TrimSpace(constraint)
+}
+
+// moduleVersion returns the exact version of a module to download for the version (or constraint) asked for. A
+// constraint is resolved to the newest version the registry has that meets it and recorded in the lock file, or
+// in locked mode the locked version is used and the registry isn't asked.
+func (registry *registryClient) moduleVersion(ctx context.Context, modulesURL *url.URL, modulePath string, moduleVersion string, lock *LockFile, locked bool) (string, error) {
+	if isExactRegistryVersion(moduleVersion) {
+		return registryVersion(moduleVersion), nil
+	}
+
+	constraint := registryConstraint(moduleVersion)
+	lockURL := registryLockURL(registry.host, modulePath)
+	if locked && lock != nil {
+		lockedVersion, ok := lock.Lookup(lockURL, constraint)
+		if !ok {
+			return "", LockedRefMissing{Source: lockURL.String(), Ref: constraint}
+		}
+		return lockedVersion, nil
+	}
+
+	newest, err := registry.newestVersion(ctx, modulesURL, modulePath, constraint)
+	if err != nil {
+		return "", err
+	}
+	if lock != nil {
+		lock.Record(lockURL, constraint, newest)
+	}
+	return newest, nil
+}
+
+// newestVersion asks the registry's versions API for the versions of a module and returns the newest one that
+// meets the constraint. Like terraform, pre-releases only match constraints that name a pre-release.
+func (registry *registryClient) newestVersion(ctx context.Context, modulesURL *url.URL, modulePath string, constraint string) (string, error) {
+	constraints, err := version.NewConstraint(constraint)
+	if err != nil {
+		return "", fmt.Errorf("invalid version constraint %q for registry module %s/%s: %w", constraint, registry.host, modulePath, err)
+	}
+
+	versionsURL := modulesURL.ResolveReference(&url.URL{Path: path.Join(modulePath, "versions")})
+	body, _, err := registry.get(ctx, versionsURL)
+	if err != nil {
+		return "", err
+	}
+	response := struct {
+		Modules []struct {
+			Versions []struct {
+				Version string `json:"version"`
+			} `json:"versions"`
+		} `json:"modules"`
+	}{}
+	if err := json.Unmarshal(body, &response); err != nil {
+		return "", fmt.Errorf("registry %s served an invalid versions list for %s: %w", registry.host, modulePath, err)
+	}
+
+	var newest *version.Version
+	newestVersion := ""
+	for _, module := range response.Modules {
+		for _, moduleVersion := range module.Versions {
+			candidate, err := version.NewVersion(moduleVersion.Version)
+			if err != nil || !constraints.Check(candidate) {
+				continue
+			}
+			if newest == nil || candidate.GreaterThan(newest) {
+				newest = candidate
+				newestVersion = moduleVersion.Version
+			}
+		}
+	}
+	if newest == nil {
+		return "", RegistryVersionNotFound{Module: registry.host + "/" + modulePath, Constraint: constraint}
+	}
+	return newestVersion, nil
+}
+
+type RegistryVersionNotFound struct {
+	Module     string
+	Constraint string
+}
+
+func (err RegistryVersionNotFound) Error() string {
+	return fmt.Sprintf("registry module %s has no version that meets %q", err.Module, err.Constraint)
+}
@@ -3,11 +3,15 @@ package main
 import (
 	"crypto/sha256"
 	"fmt"
+	"maps"
 	"net/url"
 	"os"
+	"path"
 	"path/filepath"
 	"regexp"
+	"sort"
 	"strings"
+	"time"
 
 	"github.com/hashicorp/go-getter"
 	urlhelper "github.com/hashicorp/go-getter/helper/url"
@@ -20,6 +24,7 @@ import (
 var (
 	forcedRegexp     = regexp.MustCompile(`^([A-Za-z0-9]+)::(.+)$`)
 	httpSchemeRegexp = regexp.MustCompile(`(?i)^https?://`)
+	versionTagRegexp = regexp.MustCompile(`^v?[0-9]+(\.[0-9]+)+([-+][0-9A-Za-z.-]+)?$`)
 )
 
 const matchCount = 2
@@ -35,14 +40,60 @@ type Source struct {
 	// The folder in DownloadDir that should be used as the working directory for Terraform
 	WorkingDir string
 
-	// The path to a file in DownloadDir that stores the version number of the code
-	VersionFile string
+	// The path of WorkingDir relative to DownloadDir (the part of the source URL after the double-slash)
+	ModulePath string
+
+	// The path to a file in DownloadDir that stores the stage metadata, including the version number of the code
+	MetadataFile string
+
+	// Hash the contents of local sources instead of their paths and modification times
+	HashContents bool
+
+	// Glob patterns of files and folders in local sources to leave out of the content hash
+	HashExclude []string
+
+	// Glob patterns of hidden files and folders that are copied from local sources, so are part of the content hash
+	IncludeInCopy []string
+
+	// File fingerprints from the previous stage, used to skip hashing files whose size and modification time match
+	PreviousFiles map[string]FileFingerprint
+
+	// Details reported by the getters while downloading this source
+	Record *DownloadRecord
+
+	// Files terrastage generated into the stage on the previous run, relative to DownloadDir
+	PreviousGenerated []string
+
+	// Only the module path is staged, as the root of DownloadDir (which is then also the WorkingDir)
+	ModuleOnly bool
+
+	// A git clone of this source that the run already fetched, which an existing clone in DownloadDir is updated from
+	SharedClone string
+
+	// The allowlist block of the terrastage config (named PolicyName) the source was checked against. Where the
+	// getters are sent to download the source from is checked against it too.
+	Policy     *SourcePolicy
+	PolicyName string
+
+	// Hashes of a local source, worked out once per run since the source folder doesn't change while it is staged
+	localHashes *localSourceHashes
+
+	// The error the getter of the last download attempt failed with, which go-getter only passes on as text
+	getterErr error
 
 	Logger logrus.FieldLogger
 }
 
+// The version, file fingerprints and content hash of a local source once they have been worked out, shared by the
+// copies of the Source they were worked out for
+type localSourceHashes struct {
+	version     string
+	files       map[string]FileFingerprint
+	contentHash string
+}
+
 func (src *Source) String() string {
-	return fmt.Sprintf("Source{CanonicalSourceURL = %v, DownloadDir = %v, WorkingDir = %v, VersionFile = %v}", src.CanonicalSourceURL, src.DownloadDir, src.WorkingDir, src.VersionFile)
+	return fmt.Sprintf("Source{CanonicalSourceURL = %v, DownloadDir = %v, WorkingDir = %v, MetadataFile = %v}", src.CanonicalSourceURL, src.DownloadDir, src.WorkingDir, src.MetadataFile)
 }
 
 // Encode a version number for the given source. When calculating a version number, we take the query
@@ -50,9 +101,24 @@ func (src *Source) String() string {
 // based on the assumption that the scheme/host/path of the URL (e.g. git::github.com/foo/bar) identifies the module
 // name and the query string (e.g. ?ref=v0.0.3) identifies the version. For local file paths, there is no query string,
 // so the same file path (/foo/bar) is always considered the same version. To detect changes the file path will be hashed
-// and returned as version. In case of hash error the default encoded source version will be returned.
-// See also the encodeSourceName and ProcessTerraformSource methods.
+// and returned as version. In case of hash error the default encoded source version will be returned. When HashContents
+// is set the contents of the files are hashed instead of their modification times, see encodeLocalContentVersion.
+// The version of a local source is only worked out once per run. See also the encodeSourceName and
+// ProcessTerraformSource methods.
 func (terraformSource Source) EncodeSourceVersion() (string, error) {
+	if IsLocalSource(terraformSource.CanonicalSourceURL) && terraformSource.localHashes != nil && terraformSource.localHashes.version != "" {
+		return terraformSource.localHashes.version, nil
+	}
+
+	if IsLocalSource(terraformSource.CanonicalSourceURL) && terraformSource.HashContents {
+		version, _, err := terraformSource.encodeLocalContentVersion()
+		if err != nil {
+			terraformSource.Logger.WithError(err).Warningf("Could not encode version for local source")
+			return "", err
+		}
+		return version, nil
+	}
+
 	if IsLocalSource(terraformSource.CanonicalSourceURL) {
 		sourceHash := sha256.New()
 		sourceDir := filepath.Clean(terraformSource.CanonicalSourceURL.Path)
@@ -85,6 +151,9 @@ func (terraformSource Source) EncodeSourceVersion() (string, error) {
 
 		if err == nil {
 			hash := fmt.Sprintf("%x", sourceHash.Sum(nil))
+			if terraformSource.localHashes != nil {
+				terraformSource.localHashes.version = hash
+			}
 
 			return hash, nil
 		}
@@ -96,13 +165,117 @@ func (terraformSource Source) EncodeSourceVersion() (string, error) {
 	return util.EncodeBase64Sha1(terraformSource.CanonicalSourceURL.Query().Encode()), nil
 }
 
-// Write a file into the DownloadDir that contains the version number of this source code. The version number is
-// calculated using the EncodeSourceVersion method.
-func (terraformSource Source) WriteVersionFile() error {
+// Encode a version for a local source from the contents of its files rather than their paths and modification times,
+// so a fresh checkout of unchanged code is considered the same version and an edit that keeps the modification time
+// is still detected. Paths are hashed relative to the source folder so the version doesn't depend on where the source
+// was checked out. As a fast path, files whose size and modification time match PreviousFiles reuse their previous
+// content hash. Returns the version along with the fingerprints of the files that were hashed, which are only worked
+// out once per run.
+func (terraformSource Source) encodeLocalContentVersion() (string, map[string]FileFingerprint, error) {
+	if hashes := terraformSource.localHashes; hashes != nil && hashes.files != nil {
+		return hashes.version, hashes.files, nil
+	}
+
+	sourceDir := filepath.Clean(terraformSource.CanonicalSourceURL.Path)
+	files := map[string]FileFingerprint{}
+	relPaths := []string{}
+
+	err := filepath.Walk(sourceDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		relPath, err := filepath.Rel(sourceDir, path)
+		if err != nil {
+			return err
+		}
+		relPath = filepath.ToSlash(relPath)
+		if relPath == "." {
+			return nil
+		}
+
+		if terraformSource.excludedFromHash(relPath, info.Name()) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		fingerprint := FileFingerprint{Size: info.Size(), ModTime: info.ModTime().UnixMicro()}
+		if previous, ok := terraformSource.PreviousFiles[relPath]; ok && previous.Size == fingerprint.Size && previous.ModTime == fingerprint.ModTime {
+			fingerprint.Hash = previous.Hash
+		} else {
+			fingerprint.Hash, err = hashFile(path)
+			if err != nil {
+				return err
+			}
+		}
+
+		files[relPath] = fingerprint
+		relPaths = append(relPaths, relPath)
+		return nil
+	})
+	if err != nil {
+		return "", nil, err
+	}
+
+	sort.Strings(relPaths)
+
+	sourceHash := sha256.New()
+	for _, relPath := range relPaths {
+		fmt.Fprintf(sourceHash, "%s:%s\n", relPath, files[relPath].Hash)
+	}
+
+	version := fmt.Sprintf("%x", sourceHash.Sum(nil))
+	if terraformSource.localHashes != nil {
+		terraformSource.localHashes.version = version
+		terraformSource.localHashes.files = files
+	}
+	return version, files, nil
+}
+
+// Returns true if the given path (relative to the local source folder) is left out of the content hash. Terraform and
+// Terragrunt working folders, the lock file and hidden files that wouldn't be copied into the stage are always left
+// out, along with anything matching HashExclude.
+func (terraformSource Source) excludedFromHash(relPath string, name string) bool {
+	switch name {
+	case ".git", ".terraform", util.TerragruntCacheDir, util.TerraformLockFile:
+		return true
+	}
+
+	if matchesAnyGlob(terraformSource.HashExclude, relPath, name) {
+		return true
+	}
+
+	return strings.HasPrefix(name, ".") && !matchesAnyGlob(terraformSource.IncludeInCopy, relPath, name)
+}
+
+// Returns true if any of the glob patterns match the relative path or the file name
+func matchesAnyGlob(patterns []string, relPath string, name string) bool {
+	for _, pattern := range patterns {
+		pattern = filepath.ToSlash(pattern)
+		if matched, _ := path.Match(pattern, relPath); matched {
+			return true
+		}
+		if matched, _ := path.Match(pattern, name); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// Write the stage metadata file into the DownloadDir. This records the source URL, the requested ref, the commit that
+// was checked out (for git sources), a hash of the module contents and the version number of this source code, which
+// is calculated using the EncodeSourceVersion method.
+func (terraformSource Source) WriteStageMetadata() error {
 	version, err := terraformSource.EncodeSourceVersion()
 	if err != nil {
 		// If we failed to calculate a SHA of the downloaded source, write a SHA of
-		// some random data into the version file.
+		// some random data into the version field.
 		//
 		// This ensures we attempt to redownload the source next time.
 		version, err = util.GenerateRandomSha256()
@@ -111,7 +284,150 @@ func (terraformSource Source) WriteVersionFile() error {
 		}
 	}
 
-	return errors.WithStackTrace(os.WriteFile(terraformSource.VersionFile, []byte(version), 0640))
+	contentHash, err := terraformSource.ContentHash()
+	if err != nil {
+		terraformSource.Logger.WithError(err).Warningf("Could not hash contents of source %s", terraformSource.CanonicalSourceURL)
+	}
+
+	var files map[string]FileFingerprint
+	if IsLocalSource(terraformSource.CanonicalSourceURL) && terraformSource.HashContents {
+		_, files, err = terraformSource.encodeLocalContentVersion()
+		if err != nil {
+			terraformSource.Logger.WithError(err).Warningf("Could not fingerprint files of source %s", terraformSource.CanonicalSourceURL)
+		}
+	}
+
+	resolvedCommit := resolveHeadCommit(terraformSource.DownloadDir)
+	if terraformSource.Record != nil && terraformSource.Record.ResolvedCommit != "" {
+		resolvedCommit = terraformSource.Record.ResolvedCommit
+	}
+
+	metadata := &StageMetadata{
+		SourceURL:         terraformSource.SourceURLWithoutQuery(),
+		Ref:               terraformSource.Ref(),
+		ResolvedCommit:    resolvedCommit,
+		ContentHash:       contentHash,
+		SourceVersion:     version,
+		TerrastageVersion: VERSION,
+		DownloadedAt:      time.Now().UTC(),
+		Files:             files,
+		GeneratedFiles:    terraformSource.PreviousGenerated,
+		ModuleOnly:        terraformSource.ModuleOnly,
+	}
+	if terraformSource.Record != nil {
+		metadata.ResolvedVersion = terraformSource.Record.ResolvedVersion
+		metadata.ResolvedDigest = terraformSource.Record.ResolvedDigest
+		metadata.VerifiedChecksum = terraformSource.Record.VerifiedChecksum
+		metadata.VerifiedSignature = terraformSource.Record.VerifiedSignature
+		metadata.UnresolvedReferences = terraformSource.Record.UnresolvedReferences
+		metadata.DownloadAttempts = terraformSource.Record.Attempts
+		metadata.DownloadErrors = terraformSource.Record.FailedAttempts
+	}
+
+	return metadata.write(terraformSource.MetadataFile)
+}
+
+// The canonical source URL without its query string, which identifies the repo/module being downloaded.
+func (terraformSource Source) SourceURLWithoutQuery() string {
+	sourceUrlNoQuery := *terraformSource.CanonicalSourceURL
+	sourceUrlNoQuery.RawQuery = ""
+	return sourceUrlNoQuery.String()
+}
+
+// The ref (for git sources), version (for registry and object store sources) or tag (for oci sources) requested in
+// the source URL.
+func (terraformSource Source) Ref() string {
+	query := terraformSource.CanonicalSourceURL.Query()
+	if ref := query.Get("ref"); ref != "" {
+		return ref
+	}
+	if version := query.Get("version"); version != "" {
+		return version
+	}
+	return query.Get("tag")
+}
+
+// Hash the contents of the module. Local sources are hashed from the source folder, once per run, git sources use the
+// tree ID of the module path in the checked out commit and anything else is hashed from the downloaded working dir.
+func (terraformSource Source) ContentHash() (string, error) {
+	if IsLocalSource(terraformSource.CanonicalSourceURL) {
+		if terraformSource.localHashes != nil && terraformSource.localHashes.contentHash != "" {
+			return terraformSource.localHashes.contentHash, nil
+		}
+
+		var contentHash string
+		var err error
+		if terraformSource.HashContents {
+			contentHash, err = terraformSource.localModuleContentHash()
+		} else {
+			contentHash, err = hashDirectoryContents(filepath.Join(filepath.Clean(terraformSource.CanonicalSourceURL.Path), terraformSource.ModulePath))
+		}
+		if err != nil {
+			return "", err
+		}
+		if terraformSource.localHashes != nil {
+			terraformSource.localHashes.contentHash = contentHash
+		}
+		return contentHash, nil
+	}
+
+	if util.IsDir(filepath.Join(terraformSource.DownloadDir, ".git")) {
+		return gitRevParse(terraformSource.DownloadDir, "HEAD:"+terraformSource.ModulePath)
+	}
+
+	return hashDirectoryContents(terraformSource.WorkingDir)
+}
+
+// Hash the files in the module path of a local source the same way as hashDirectoryContents, from the fingerprints
+// of its content version so the files aren't read again. Files left out of the content version by HashExclude are
+// left out here too.
+func (terraformSource Source) localModuleContentHash() (string, error) {
+	_, files, err := terraformSource.encodeLocalContentVersion()
+	if err != nil {
+		return "", err
+	}
+
+	prefix := ""
+	if modulePath := path.Clean(filepath.ToSlash(terraformSource.ModulePath)); modulePath != "." {
+		prefix = modulePath + "/"
+	}
+
+	relPaths := []string{}
+	for relPath := range files {
+		moduleRelPath, ok := strings.CutPrefix(relPath, prefix)
+		if !ok || strings.HasPrefix(moduleRelPath, ".") || strings.Contains(moduleRelPath, "/.") {
+			continue
+		}
+		relPaths = append(relPaths, moduleRelPath)
+	}
+	sort.Strings(relPaths)
+
+	dirHash := sha256.New()
+	for _, relPath := range relPaths {
+		fmt.Fprintf(dirHash, "%s:%s\n", relPath, files[prefix+relPath].Hash)
+	}
+	return fmt.Sprintf("%x", dirHash.Sum(nil)), nil
+}
+
+// Record the fingerprints of the files of a local source in its stage metadata when they changed without the version
+// changing, e.g. after a fresh checkout of the same code, so the next run doesn't read those files again either. The
+// fingerprints are otherwise only written when the source is staged.
+func (terraformSource Source) refreshFileFingerprints() error {
+	if !IsLocalSource(terraformSource.CanonicalSourceURL) || !terraformSource.HashContents {
+		return nil
+	}
+
+	_, files, err := terraformSource.encodeLocalContentVersion()
+	if err != nil || maps.Equal(files, terraformSource.PreviousFiles) {
+		return nil
+	}
+
+	metadata, err := readStageMetadata(terraformSource.MetadataFile)
+	if err != nil {
+		return err
+	}
+	metadata.Files = files
+	return metadata.write(terraformSource.MetadataFile)
 }
 
 // Take the given source path and create a Source struct from it, including the folder where the source should
@@ -133,14 +449,14 @@ func (terraformSource Source) WriteVersionFile() error {
 //     URLs), this is based on the assumption that the scheme/host/path of the URL (e.g. git::github.com/foo/bar)
 //     identifies the repo, and we always want to download the same repo into the same folder (see the encodeSourceName
 //     method). We also assume the version of the module is stored in the query string (e.g. ref=v0.0.3), so we store
-//     the base 64 encoded sha1 of the query string in a file called .terragrunt-source-version within /T/W/H.
+//     the base 64 encoded sha1 of the query string in the stage metadata file (.terrastage-stage.json) within /T/W/H.
 //
 // The downloadTerraformSourceIfNecessary decides when we should download the Terraform code and when not to. It uses
 // the following rules:
 //
 //  1. Always download source URLs pointing to local file paths.
 //  2. Only download source URLs pointing to remote paths if /T/W/H doesn't already exist or, if it does exist, if the
-//     version number in /T/W/H/.terragrunt-source-version doesn't match the current version.
+//     version number in /T/W/H/.terrastage-stage.json doesn't match the current version.
 func NewSource(source string, downloadDir string, workingDir string, logger *logrus.Entry) (*Source, error) {
 
 	canonicalWorkingDir, err := util.CanonicalPath(workingDir, "")
@@ -177,13 +493,16 @@ func NewSource(source string, downloadDir string, workingDir string, logger *log
 	encodedWorkingDir := util.EncodeBase64Sha1(canonicalWorkingDir)
 	updatedDownloadDir := util.JoinPath(downloadDir, encodedWorkingDir, rootPath)
 	updatedWorkingDir := util.JoinPath(updatedDownloadDir, modulePath)
-	versionFile := util.JoinPath(updatedDownloadDir, ".terragrunt-source-version")
+	metadataFile := util.JoinPath(updatedDownloadDir, StageMetadataFile)
 
 	return &Source{
 		CanonicalSourceURL: rootSourceUrl,
 		DownloadDir:        updatedDownloadDir,
 		WorkingDir:         updatedWorkingDir,
-		VersionFile:        versionFile,
+		ModulePath:         modulePath,
+		MetadataFile:       metadataFile,
+		Record:             &DownloadRecord{},
+		localHashes:        &localSourceHashes{},
 		Logger:             logger,
 	}, nil
 }
@@ -194,7 +513,9 @@ func NewSource(source string, downloadDir string, workingDir string, logger *log
 // Be Staged To Within That Is The stageSubDir.  This Comes From
 // Terragrunt Inputs.   The Inputs That Will Be Used Can Be Specified
 // On The Command Line.   This Allows Full Freedom To Choose The Path
-// That Gets Staged To.
+// That Gets Staged To.   The Stage Metadata (Including The Version)
+// Is Kept In The Stage Subdirectory So Every Module Staged Into The
+// Same Stage Directory Tracks Its Own Version.
 func CustomNewSource(source string, downloadDir string, workingDir string, stageSubDir string, logger *logrus.Entry) (*Source, error) {
 
 	canonicalWorkingDir, err := util.CanonicalPath(workingDir, "")
@@ -225,13 +546,16 @@ func CustomNewSource(source string, downloadDir string, workingDir string, stage
 
 	updatedDownloadDir := util.JoinPath(downloadDir, stageSubDir)
 	updatedWorkingDir := util.JoinPath(updatedDownloadDir, modulePath)
-	versionFile := util.JoinPath(downloadDir, ".terragrunt-source-version")
+	metadataFile := util.JoinPath(updatedDownloadDir, StageMetadataFile)
 
 	return &Source{
 		CanonicalSourceURL: rootSourceUrl,
 		DownloadDir:        updatedDownloadDir,
 		WorkingDir:         updatedWorkingDir,
-		VersionFile:        versionFile,
+		ModulePath:         modulePath,
+		MetadataFile:       metadataFile,
+		Record:             &DownloadRecord{},
+		localHashes:        &localSourceHashes{},
 		Logger:             logger,
 	}, nil
 }
@@ -242,6 +566,12 @@ func ToSourceUrl(source string, workingDir string) (*url.URL, error) {
 	// we need to remove the http(s) scheme to allow `getter.Detect` to add the source type
 	source = httpSchemeRegexp.ReplaceAllString(source, "")
 
+	// oci sources name a repository without a scheme (oci::registry.example.com/team/vpc?tag=1.2.0), which would
+	// otherwise be detected as a local path
+	if repository, ok := strings.CutPrefix(source, "oci::"); ok && !strings.Contains(repository, "://") {
+		source = "oci::oci://" + repository
+	}
+
 	// The go-getter library is what Terraform's init command uses to download source URLs. Use that library to
 	// parse the URL.
 	rawSourceUrlWithGetter, err := getter.Detect(source, workingDir, getter.Detectors)
@@ -279,6 +609,30 @@ func parseSourceUrl(source string) (*url.URL, error) {
 	return canonicalSourceUrl, nil
 }
 
+// Returns true if the given URL is pinned to content that can't change: a git ref that is a commit ID or a version
+// tag with at least a major and minor version (a ref like 1 or 2024 is as likely to be a branch), an exact
+// registry/object version, an oci artifact by digest, or an archive with a checksum. Local sources are always
+// considered pinned since they are compared by hash rather than by URL. Branches (including an unspecified ref, which
+// means the default branch) are not pinned.
+func IsPinnedSource(sourceUrl *url.URL) bool {
+	if IsLocalSource(sourceUrl) {
+		return true
+	}
+
+	query := sourceUrl.Query()
+	if ref := query.Get("ref"); ref != "" {
+		return gitCommitIDRegex.MatchString(ref) || versionTagRegexp.MatchString(ref)
+	}
+	if version := query.Get("version"); version != "" {
+		return versionTagRegexp.MatchString(version)
+	}
+	if query.Get("digest") != "" {
+		return true
+	}
+
+	return query.Get("checksum") != ""
+}
+
 // Returns true if the given URL refers to a path on the local file system
 func IsLocalSource(sourceUrl *url.URL) bool {
 	return sourceUrl.Scheme == "file"
@@ -0,0 +1,544 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gruntwork-io/go-commons/errors"
+	"github.com/gruntwork-io/terragrunt/config"
+	"github.com/gruntwork-io/terragrunt/options"
+	"github.com/gruntwork-io/terragrunt/util"
+)
+
+// The Index At The Root Of A Source Bundle
+const BundleIndexFile = "terrastage-bundle.json"
+
+// Folder In A Source Bundle The Sources Are Kept In, One Folder Per Source
+const bundleSourcesDir = "sources"
+
+// Every Remote Source Referenced By A Live Tree, So It Can Be Staged Without A Network (-offline).   Sources Are Keyed
+// By Their Root URL (The Part Before //, Ref Included), The Same Way They Are Asked For When Staging.
+type BundleIndex struct {
+	TerrastageVersion string                   `json:"terrastage_version"`
+	CreatedAt         time.Time                `json:"created_at"`
+	Sources           map[string]BundledSource `json:"sources"`
+}
+
+// One Source In A Bundle
+type BundledSource struct {
+	// Folder Of The Source In The Bundle, Slash Separated
+	Dir string `json:"dir"`
+
+	// The Commit That Was Checked Out, For Git Sources
+	ResolvedCommit string `json:"resolved_commit,omitempty"`
+
+	// The Exact Version That Was Downloaded, For Terraform Registry Sources
+	ResolvedVersion string `json:"resolved_version,omitempty"`
+
+	// The Manifest Digest That Was Pulled, For OCI Sources
+	ResolvedDigest string `json:"resolved_digest,omitempty"`
+
+	// The Checksum The Archive Was Verified Against, For Archive Sources Pinned To One
+	VerifiedChecksum string `json:"verified_checksum,omitempty"`
+
+	// What Was Verified To Be Signed By A Trusted Key, For Git Sources Bundled With -git-verify-signatures
+	VerifiedSignature string `json:"verified_signature,omitempty"`
+}
+
+// A Source Bundle Unpacked Into The Stage Directory For A Run With -offline
+type SourceBundle struct {
+	path  string
+	dir   string
+	index BundleIndex
+}
+
+// Identify A Source In A Bundle.   The sshkey Query Parameter And Passwords Are Credentials Rather Than Part Of What
+// Is Downloaded, So They Are Left Out (And Never Written To The Index).
+func bundleKey(u *url.URL) string {
+	key := *u
+	if key.User != nil {
+		key.User = url.User(key.User.Username())
+	}
+	query := key.Query()
+	query.Del("sshkey")
+	key.RawQuery = query.Encode()
+	return key.String()
+}
+
+// Download Every Remote Source Referenced By The Modules Under workdir Into A Bundle At bundlePath: The terraform
+// Source Of Each terragrunt.hcl, And The Sources Of The Module Blocks In The Code (Followed Recursively, Including
+// Terraform Registry Modules, Whose Version Constraints Are Resolved And Locked).   Sources Are Downloaded With The Same Getters And Options As
+// Staging, Except That Git Sources Are Always Checked Out In Full, Since Modules Staged From The Bundle May Use
+// Different Paths Of The Same Repo.
+func createBundle(terragruntOptions *options.TerragruntOptions, stageOptions *StageOptions, workdir string, stageDir string, bundlePath string) error {
+	modules, err := findTerragruntModules(workdir, stageDir)
+	if err != nil {
+		return err
+	}
+
+	tmpDir, err := os.MkdirTemp("", "terrastage-bundle-")
+	if err != nil {
+		return errors.WithStackTrace(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	bundleOptions := *stageOptions
+	bundleOptions.Sparse = false
+	bundleOptions.FullRepo = true
+	bundleOptions.Downloads = nil
+	bundler := &sourceBundler{
+		terragruntOptions: terragruntOptions,
+		stageOptions:      &bundleOptions,
+		dir:               tmpDir,
+		index:             BundleIndex{TerrastageVersion: VERSION, CreatedAt: time.Now().UTC(), Sources: map[string]BundledSource{}},
+		visited:           map[string]bool{},
+	}
+
+	for _, moduleDir := range modules {
+		configPath := filepath.Join(moduleDir, "terragrunt.hcl")
+		moduleOptions := terragruntOptions.Clone(configPath)
+		moduleOptions.WorkingDir = moduleDir
+		moduleOptions.OriginalTerragruntConfigPath = configPath
+
+		terragruntConfig, err := config.ReadTerragruntConfig(moduleOptions)
+		if err != nil {
+			return err
+		}
+		if terragruntConfig.Terraform == nil || terragruntConfig.Terraform.Source == nil {
+			continue
+		}
+		if terragruntOptions.Source != "" {
+			if moduleOptions.Source, err = config.GetTerragruntSourceForModule(terragruntOptions.Source, moduleDir, terragruntConfig); err != nil {
+				return err
+			}
+		}
+		sourceURL, err := config.GetTerraformSourceUrl(moduleOptions, terragruntConfig)
+		if err != nil {
+			return err
+		}
+
+		// The Terragrunt Working Directory Is Copied Into The Stage Too, So Its Module Blocks Count
+		if err := bundler.addModuleCalls(moduleDir, terragruntConfig); err != nil {
+			return err
+		}
+		if err := bundler.addSource(sourceURL, moduleDir, terragruntConfig, false); err != nil {
+			return err
+		}
+	}
+
+	contents, err := json.MarshalIndent(bundler.index, "", "  ")
+	if err != nil {
+		return errors.WithStackTrace(err)
+	}
+	if err := os.WriteFile(filepath.Join(tmpDir, BundleIndexFile), append(contents, '\n'), 0644); err != nil {
+		return errors.WithStackTrace(err)
+	}
+
+	terragruntOptions.Logger.Infof("Writing %d sources into bundle %s", len(bundler.index.Sources), bundlePath)
+	return writeBundleArchive(tmpDir, bundlePath)
+}
+
+// Downloads The Sources Of A Bundle Into A Folder, Following The Module Blocks Of Everything It Downloads
+type sourceBundler struct {
+	terragruntOptions *options.TerragruntOptions
+	stageOptions      *StageOptions
+	dir               string
+	index             BundleIndex
+	visited           map[string]bool
+}
+
+// Add A Source, As Written In The Code In dir, To The Bundle.   Local Sources Aren't Bundled, They Are On Disk When
+// Staging Too, But Their Module Blocks Are Followed.   The Source Must Be Allowed By The Allowlist In The Terrastage
+// Config For terraform.source, Or For Module Blocks When It Is The Source Of A Module Block.
+func (bundler *sourceBundler) addSource(source string, dir string, terragruntConfig *config.TerragruntConfig, moduleBlock bool) error {
+	sourceURL, err := ToSourceUrl(source, dir)
+	if err != nil {
+		return err
+	}
+	policy, policyName := bundler.stageOptions.AllowedSources, AllowedSourcesBlock
+	if moduleBlock {
+		policy, policyName = bundler.stageOptions.AllowedModuleSources, AllowedModuleSourcesBlock
+	}
+	if err := policy.check(policyName, sourceURL); err != nil {
+		return err
+	}
+	rootSourceURL, modulePath, err := SplitSourceUrl(sourceURL, bundler.terragruntOptions.Logger)
+	if err != nil {
+		return err
+	}
+	if IsLocalSource(rootSourceURL) {
+		return bundler.addModuleCalls(filepath.Join(rootSourceURL.Path, filepath.FromSlash(modulePath)), terragruntConfig)
+	}
+
+	key := bundleKey(rootSourceURL)
+	bundled, ok := bundler.index.Sources[key]
+	if !ok {
+		name := vendoredPackageName(rootSourceURL.Path, key)
+		packageDir := filepath.Join(bundler.dir, bundleSourcesDir, name)
+		packageSource := &Source{
+			CanonicalSourceURL: rootSourceURL,
+			DownloadDir:        packageDir,
+			WorkingDir:         filepath.Join(packageDir, filepath.FromSlash(modulePath)),
+			ModulePath:         modulePath,
+			Record:             &DownloadRecord{},
+			Policy:             policy,
+			PolicyName:         policyName,
+			Logger:             bundler.terragruntOptions.Logger,
+		}
+		if err := fetchSource(packageSource, bundler.terragruntOptions, bundler.stageOptions, terragruntConfig); err != nil {
+			return DownloadingTerraformSourceErr{ErrMsg: err, Url: rootSourceURL.Redacted()}
+		}
+		if err := os.RemoveAll(filepath.Join(packageDir, ".git")); err != nil {
+			return errors.WithStackTrace(err)
+		}
+
+		bundled = BundledSource{
+			Dir:               bundleSourcesDir + "/" + name,
+			ResolvedCommit:    packageSource.Record.ResolvedCommit,
+			ResolvedVersion:   packageSource.Record.ResolvedVersion,
+			ResolvedDigest:    packageSource.Record.ResolvedDigest,
+			VerifiedChecksum:  packageSource.Record.VerifiedChecksum,
+			VerifiedSignature: packageSource.Record.VerifiedSignature,
+		}
+		bundler.index.Sources[key] = bundled
+	}
+
+	moduleDir := filepath.Join(bundler.dir, filepath.FromSlash(bundled.Dir), filepath.FromSlash(modulePath))
+	if !util.IsDir(moduleDir) {
+		return errors.WithStackTrace(WorkingDirNotFound{Source: sourceURL.Redacted(), Dir: modulePath})
+	}
+	return bundler.addModuleCalls(moduleDir, terragruntConfig)
+}
+
+// Add The Sources Of The Module Blocks In A Folder, Following Local Sources To The Folders They Point At
+func (bundler *sourceBundler) addModuleCalls(dir string, terragruntConfig *config.TerragruntConfig) error {
+	if bundler.visited[dir] {
+		return nil
+	}
+	bundler.visited[dir] = true
+
+	calls, err := findModuleCalls(dir)
+	if err != nil {
+		return err
+	}
+	for _, call := range calls {
+		if call.Source == "" {
+			continue
+		}
+		if isLocalModuleSource(call.Source) {
+			if target := filepath.Join(dir, filepath.FromSlash(strings.ReplaceAll(call.Source, `\`, "/"))); util.IsDir(target) {
+				if err := bundler.addModuleCalls(target, terragruntConfig); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+
+		source, err := vendoredModuleSource(call)
+		if err != nil {
+			return err
+		}
+		if err := bundler.addSource(source, dir, terragruntConfig, true); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Write A Folder To A Gzipped Tar Archive, Through A Temporary File So A Failed Write Never Leaves A Partial Bundle
+func writeBundleArchive(dir string, bundlePath string) error {
+	if err := os.MkdirAll(filepath.Dir(bundlePath), os.ModePerm); err != nil {
+		return errors.WithStackTrace(err)
+	}
+	file, err := os.CreateTemp(filepath.Dir(bundlePath), ".terrastage-bundle-")
+	if err != nil {
+		return errors.WithStackTrace(err)
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+
+	gzipWriter := gzip.NewWriter(file)
+	tarWriter := tar.NewWriter(gzipWriter)
+	err = filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		relPath, err := filepath.Rel(dir, path)
+		if err != nil || relPath == "." {
+			return err
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		link := ""
+		if info.Mode()&fs.ModeSymlink != 0 {
+			if link, err = os.Readlink(path); err != nil {
+				return err
+			}
+		}
+		header, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return err
+		}
+		header.Name = filepath.ToSlash(relPath)
+		if err := tarWriter.WriteHeader(header); err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		contents, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer contents.Close()
+		_, err = io.Copy(tarWriter, contents)
+		return err
+	})
+	if err != nil {
+		return errors.WithStackTrace(err)
+	}
+	if err := tarWriter.Close(); err != nil {
+		return errors.WithStackTrace(err)
+	}
+	if err := gzipWriter.Close(); err != nil {
+		return errors.WithStackTrace(err)
+	}
+	if err := file.Close(); err != nil {
+		return errors.WithStackTrace(err)
+	}
+	return errors.WithStackTrace(os.Rename(file.Name(), bundlePath))
+}
+
+// Unpack A Source Bundle Into A Folder In The Stage Directory, So Copies From It Stay On The Same Disk.   The Folder Is
+// Removed By Close.
+func openSourceBundle(bundlePath string, stageDir string) (*SourceBundle, error) {
+	if err := os.MkdirAll(stageDir, os.ModePerm); err != nil {
+		return nil, errors.WithStackTrace(err)
+	}
+	dir, err := os.MkdirTemp(stageDir, ".terrastage-bundle-")
+	if err != nil {
+		return nil, errors.WithStackTrace(err)
+	}
+	bundle := &SourceBundle{path: bundlePath, dir: dir}
+
+	if err := extractBundleArchive(bundlePath, dir); err != nil {
+		bundle.Close()
+		return nil, err
+	}
+
+	contents, err := os.ReadFile(filepath.Join(dir, BundleIndexFile))
+	if err != nil {
+		bundle.Close()
+		return nil, errors.WithStackTrace(fmt.Errorf("%s is not a terrastage source bundle, it has no %s: %w", bundlePath, BundleIndexFile, err))
+	}
+	if err := json.Unmarshal(contents, &bundle.index); err != nil {
+		bundle.Close()
+		return nil, errors.WithStackTrace(fmt.Errorf("could not parse the index of bundle %s: %w", bundlePath, err))
+	}
+	return bundle, nil
+}
+
+// Unpack A Gzipped Tar Archive, Refusing Entries That Would Land Outside Of The Folder.   Symlinks Must Point Inside
+// The Folder, And Nothing Is Written Through One, So A Crafted Bundle Can't Reach Outside Of It Either Way.
+func extractBundleArchive(bundlePath string, dir string) error {
+	file, err := os.Open(bundlePath)
+	if err != nil {
+		return errors.WithStackTrace(err)
+	}
+	defer file.Close()
+
+	gzipReader, err := gzip.NewReader(file)
+	if err != nil {
+		return errors.WithStackTrace(fmt.Errorf("could not read bundle %s: %w", bundlePath, err))
+	}
+	var links []string
+	tarReader := tar.NewReader(gzipReader)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return errors.WithStackTrace(fmt.Errorf("could not read bundle %s: %w", bundlePath, err))
+		}
+
+		relPath := filepath.FromSlash(header.Name)
+		if !filepath.IsLocal(relPath) {
+			return errors.WithStackTrace(fmt.Errorf("bundle %s has an entry outside of it: %s", bundlePath, header.Name))
+		}
+		if entryThroughSymlink(dir, relPath) {
+			return errors.WithStackTrace(fmt.Errorf("bundle %s has an entry inside a symlink: %s", bundlePath, header.Name))
+		}
+		target := filepath.Join(dir, relPath)
+		if err := os.MkdirAll(filepath.Dir(target), os.ModePerm); err != nil {
+			return errors.WithStackTrace(err)
+		}
+		if info, err := os.Lstat(target); err == nil && info.Mode()&fs.ModeSymlink != 0 {
+			if err := os.Remove(target); err != nil {
+				return errors.WithStackTrace(err)
+			}
+		}
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			err = os.MkdirAll(target, header.FileInfo().Mode().Perm()|0700)
+		case tar.TypeSymlink:
+			if symlinkEscapes(relPath, header.Linkname) {
+				return errors.WithStackTrace(fmt.Errorf("bundle %s has a symlink pointing outside of it: %s -> %s", bundlePath, header.Name, header.Linkname))
+			}
+			err = os.Symlink(header.Linkname, target)
+			links = append(links, relPath)
+		case tar.TypeReg:
+			err = extractBundleFile(tarReader, target, header.FileInfo().Mode().Perm())
+		}
+		if err != nil {
+			return errors.WithStackTrace(err)
+		}
+	}
+
+	if link, ok := symlinkThroughSymlink(dir, links); ok {
+		return errors.WithStackTrace(fmt.Errorf("bundle %s has a symlink pointing through another symlink: %s", bundlePath, filepath.ToSlash(link)))
+	}
+	return nil
+}
+
+func extractBundleFile(contents io.Reader, target string, perm fs.FileMode) error {
+	file, err := os.OpenFile(target, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(file, contents); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// Replace The Contents Of The Download Folder With The Source From The Bundle, Keeping Terraform's Working Files And
+// Terrastage's Bookkeeping.   Git Sources, Registry Sources With A Version Constraint And OCI Sources Pulled By Tag
+// Are Checked Against (Or Recorded In) The Lock File Like A Download Would Be.
+func (bundle *SourceBundle) Fetch(terraformSource *Source, terragruntOptions *options.TerragruntOptions, stageOptions *StageOptions) error {
+	bundled, ok := bundle.index.Sources[bundleKey(terraformSource.CanonicalSourceURL)]
+	if !ok {
+		return errors.WithStackTrace(SourceNotInBundle{Source: terraformSource.CanonicalSourceURL.Redacted(), Bundle: bundle.path})
+	}
+
+	if isGitSource(terraformSource.CanonicalSourceURL) && bundled.ResolvedCommit != "" {
+		if err := lockBundledSource(stageOptions, terraformSource.CanonicalSourceURL, terraformSource.Ref(), bundled.ResolvedCommit); err != nil {
+			return err
+		}
+	}
+	if lockURL, constraint, ok := registryLockKey(terraformSource.CanonicalSourceURL); ok && bundled.ResolvedVersion != "" {
+		if err := lockBundledSource(stageOptions, lockURL, constraint, bundled.ResolvedVersion); err != nil {
+			return err
+		}
+	}
+	// Archives Pinned To A Checksum Must Have Been Verified Against It When They Were Bundled
+	checksum, err := sourceChecksum(terraformSource.CanonicalSourceURL, stageOptions.Checksums)
+	if err != nil {
+		return err
+	}
+	if checksum != "" && !strings.EqualFold(bundled.VerifiedChecksum, checksum) {
+		verified := bundled.VerifiedChecksum
+		if verified == "" {
+			verified = "an archive that wasn't verified when it was bundled"
+		}
+		return errors.WithStackTrace(ChecksumMismatch{Source: terraformSource.CanonicalSourceURL.Redacted(), Expected: strings.ToLower(checksum), Actual: verified})
+	}
+	// Git Sources Must Have Been Verified To Be Signed The Way This Run Asks For When They Were Bundled
+	fromGit := isGitSource(terraformSource.CanonicalSourceURL) || bundled.ResolvedCommit != ""
+	if mode := stageOptions.GitSignatures.Mode; mode != "" && fromGit && bundled.VerifiedSignature != mode {
+		return errors.WithStackTrace(GitSignatureNotTrusted{Source: terraformSource.CanonicalSourceURL.Redacted(), Object: "ref " + lockFileRef(terraformSource.Ref()), Reason: fmt.Sprintf("it wasn't verified to be a signed %s when it was bundled", mode)})
+	}
+	if lockURL, tag, ok := ociLockKey(terraformSource.CanonicalSourceURL); ok && bundled.ResolvedDigest != "" {
+		if err := lockBundledSource(stageOptions, lockURL, tag, bundled.ResolvedDigest); err != nil {
+			return err
+		}
+	}
+
+	terragruntOptions.Logger.Infof("Copying Terraform configurations from %s in bundle %s into %s", terraformSource.CanonicalSourceURL.Redacted(), bundle.path, terraformSource.DownloadDir)
+	if err := clearStage(terraformSource.DownloadDir); err != nil {
+		return err
+	}
+	bundledDir := filepath.Join(bundle.dir, filepath.FromSlash(bundled.Dir))
+	err = copyDirectory(bundledDir, terraformSource.DownloadDir, func(relPath string) bool {
+		return keptInStage(relPath, nil) && util.FileExists(filepath.Join(terraformSource.DownloadDir, filepath.FromSlash(relPath)))
+	})
+	if err != nil {
+		return errors.WithStackTrace(err)
+	}
+	terraformSource.Record.ResolvedCommit = bundled.ResolvedCommit
+	terraformSource.Record.ResolvedVersion = bundled.ResolvedVersion
+	terraformSource.Record.ResolvedDigest = bundled.ResolvedDigest
+	terraformSource.Record.VerifiedChecksum = bundled.VerifiedChecksum
+	terraformSource.Record.VerifiedSignature = bundled.VerifiedSignature
+	return nil
+}
+
+// Return The Version A Registry Module Block's Constraint Resolved To When The Bundle Was Made.   The Module Is Looked
+// Up By The Same tfr Source It Was Bundled Under, Built From The Module Block In dir.
+func (bundle *SourceBundle) moduleVersion(call ModuleCall, dir string, terragruntOptions *options.TerragruntOptions) (string, bool, error) {
+	source, err := vendoredModuleSource(call)
+	if err != nil {
+		return "", false, err
+	}
+	sourceURL, err := ToSourceUrl(source, dir)
+	if err != nil {
+		return "", false, err
+	}
+	rootSourceURL, _, err := SplitSourceUrl(sourceURL, terragruntOptions.Logger)
+	if err != nil {
+		return "", false, err
+	}
+	bundled, ok := bundle.index.Sources[bundleKey(rootSourceURL)]
+	if !ok || bundled.ResolvedVersion == "" {
+		return "", false, nil
+	}
+	return bundled.ResolvedVersion, true, nil
+}
+
+// Check What A Source In The Bundle Is Pinned To (A Commit, Registry Version Or OCI Digest) Against The Lock File With -locked,
+// Otherwise Record It There
+func lockBundledSource(stageOptions *StageOptions, lockURL *url.URL, ref string, pinned string) error {
+	if stageOptions.Lock == nil {
+		return nil
+	}
+	if !stageOptions.Locked {
+		stageOptions.Lock.Record(lockURL, ref, pinned)
+		return nil
+	}
+
+	locked, ok := stageOptions.Lock.Lookup(lockURL, ref)
+	if !ok {
+		return errors.WithStackTrace(LockedRefMissing{Source: lockURL.Redacted(), Ref: ref})
+	}
+	if locked != pinned {
+		return errors.WithStackTrace(LockedRefMoved{Source: lockURL.Redacted(), Ref: ref, Locked: locked, Current: pinned})
+	}
+	return nil
+}
+
+// Remove The Unpacked Bundle
+func (bundle *SourceBundle) Close() error {
+	return errors.WithStackTrace(os.RemoveAll(bundle.dir))
+}
+
+type SourceNotInBundle struct {
+	Source string
+	Bundle string
+}
+
+func (err SourceNotInBundle) Error() string {
+	return fmt.Sprintf("Source %s is not in bundle %s. Run terrastage bundle again over a tree that references it, or stage without -offline.", err.Source, err.Bundle)
+}
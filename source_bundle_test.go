@@ -0,0 +1,155 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// An Entry Of A Crafted Bundle Archive: A Folder When Both contents And linkname Are Empty, A Symlink When linkname
+// Is Set And A File Otherwise
+type testBundleEntry struct {
+	name     string
+	contents string
+	linkname string
+}
+
+// Write The Entries Into A Gzipped Tar Archive, As Is, And Return Its Path
+func writeTestBundleArchive(t *testing.T, dir string, entries []testBundleEntry) string {
+	t.Helper()
+
+	bundlePath := filepath.Join(dir, "bundle.tar.gz")
+	file, err := os.Create(bundlePath)
+	require.NoError(t, err)
+	defer file.Close()
+
+	gzipWriter := gzip.NewWriter(file)
+	tarWriter := tar.NewWriter(gzipWriter)
+	for _, entry := range entries {
+		header := &tar.Header{Name: entry.name, Mode: 0755, Typeflag: tar.TypeDir}
+		switch {
+		case entry.linkname != "":
+			header = &tar.Header{Name: entry.name, Mode: 0777, Typeflag: tar.TypeSymlink, Linkname: entry.linkname}
+		case entry.contents != "":
+			header = &tar.Header{Name: entry.name, Mode: 0644, Typeflag: tar.TypeReg, Size: int64(len(entry.contents))}
+		}
+		require.NoError(t, tarWriter.WriteHeader(header))
+		_, err := tarWriter.Write([]byte(entry.contents))
+		require.NoError(t, err)
+	}
+	require.NoError(t, tarWriter.Close())
+	require.NoError(t, gzipWriter.Close())
+	return bundlePath
+}
+
+func TestExtractBundleArchive(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name        string
+		entries     []testBundleEntry
+		expectedErr string
+	}{
+		{
+			name: "symlinks inside the bundle",
+			entries: []testBundleEntry{
+				{name: "modules/vpc/main.tf", contents: "# vpc\n"},
+				{name: "modules/common.tf", contents: "# common\n"},
+				{name: "modules/vpc/common.tf", linkname: "../common.tf"},
+				{name: "modules/vpc/self", linkname: "."},
+				{name: "vpc", linkname: "modules/vpc"},
+			},
+		},
+		{
+			name:        "entry outside of the bundle",
+			entries:     []testBundleEntry{{name: "../escaped.tf", contents: "# escaped\n"}},
+			expectedErr: "has an entry outside of it",
+		},
+		{
+			name:        "absolute symlink",
+			entries:     []testBundleEntry{{name: "passwd", linkname: "/etc/passwd"}},
+			expectedErr: "has a symlink pointing outside of it",
+		},
+		{
+			name:        "symlink escaping the bundle",
+			entries:     []testBundleEntry{{name: "modules/vpc/outside", linkname: "../../.."}},
+			expectedErr: "has a symlink pointing outside of it",
+		},
+		{
+			name: "file written through a symlink",
+			entries: []testBundleEntry{
+				{name: "modules/"},
+				{name: "link", linkname: "modules"},
+				{name: "link/main.tf", contents: "# through the link\n"},
+			},
+			expectedErr: "has an entry inside a symlink",
+		},
+		{
+			name: "symlink written through a symlink",
+			entries: []testBundleEntry{
+				{name: "modules/up", linkname: ".."},
+				{name: "modules/up/escaped", linkname: ".."},
+			},
+			expectedErr: "has an entry inside a symlink",
+		},
+		{
+			name: "symlink pointing through a symlink",
+			entries: []testBundleEntry{
+				{name: "modules/up", linkname: ".."},
+				{name: "escaped", linkname: "modules/up/.."},
+			},
+			expectedErr: "has a symlink pointing through another symlink",
+		},
+		{
+			name: "symlink pointing through a symlink unpacked after it",
+			entries: []testBundleEntry{
+				{name: "modules/"},
+				{name: "escaped", linkname: "modules/up/.."},
+				{name: "modules/up", linkname: ".."},
+			},
+			expectedErr: "has a symlink pointing through another symlink",
+		},
+	}
+
+	for _, testCase := range testCases {
+		testCase := testCase
+		t.Run(testCase.name, func(t *testing.T) {
+			t.Parallel()
+
+			parentDir := t.TempDir()
+			bundlePath := writeTestBundleArchive(t, parentDir, testCase.entries)
+			dir := filepath.Join(parentDir, "bundle")
+			require.NoError(t, os.Mkdir(dir, os.ModePerm))
+
+			err := extractBundleArchive(bundlePath, dir)
+
+			// Nothing Is Ever Written Next To The Folder The Bundle Is Unpacked Into
+			entries, readErr := os.ReadDir(parentDir)
+			require.NoError(t, readErr)
+			names := []string{}
+			for _, entry := range entries {
+				names = append(names, entry.Name())
+			}
+			assert.ElementsMatch(t, []string{"bundle", "bundle.tar.gz"}, names)
+
+			if testCase.expectedErr != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), testCase.expectedErr)
+				return
+			}
+			require.NoError(t, err)
+			for _, entry := range testCase.entries {
+				_, err := os.Lstat(filepath.Join(dir, filepath.FromSlash(entry.name)))
+				assert.NoError(t, err, entry.name)
+			}
+			contents, err := os.ReadFile(filepath.Join(dir, "vpc", "common.tf"))
+			require.NoError(t, err)
+			assert.Equal(t, "# common\n", string(contents))
+		})
+	}
+}
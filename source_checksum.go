@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/gruntwork-io/go-commons/errors"
+	"github.com/hashicorp/go-getter"
+)
+
+// Checksums Archive Sources Can Be Pinned To, In go-getter's checksum Query Parameter Format
+var checksumRegexp = regexp.MustCompile(`^(sha256:[A-Fa-f0-9]{64}|sha512:[A-Fa-f0-9]{128})$`)
+
+// Getters That Download Archives, Whose Sources Can Be Pinned To A Checksum
+var archiveGetters = map[string]bool{"http": true, "https": true, "s3": true, "gcs": true}
+
+// Returns True For Sources Downloaded By A Getter That Fetches Archives (http, s3 And gcs)
+func isArchiveSource(u *url.URL) bool {
+	getterName, _, _ := strings.Cut(u.Scheme, "::")
+	return archiveGetters[getterName]
+}
+
+// The Key A Source Is Looked Up By In The Checksums Of The Terrastage Config: Its URL Without Credentials Or A
+// checksum Query Parameter
+func checksumKey(u *url.URL) string {
+	key := *u
+	key.User = nil
+	query := key.Query()
+	query.Del("checksum")
+	key.RawQuery = query.Encode()
+	return key.String()
+}
+
+// The Checksum A Source Must Match, From Its checksum Query Parameter Or The Terrastage Config, Or "" When It Isn't
+// Pinned To One.   A Checksum On A Source That Isn't An Archive, Or One That Disagrees With The Config, Is An Error
+// Rather Than Being Ignored.
+func sourceChecksum(u *url.URL, checksums map[string]string) (string, error) {
+	checksum := u.Query().Get("checksum")
+	if checksum == "" {
+		return checksums[checksumKey(u)], nil
+	}
+
+	if !isArchiveSource(u) {
+		return "", errors.WithStackTrace(ChecksumNotSupported{Source: u.Redacted()})
+	}
+	if !checksumRegexp.MatchString(checksum) {
+		return "", errors.WithStackTrace(InvalidChecksum{Source: u.Redacted(), Checksum: checksum})
+	}
+	if configured, ok := checksums[checksumKey(u)]; ok && !strings.EqualFold(configured, checksum) {
+		return "", errors.WithStackTrace(ChecksumMismatch{Source: u.Redacted(), Expected: configured, Actual: checksum})
+	}
+	return checksum, nil
+}
+
+// Download An Archive Source As A File Next To The Download Folder, Check It Against Its Checksum, And Only Then
+// Replace The Contents Of The Download Folder With What Is In It (Keeping Terraform's Working Files And Terrastage's
+// Bookkeeping).   The Verified Checksum Is Reported In The Source's Download Record.
+func fetchVerifiedArchive(ctx context.Context, terraformSource *Source, checksum string, options ...getter.ClientOption) error {
+	archiveType := sourceArchiveType(terraformSource.CanonicalSourceURL)
+	if archiveType == "" {
+		return errors.WithStackTrace(ChecksumNeedsArchive{Source: terraformSource.CanonicalSourceURL.Redacted()})
+	}
+
+	if err := os.MkdirAll(filepath.Dir(terraformSource.DownloadDir), os.ModePerm); err != nil {
+		return errors.WithStackTrace(err)
+	}
+	tmpDir, err := os.MkdirTemp(filepath.Dir(terraformSource.DownloadDir), ".terrastage-archive-")
+	if err != nil {
+		return errors.WithStackTrace(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	// The Archive Is Downloaded As It Is, go-getter Neither Unpacks Nor Checks It
+	archiveURL := *terraformSource.CanonicalSourceURL
+	query := archiveURL.Query()
+	query.Del("checksum")
+	query.Set("archive", "false")
+	archiveURL.RawQuery = query.Encode()
+	archiveFile := filepath.Join(tmpDir, "archive")
+	client := &getter.Client{
+		Ctx:     ctx,
+		Src:     archiveURL.String(),
+		Dst:     archiveFile,
+		Mode:    getter.ClientModeFile,
+		Options: options,
+	}
+	if err := client.Get(); err != nil {
+		return err
+	}
+
+	algorithm, _, _ := strings.Cut(checksum, ":")
+	digest, err := fileChecksum(archiveFile, algorithm)
+	if err != nil {
+		return err
+	}
+	if !strings.EqualFold(digest, checksum) {
+		return errors.WithStackTrace(ChecksumMismatch{Source: terraformSource.CanonicalSourceURL.Redacted(), Expected: strings.ToLower(checksum), Actual: digest})
+	}
+
+	if err := clearStage(terraformSource.DownloadDir); err != nil {
+		return err
+	}
+	if err := getter.Decompressors[archiveType].Decompress(terraformSource.DownloadDir, archiveFile, true, 0); err != nil {
+		return errors.WithStackTrace(err)
+	}
+	terraformSource.Record.VerifiedChecksum = digest
+	return nil
+}
+
+// The Archive Type Of A Source, The Same Way go-getter Decides It: The archive Query Parameter, Or Else The Longest
+// Archive Extension The Path Ends With.   Returns "" For Sources That Aren't Archives.
+func sourceArchiveType(u *url.URL) string {
+	if archiveType := u.Query().Get("archive"); archiveType != "" {
+		if _, ok := getter.Decompressors[archiveType]; ok {
+			return archiveType
+		}
+		return ""
+	}
+
+	archiveType := ""
+	for extension := range getter.Decompressors {
+		if strings.HasSuffix(u.Path, "."+extension) && len(extension) > len(archiveType) {
+			archiveType = extension
+		}
+	}
+	return archiveType
+}
+
+// The Checksum Of A File, As <algorithm>:<hex>
+func fileChecksum(path string, algorithm string) (string, error) {
+	var digester hash.Hash
+	switch algorithm {
+	case "sha256":
+		digester = sha256.New()
+	case "sha512":
+		digester = sha512.New()
+	default:
+		return "", errors.WithStackTrace(fmt.Errorf("unsupported checksum algorithm %s", algorithm))
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return "", errors.WithStackTrace(err)
+	}
+	defer file.Close()
+	if _, err := io.Copy(digester, file); err != nil {
+		return "", errors.WithStackTrace(err)
+	}
+	return algorithm + ":" + hex.EncodeToString(digester.Sum(nil)), nil
+}
+
+type ChecksumMismatch struct {
+	Source   string
+	Expected string
+	Actual   string
+}
+
+func (err ChecksumMismatch) Error() string {
+	return fmt.Sprintf("Source %s doesn't match its checksum: expected %s, got %s. Nothing was staged from it.", err.Source, err.Expected, err.Actual)
+}
+
+type ChecksumNotSupported struct {
+	Source string
+}
+
+func (err ChecksumNotSupported) Error() string {
+	return fmt.Sprintf("Source %s has a checksum, but only archive sources (http, s3 and gcs) can be pinned to one", err.Source)
+}
+
+type ChecksumNeedsArchive struct {
+	Source string
+}
+
+func (err ChecksumNeedsArchive) Error() string {
+	return fmt.Sprintf("Source %s has a checksum but isn't an archive. Add an archive query parameter (e.g. archive=tar.gz) if its path has no archive extension.", err.Source)
+}
+
+type InvalidChecksum struct {
+	Source   string
+	Checksum string
+}
+
+func (err InvalidChecksum) Error() string {
+	return fmt.Sprintf("Source %s has an invalid checksum %q, it must be sha256:<hex> or sha512:<hex>", err.Source, err.Checksum)
+}
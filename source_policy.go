@@ -0,0 +1,194 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/gruntwork-io/go-commons/errors"
+)
+
+// Which Sources May Be Staged, From An allowed_sources Or allowed_module_sources Block Of The Terrastage Config.
+// A Source Is Allowed When Every Part Of Its Scheme Is In schemes And Its Host, Org Or Path Matches One Of hosts,
+// orgs Or path_prefixes.   A List That Is Left Out Doesn't Restrict Anything.
+type SourcePolicy struct {
+	// Schemes (And Forced Getters) Sources May Use, e.g. "git", "https", "ssh", "tfr", "oci" Or "file" For Local
+	// Paths.   A Source Like git::https://... Needs Both git And https.
+	Schemes []string `hcl:"schemes,optional"`
+
+	// Hosts Sources May Come From, e.g. "github.com" Or "*.example.com" For Any Subdomain
+	Hosts []string `hcl:"hosts,optional"`
+
+	// Organizations (The First Path Segment, Such As A GitHub Org Or Registry Namespace) Sources May Come From, As
+	// host/org, e.g. "github.com/example-org"
+	Orgs []string `hcl:"orgs,optional"`
+
+	// Prefixes Of host/path Sources May Start With, e.g. "github.com/example-org/terraform-".   End A Prefix With /
+	// To Only Match What Is Below A Folder.
+	PathPrefixes []string `hcl:"path_prefixes,optional"`
+}
+
+// Check That The Entries Of A Policy Are Well Formed, So A Typo Fails When The Config Is Loaded Rather Than
+// Quietly Refusing (Or Allowing) Sources
+func (policy *SourcePolicy) validate(name string) error {
+	for _, scheme := range policy.Schemes {
+		if scheme == "" || strings.ContainsAny(scheme, ":/") {
+			return errors.WithStackTrace(InvalidSourcePolicy{Policy: name, Entry: scheme, Reason: "a scheme is a single name like git or https"})
+		}
+	}
+	for _, host := range policy.Hosts {
+		if host == "" || strings.Contains(host, "/") {
+			return errors.WithStackTrace(InvalidSourcePolicy{Policy: name, Entry: host, Reason: "a host has no path"})
+		}
+	}
+	for _, org := range policy.Orgs {
+		host, orgName, ok := strings.Cut(org, "/")
+		if !ok || host == "" || orgName == "" || strings.Contains(orgName, "/") {
+			return errors.WithStackTrace(InvalidSourcePolicy{Policy: name, Entry: org, Reason: "an org is written host/org"})
+		}
+	}
+	for _, prefix := range policy.PathPrefixes {
+		if host, _, ok := strings.Cut(prefix, "/"); !ok || host == "" {
+			return errors.WithStackTrace(InvalidSourcePolicy{Policy: name, Entry: prefix, Reason: "a path prefix is written host/path"})
+		}
+	}
+	return nil
+}
+
+// Check A Canonical Source URL (From ToSourceUrl) Against The Policy Named name, Returning A SourceNotAllowed Error
+// When It Isn't Allowed.   A nil Policy Allows Everything.   Local Sources Are Only Checked Against The Schemes, As
+// file, Since They Have No Host.
+func (policy *SourcePolicy) check(name string, sourceURL *url.URL) error {
+	if policy == nil {
+		return nil
+	}
+
+	if len(policy.Schemes) > 0 {
+		for _, scheme := range sourceSchemes(sourceURL) {
+			if !containsFold(policy.Schemes, scheme) {
+				return errors.WithStackTrace(SourceNotAllowed{Source: sourceURL.Redacted(), Policy: name, Reason: fmt.Sprintf("the scheme %s isn't in schemes", scheme)})
+			}
+		}
+	}
+	if IsLocalSource(sourceURL) || (len(policy.Hosts) == 0 && len(policy.Orgs) == 0 && len(policy.PathPrefixes) == 0) {
+		return nil
+	}
+
+	if location, ok := policy.allowsLocation(sourceURL); !ok {
+		return errors.WithStackTrace(SourceNotAllowed{Source: sourceURL.Redacted(), Policy: name, Reason: fmt.Sprintf("%s doesn't match any of its hosts, orgs or path_prefixes", location)})
+	}
+	return nil
+}
+
+// Check Where A Getter Was Sent To Download An Allowed Source From, Such As The Package Location A Registry Answers
+// With Or The Host An OCI Registry Redirects A Layer To, Against The Hosts, Orgs And Path Prefixes Of The Policy.
+// Schemes Aren't Checked, How The Registry Serves Its Packages Is Up To It.
+func (policy *SourcePolicy) checkLocation(name string, sourceURL *url.URL, locationURL *url.URL) error {
+	if policy == nil || (len(policy.Hosts) == 0 && len(policy.Orgs) == 0 && len(policy.PathPrefixes) == 0) {
+		return nil
+	}
+	if location, ok := policy.allowsLocation(locationURL); !ok {
+		return errors.WithStackTrace(SourceNotAllowed{Source: sourceURL.Redacted(), Policy: name, Reason: fmt.Sprintf("it is downloaded from %s, which doesn't match any of its hosts, orgs or path_prefixes", location)})
+	}
+	return nil
+}
+
+// Whether A URL Matches One Of The Hosts, Orgs Or Path Prefixes Of The Policy, And Where It Points As host/path
+func (policy *SourcePolicy) allowsLocation(sourceURL *url.URL) (string, bool) {
+	host, location := sourceLocation(sourceURL)
+	for _, allowed := range policy.Hosts {
+		if hostMatches(allowed, host) {
+			return location, true
+		}
+	}
+	for _, allowed := range policy.Orgs {
+		if locationHasPrefix(location, allowed+"/") {
+			return location, true
+		}
+	}
+	for _, allowed := range policy.PathPrefixes {
+		if locationHasPrefix(location, allowed) {
+			return location, true
+		}
+	}
+	return location, false
+}
+
+// The Parts Of A Source's Scheme: The Forced Getter And The Scheme Of The URL It Wraps (git::https Is git And
+// https), With Local Paths As file
+func sourceSchemes(sourceURL *url.URL) []string {
+	if IsLocalSource(sourceURL) {
+		return []string{"file"}
+	}
+	getterName, scheme, forced := strings.Cut(sourceURL.Scheme, "::")
+	if !forced || getterName == scheme {
+		return []string{getterName}
+	}
+	return []string{getterName, scheme}
+}
+
+// The Host Of A Source (Lower Case, Without A Port) And Where It Points, As host/path.   The Path Is Cleaned, So //
+// Module Subdirectories And .. Don't Count, And .git Is Dropped From The End Of Repository Names.   Terraform Registry
+// Sources Without A Host Are On The Public Registry.
+func sourceLocation(sourceURL *url.URL) (string, string) {
+	host := strings.ToLower(sourceURL.Hostname())
+	if host == "" && strings.HasPrefix(sourceURL.Scheme, "tfr") {
+		host = defaultRegistryHost
+	}
+
+	cleaned := path.Clean("/" + sourceURL.Path)
+	segments := strings.Split(cleaned, "/")
+	for i, segment := range segments {
+		segments[i] = strings.TrimSuffix(segment, ".git")
+	}
+	return host, host + strings.Join(segments, "/")
+}
+
+// Whether A Host Is The Allowed Host, Or A Subdomain Of It When It Starts With *.
+func hostMatches(allowed string, host string) bool {
+	allowed = strings.ToLower(allowed)
+	if suffix, ok := strings.CutPrefix(allowed, "*."); ok {
+		return strings.HasSuffix(host, "."+suffix)
+	}
+	return allowed == host
+}
+
+// Whether A host/path Location Starts With A host/path Prefix, Whose Host May Be A *. Wildcard.   Paths Are Compared
+// Case Insensitively, Like Hosts And Schemes, Since GitHub, GitLab And The Registries Treat Orgs And Repositories
+// That Only Differ In Case As The Same.
+func locationHasPrefix(location string, prefix string) bool {
+	prefixHost, prefixPath, _ := strings.Cut(prefix, "/")
+	host, locationPath, _ := strings.Cut(location, "/")
+	return hostMatches(prefixHost, host) && strings.HasPrefix(strings.ToLower(locationPath+"/"), strings.ToLower(prefixPath))
+}
+
+// Whether A List Holds A Value, Ignoring Case
+func containsFold(values []string, value string) bool {
+	for _, candidate := range values {
+		if strings.EqualFold(candidate, value) {
+			return true
+		}
+	}
+	return false
+}
+
+type SourceNotAllowed struct {
+	Source string
+	Policy string
+	Reason string
+}
+
+func (err SourceNotAllowed) Error() string {
+	return fmt.Sprintf("Source %s is not allowed by %s in the terrastage config: %s. Nothing was downloaded from it.", err.Source, err.Policy, err.Reason)
+}
+
+type InvalidSourcePolicy struct {
+	Policy string
+	Entry  string
+	Reason string
+}
+
+func (err InvalidSourcePolicy) Error() string {
+	return fmt.Sprintf("Invalid entry %q in %s of the terrastage config: %s", err.Entry, err.Policy, err.Reason)
+}
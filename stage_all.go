@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/gruntwork-io/go-commons/errors"
+	"github.com/gruntwork-io/terragrunt/options"
+)
+
+// Settings Shared By Every Module Staged In A Run, And The Stage Subdirectories Claimed So Far
+type stageRun struct {
+	stageDir     string
+	subdirVar    string
+	verbose      bool
+	locked       bool
+	all          bool
+	stageOptions *StageOptions
+
+	mutex        sync.Mutex
+	stageSubDirs map[string]string
+}
+
+// Claim A Stage Subdirectory For The Module With The Given Config, Failing If Another Module In This Run Has It
+func (run *stageRun) claimStageSubDir(stageSubDir string, configPath string) error {
+	run.mutex.Lock()
+	defer run.mutex.Unlock()
+
+	key := filepath.Clean(stageSubDir)
+	if claimedBy, ok := run.stageSubDirs[key]; ok && claimedBy != configPath {
+		return errors.WithStackTrace(StageSubDirConflict{StageSubDir: key, ConfigPaths: []string{claimedBy, configPath}})
+	}
+	run.stageSubDirs[key] = configPath
+	return nil
+}
+
+// Returns True If A Module In This Run Is Being Staged Into The Stage Subdirectory, It May Not Exist On Disk Yet
+func (run *stageRun) staging(stageSubDir string) bool {
+	run.mutex.Lock()
+	defer run.mutex.Unlock()
+
+	_, ok := run.stageSubDirs[filepath.Clean(stageSubDir)]
+	return ok
+}
+
+// Find The Folder Of Every terragrunt.hcl Under The Root, Skipping Hidden Folders, Terragrunt's Cache And The
+// Stage Directory Itself
+func findTerragruntModules(root string, stageDir string) ([]string, error) {
+	stageDir = filepath.Clean(stageDir)
+	modules := []string{}
+	err := filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			if path != filepath.Clean(root) && (strings.HasPrefix(entry.Name(), ".") || filepath.Clean(path) == stageDir) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if entry.Name() == "terragrunt.hcl" {
+			modules = append(modules, filepath.Dir(path)+string(filepath.Separator))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, errors.WithStackTrace(err)
+	}
+	sort.Strings(modules)
+	return modules, nil
+}
+
+// Stage The Modules, Up To parallelism At Once, Returning The Working Directories Of Those That Failed
+func (run *stageRun) stageModules(baseOptions *options.TerragruntOptions, modules []string, parallelism int) []string {
+	work := make(chan string)
+	failedModules := []string{}
+	failedMutex := sync.Mutex{}
+
+	waitGroup := sync.WaitGroup{}
+	for i := 0; i < parallelism && i < len(modules); i++ {
+		waitGroup.Add(1)
+		go func() {
+			defer waitGroup.Done()
+			for workdir := range work {
+				if err := run.stageModule(baseOptions, workdir); err != nil {
+					failedMutex.Lock()
+					failedModules = append(failedModules, workdir)
+					failedMutex.Unlock()
+				}
+			}
+		}()
+	}
+	for _, workdir := range modules {
+		work <- workdir
+	}
+	close(work)
+	waitGroup.Wait()
+
+	sort.Strings(failedModules)
+	return failedModules
+}
+
+type StageSubDirConflict struct {
+	StageSubDir string
+	ConfigPaths []string
+}
+
+func (err StageSubDirConflict) Error() string {
+	return fmt.Sprintf("Stage subdir %s is used by more than one module: %s. Each module must stage into its own subdir (see -subdirvar).", err.StageSubDir, strings.Join(err.ConfigPaths, ", "))
+}
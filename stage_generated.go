@@ -0,0 +1,165 @@
+package main
+
+import (
+	"encoding/gob"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/gruntwork-io/go-commons/errors"
+	"github.com/gruntwork-io/terragrunt/util"
+)
+
+// Files Terrastage And Terragrunt Keep In Every Stage For Their Own Bookkeeping
+var stageBookkeepingFiles = []string{StageMetadataFile, moduleInitRequiredFile, MODULE_MANIFEST_NAME, SourceManifestName}
+
+// Terraform's Own Working Files.  Running Terraform In A Stage Leaves These Behind, And Losing Them Would Mean Losing
+// Local State Or Initialized Providers, So They Are Never Cleaned From A Reused Stage.
+var terraformWorkingFiles = []string{".terraform", "*.tfstate", "*.tfstate.backup", ".terraform.lock.hcl", ".terraform.tfstate.lock.info"}
+
+// An Entry Of The Manifest Terragrunt Writes When Copying The Terragrunt Working Directory Into The Stage.
+// Gob Matches Fields By Name, So This Reads Terragrunt's Own Manifest Entries.
+type moduleManifestEntry struct {
+	Path  string
+	IsDir bool
+}
+
+// Return The Files Terragrunt Copied From The Terragrunt Working Directory Into The Stage Working Directory,
+// From The Manifest It Leaves There.  A Missing Manifest Means Nothing Was Copied.
+func readModuleManifest(workingDir string) ([]string, error) {
+	manifest, err := os.Open(filepath.Join(workingDir, MODULE_MANIFEST_NAME))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.WithStackTrace(err)
+	}
+	defer manifest.Close()
+
+	files := []string{}
+	decoder := gob.NewDecoder(manifest)
+	for {
+		entry := moduleManifestEntry{}
+		if err := decoder.Decode(&entry); err == io.EOF {
+			return files, nil
+		} else if err != nil {
+			return nil, errors.WithStackTrace(err)
+		}
+		if !entry.IsDir {
+			files = append(files, entry.Path)
+		}
+	}
+}
+
+// Record The Files Terrastage Generated Into A Stage (Absolute Paths) In Its Stage Metadata, So A Git Stage Can
+// Be Updated Later Without Mistaking Them For Local Changes.   Files Outside Of The Stage Are Ignored.
+func recordGeneratedFiles(downloadDir string, files []string) error {
+	metadataFile := filepath.Join(downloadDir, StageMetadataFile)
+	metadata, err := readStageMetadata(metadataFile)
+	if err != nil {
+		return err
+	}
+
+	generated := map[string]bool{}
+	for _, file := range files {
+		relPath, err := filepath.Rel(downloadDir, file)
+		if err != nil {
+			continue
+		}
+		relPath = filepath.ToSlash(relPath)
+		if relPath == "." || escapesDir(relPath) {
+			continue
+		}
+		generated[relPath] = true
+	}
+
+	metadata.GeneratedFiles = []string{}
+	for relPath := range generated {
+		metadata.GeneratedFiles = append(metadata.GeneratedFiles, relPath)
+	}
+	sort.Strings(metadata.GeneratedFiles)
+
+	return metadata.write(metadataFile)
+}
+
+// Return The Generated Files Recorded In The Stage Metadata Of A Download Folder, If There Is Any
+func previousGeneratedFiles(downloadDir string) []string {
+	metadataFile := filepath.Join(downloadDir, StageMetadataFile)
+	if !util.FileExists(metadataFile) {
+		return nil
+	}
+	metadata, err := readStageMetadata(metadataFile)
+	if err != nil {
+		return nil
+	}
+	return metadata.GeneratedFiles
+}
+
+// Returns True If The Slash Separated Path (Relative To The Stage) Is Never Cleaned From A Reused Stage:
+// It Was Generated By Terrastage, Is Bookkeeping, Or Is One Of Terraform's Working Files
+func keptInStage(relPath string, generated []string) bool {
+	for _, file := range generated {
+		if relPath == file {
+			return true
+		}
+	}
+
+	for _, part := range strings.Split(relPath, "/") {
+		if matchesAnyGlob(stageBookkeepingFiles, relPath, part) || matchesAnyGlob(terraformWorkingFiles, relPath, part) {
+			return true
+		}
+	}
+	return false
+}
+
+// Return The Files Recorded In The Stage Metadata As Copied Or Rewritten To Make Module Sources Resolve Inside The
+// Stage (Absolute Paths), Leaving Out Any That No Longer Exist
+func previousRewrittenFiles(downloadDir string) (map[string]bool, error) {
+	metadata, err := readStageMetadata(filepath.Join(downloadDir, StageMetadataFile))
+	if err != nil {
+		return nil, err
+	}
+
+	rewritten := map[string]bool{}
+	for _, relPath := range metadata.RewrittenFiles {
+		if file := filepath.Join(downloadDir, filepath.FromSlash(relPath)); util.FileExists(file) {
+			rewritten[file] = true
+		}
+	}
+	return rewritten, nil
+}
+
+// Record The Files Copied Or Rewritten To Make Module Sources Resolve Inside The Stage (Absolute Paths) In Its
+// Stage Metadata, And Return Them Sorted
+func recordRewrittenFiles(downloadDir string, rewritten map[string]bool) ([]string, error) {
+	metadataFile := filepath.Join(downloadDir, StageMetadataFile)
+	metadata, err := readStageMetadata(metadataFile)
+	if err != nil {
+		return nil, err
+	}
+
+	files := []string{}
+	metadata.RewrittenFiles = []string{}
+	for file := range rewritten {
+		files = append(files, file)
+		if relPath, err := filepath.Rel(downloadDir, file); err == nil && !escapesDir(filepath.ToSlash(relPath)) {
+			metadata.RewrittenFiles = append(metadata.RewrittenFiles, filepath.ToSlash(relPath))
+		}
+	}
+	sort.Strings(files)
+	sort.Strings(metadata.RewrittenFiles)
+
+	return files, metadata.write(metadataFile)
+}
+
+// Add Every File In A Folder To The Set
+func addFiles(files map[string]bool, dir string) error {
+	return errors.WithStackTrace(filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			files[path] = true
+		}
+		return err
+	}))
+}
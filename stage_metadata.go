@@ -0,0 +1,203 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gruntwork-io/go-commons/errors"
+)
+
+// Metadata File Written Into Each Stage Subdirectory.  This Replaces Terragrunt's .terragrunt-source-version
+// File, Which Terrastage Used To Write At The Root Of The Stage Directory Where It Was Shared By Every Module.
+const StageMetadataFile = ".terrastage-stage.json"
+
+// Terrastage Version, Set At Build Time With -ldflags "-X main.VERSION=<version>"
+var VERSION = "dev"
+
+// Everything We Know About What Was Downloaded Into A Stage Subdirectory.   Cache Decisions Are Made From This.
+type StageMetadata struct {
+	// Canonical Source URL Without The Query String
+	SourceURL string `json:"source_url"`
+
+	// The Ref (Or Registry Version) Requested In The Source URL, If Any
+	Ref string `json:"ref,omitempty"`
+
+	// The Commit That Was Checked Out, For Git Sources
+	ResolvedCommit string `json:"resolved_commit,omitempty"`
+
+	// The Exact Version Downloaded, For Terraform Registry Sources (The Ref May Be A Constraint)
+	ResolvedVersion string `json:"resolved_version,omitempty"`
+
+	// The Manifest Digest Pulled, For OCI Sources (The Ref May Be A Tag)
+	ResolvedDigest string `json:"resolved_digest,omitempty"`
+
+	// The Checksum The Archive Was Verified Against, For Archive Sources Pinned To One
+	VerifiedChecksum string `json:"verified_checksum,omitempty"`
+
+	// What Was Verified To Be Signed By A Trusted Key (GitVerifyCommit Or GitVerifyTag), For Git Sources Staged With
+	// -git-verify-signatures
+	VerifiedSignature string `json:"verified_signature,omitempty"`
+
+	// Hash Of The Downloaded Module Contents
+	ContentHash string `json:"content_hash,omitempty"`
+
+	// Version Calculated By EncodeSourceVersion When The Source Was Downloaded
+	SourceVersion string `json:"source_version"`
+
+	// Version Of Terrastage That Staged The Source
+	TerrastageVersion string `json:"terrastage_version"`
+
+	// When The Source Was Downloaded
+	DownloadedAt time.Time `json:"downloaded_at"`
+
+	// Only The Module Subdirectory Of The Source Was Staged, As The Root Of The Stage Subdirectory (-fullrepo=false)
+	ModuleOnly bool `json:"module_only,omitempty"`
+
+	// Relative Module References That A Sparse Checkout Could Not Include
+	UnresolvedReferences []string `json:"unresolved_references,omitempty"`
+
+	// Fingerprints Of Every File In A Local Source, Recorded When Hashing Local Sources By Content
+	Files map[string]FileFingerprint `json:"files,omitempty"`
+
+	// Files Terrastage Generated Or Copied Into The Stage, Relative To The Stage Subdirectory
+	GeneratedFiles []string `json:"generated_files,omitempty"`
+
+	// Files Copied Into Or Rewritten In The Stage So Module Sources Resolve Inside It (Relative References Outside
+	// Of The Stage And -vendor-modules), Relative To The Stage Subdirectory.   These Are Kept Across Runs That Reuse
+	// The Stage, When The Module Sources Are Already Rewritten.
+	RewrittenFiles []string `json:"rewritten_files,omitempty"`
+
+	// Folders Copied Into The Stage Because Relative Module Sources Referenced Them From Outside Of It
+	CopiedReferences []CopiedReference `json:"copied_references,omitempty"`
+
+	// How Many Attempts Downloading The Source Took, And The Errors Of The Attempts That Failed And Were Retried
+	DownloadAttempts int      `json:"download_attempts,omitempty"`
+	DownloadErrors   []string `json:"download_errors,omitempty"`
+}
+
+// Size, Modification Time And Content Hash Of A File In A Local Source.  When A File's Size And Modification
+// Time Haven't Changed Since The Last Stage, Its Previous Content Hash Is Reused Rather Than Reading It Again.
+type FileFingerprint struct {
+	Size    int64  `json:"size"`
+	ModTime int64  `json:"mtime"`
+	Hash    string `json:"sha256"`
+}
+
+// Details Of A Download Reported Back By The Getters, Recorded In The Stage Metadata
+type DownloadRecord struct {
+	// The Commit That Was Checked Out, For Git Sources
+	ResolvedCommit string
+
+	// The Exact Version That Was Downloaded, For Registry Sources
+	ResolvedVersion string
+
+	// The Manifest Digest That Was Pulled, For OCI Sources
+	ResolvedDigest string
+
+	// The Checksum The Archive Matched, For Archive Sources Pinned To One
+	VerifiedChecksum string
+
+	// What Was Verified To Be Signed By A Trusted Key, For Git Sources Staged With -git-verify-signatures
+	VerifiedSignature string
+
+	// Relative Module References That A Sparse Checkout Could Not Include
+	UnresolvedReferences []string
+
+	// Where The Credentials Used For The Download Came From (Never The Credentials Themselves)
+	AuthSource string
+
+	// How Many Attempts The Download Took, And The Errors Of The Attempts That Failed
+	Attempts       int
+	FailedAttempts []string
+}
+
+// Read The Stage Metadata From The Given File
+func readStageMetadata(path string) (*StageMetadata, error) {
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.WithStackTrace(err)
+	}
+
+	metadata := &StageMetadata{}
+	if err := json.Unmarshal(contents, metadata); err != nil {
+		return nil, errors.WithStackTrace(err)
+	}
+
+	return metadata, nil
+}
+
+// Write The Stage Metadata To The Given File
+func (metadata *StageMetadata) write(path string) error {
+	contents, err := json.MarshalIndent(metadata, "", "  ")
+	if err != nil {
+		return errors.WithStackTrace(err)
+	}
+
+	return errors.WithStackTrace(os.WriteFile(path, contents, 0640))
+}
+
+// Hash The Contents Of The Files In A Directory.  Hidden Files And Folders (.git, .terraform, Terrastage And
+// Terragrunt Metadata) Are Skipped Since They Aren't Part Of The Module Terragrunt Would Copy.
+func hashDirectoryContents(dir string) (string, error) {
+	files := []string{}
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if path != dir && strings.HasPrefix(info.Name(), ".") {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if info.Mode().IsRegular() {
+			files = append(files, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return "", errors.WithStackTrace(err)
+	}
+
+	sort.Strings(files)
+
+	dirHash := sha256.New()
+	for _, path := range files {
+		relPath, err := filepath.Rel(dir, path)
+		if err != nil {
+			return "", errors.WithStackTrace(err)
+		}
+
+		fileHash, err := hashFile(path)
+		if err != nil {
+			return "", err
+		}
+
+		fmt.Fprintf(dirHash, "%s:%s\n", filepath.ToSlash(relPath), fileHash)
+	}
+
+	return fmt.Sprintf("%x", dirHash.Sum(nil)), nil
+}
+
+// Return The sha256 Of A File's Contents
+func hashFile(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", errors.WithStackTrace(err)
+	}
+	defer file.Close()
+
+	fileHash := sha256.New()
+	if _, err := io.Copy(fileHash, file); err != nil {
+		return "", errors.WithStackTrace(err)
+	}
+
+	return fmt.Sprintf("%x", fileHash.Sum(nil)), nil
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"time"
+)
+
+// Terrastage Specific Options That Don't Have A Home In The Terragrunt Options.
+// These Are Populated From The Command Line And Passed Along With The Terragrunt Options.
+type StageOptions struct {
+	// Always Download The Source Again, Even When The Stage Looks Up To Date
+	NoCache bool
+
+	// How Long A Stage Downloaded From A Ref That Isn't Pinned (A Branch Or Default Branch)
+	// Is Considered Up To Date.   Zero Means It Never Expires.
+	CacheTTL time.Duration
+
+	// Hash The Contents Of Local Sources To Decide Whether They Changed, Rather Than Their Modification Times
+	HashContents bool
+
+	// Glob Patterns Of Files Left Out Of The Content Hash Of Local Sources
+	HashExclude []string
+
+	// Folder Of The Shared Git Mirror Cache.   Empty Disables The Cache And Clones Into Every Stage.
+	GitCacheDir string
+
+	// Only Materialise The Module Subdirectory Of Git Sources (And What It References) Instead Of The Whole Repo
+	Sparse bool
+
+	// Lock File The Commit Each Git Source's Ref Resolves To Is Recorded In.   Nil Disables Locking.
+	Lock *LockFile
+
+	// Check Out Exactly The Commits In The Lock File, Failing If A Ref Has Moved Or Isn't Locked
+	Locked bool
+
+	// Fetch Submodules Of Git Sources That Don't Set The submodules Query Parameter
+	Submodules bool
+
+	// How To Authenticate To Git Remotes
+	GitAuth GitAuthOptions
+
+	// Which Signatures Git Sources Must Carry, And The Keys Trusted To Make Them
+	GitSignatures GitSignatureOptions
+
+	// Which Git Implementation Downloads Git Sources: GitBackendExec (The git Binary) Or GitBackendGoGit
+	GitBackend string
+
+	// How Long Each Attempt To Download A Source May Take.   Zero Means No Limit.
+	DownloadTimeout time.Duration
+
+	// How Many Times A Download That Failed For A Retryable Reason Is Tried Again
+	DownloadRetries int
+
+	// How Long To Wait Before The First Retry, Doubling For Every Retry After That
+	RetryBackoff time.Duration
+
+	// Download The Whole Repo (Or Folder) Of Sources With A Module Subdirectory (//) So ../ References Work, Rather
+	// Than Staging Only The Module Subdirectory As The Root Of The Stage
+	FullRepo bool
+
+	// Terraform Registry Hosts Served From Somewhere Else (e.g. An Internal Mirror): Host To The Base URL Service
+	// Discovery Is Done Against
+	RegistryHosts map[string]string
+
+	// Checksums From The Terrastage Config That Archive Sources Must Match, Keyed By checksumKey
+	Checksums map[string]string
+
+	// Which Sources terraform.source May Point At, From The Terrastage Config.   Nil Allows Every Source.
+	AllowedSources *SourcePolicy
+
+	// Which Sources Vendored Module Blocks May Point At, From The Terrastage Config.   Nil Allows Every Source.
+	AllowedModuleSources *SourcePolicy
+
+	// Download The Remote Sources Of Module Blocks In The Staged Code Into The Stage And Point Them At The Copies
+	VendorModules bool
+
+	// Rewrite Registry Module Version Constraints In The Staged Code To The Exact Versions They Resolve To
+	PinVersions bool
+
+	// Bundle Every Remote Source Is Taken From Instead Of Downloading It (-offline).   Nil Downloads As Usual.
+	Bundle *SourceBundle
+
+	// Downloads Each Source Once And Shares It Between The Modules Staged In A Run.   Nil Downloads Every Stage Separately.
+	Downloads *DownloadCoordinator
+}
+
+// Default Set Of Stage Options
+func NewStageOptions() *StageOptions {
+	return &StageOptions{
+		GitBackend:      GitBackendExec,
+		FullRepo:        true,
+		DownloadRetries: DefaultDownloadRetries,
+		RetryBackoff:    DefaultRetryBackoff,
+	}
+}
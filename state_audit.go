@@ -0,0 +1,249 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gruntwork-io/go-commons/errors"
+	"github.com/gruntwork-io/terragrunt/remote"
+	"github.com/gruntwork-io/terragrunt/util"
+)
+
+// Index Of Remote State Identities For Every Module Staged Into A Stage Directory.
+// This Lives At The Root Of The Stage Directory So Separate Runs Staging Into The
+// Same Place Can See What Every Other Stage Subdirectory Is Using For State.
+const RemoteStateIndexFile = ".terrastage-remote-state.json"
+
+// How Long To Wait For Another Module Updating The Remote State Index
+const remoteStateIndexLockTimeout = 5 * time.Minute
+
+// The Effective Location Of A Module's State.  Two Modules With The Same Identity
+// Would Read And Write The Same State File.
+type RemoteStateIdentity struct {
+	Backend   string `json:"backend"`
+	Container string `json:"container"`
+	Key       string `json:"key"`
+}
+
+func (id RemoteStateIdentity) String() string {
+	return fmt.Sprintf("%s://%s/%s", id.Backend, id.Container, id.Key)
+}
+
+// An Entry In The Remote State Index, One Per Stage Subdirectory
+type RemoteStateIndexEntry struct {
+	StageSubDir string              `json:"stage_subdir"`
+	ConfigPath  string              `json:"config_path"`
+	Identity    RemoteStateIdentity `json:"identity"`
+}
+
+// Config Keys That Identify The Container (Bucket, Storage Account, Etc.) And The Key Within That
+// Container For Each Backend.   Backends Not Listed Here Fall Back To Comparing Their Full Config.
+var remoteStateIdentityKeys = map[string]struct {
+	Container []string
+	Key       []string
+}{
+	"s3":         {Container: []string{"bucket"}, Key: []string{"key"}},
+	"gcs":        {Container: []string{"bucket"}, Key: []string{"prefix"}},
+	"azurerm":    {Container: []string{"storage_account_name", "container_name"}, Key: []string{"key"}},
+	"remote":     {Container: []string{"hostname", "organization"}, Key: []string{"workspaces"}},
+	"consul":     {Container: []string{"address"}, Key: []string{"path"}},
+	"http":       {Container: []string{"address"}, Key: nil},
+	"pg":         {Container: []string{"conn_str"}, Key: []string{"schema_name"}},
+	"cos":        {Container: []string{"bucket"}, Key: []string{"prefix", "key"}},
+	"oss":        {Container: []string{"bucket"}, Key: []string{"prefix", "key"}},
+	"kubernetes": {Container: []string{"namespace"}, Key: []string{"secret_suffix"}},
+}
+
+// Compute The Effective Backend Identity From The Remote State Config.  Returns Nil
+// When The State Can't Collide With Another Stage Subdirectory (Relative Local State).
+func remoteStateIdentity(remoteState *remote.RemoteState) *RemoteStateIdentity {
+	if remoteState == nil {
+		return nil
+	}
+
+	// Local State With A Relative Path Lives Inside The Stage Subdirectory Itself
+	if remoteState.Backend == "local" {
+		path := configString(remoteState.Config["path"])
+		if path == "" || !filepath.IsAbs(path) {
+			return nil
+		}
+		return &RemoteStateIdentity{Backend: "local", Key: filepath.ToSlash(filepath.Clean(path))}
+	}
+
+	keys, ok := remoteStateIdentityKeys[remoteState.Backend]
+	if !ok {
+		// Unknown Backend, Only Identical Configs Are Considered The Same State
+		contents, _ := json.Marshal(remoteState.Config)
+		return &RemoteStateIdentity{Backend: remoteState.Backend, Key: string(contents)}
+	}
+
+	identity := &RemoteStateIdentity{Backend: remoteState.Backend}
+
+	containerParts := []string{}
+	for _, name := range keys.Container {
+		containerParts = append(containerParts, configString(remoteState.Config[name]))
+	}
+	identity.Container = strings.Join(containerParts, "/")
+
+	keyParts := []string{}
+	for _, name := range keys.Key {
+		keyParts = append(keyParts, configString(remoteState.Config[name]))
+	}
+	identity.Key = strings.Join(keyParts, "/")
+
+	// The Remote Backend Defaults To Terraform Cloud When No Hostname Is Given
+	if remoteState.Backend == "remote" && configString(remoteState.Config["hostname"]) == "" {
+		identity.Container = "app.terraform.io" + identity.Container
+	}
+
+	return identity
+}
+
+// Render A Remote State Config Value As A String.  Nested Blocks (Like The Workspaces Block
+// Of The Remote Backend) Are Rendered As Sorted key=value Pairs So They Compare Consistently.
+func configString(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case map[string]interface{}:
+		pairs := []string{}
+		for key, nested := range v {
+			pairs = append(pairs, fmt.Sprintf("%s=%s", key, configString(nested)))
+		}
+		sort.Strings(pairs)
+		return strings.Join(pairs, ",")
+	case []interface{}:
+		items := []string{}
+		for _, nested := range v {
+			items = append(items, configString(nested))
+		}
+		return strings.Join(items, ",")
+	case []map[string]interface{}:
+		items := []string{}
+		for _, nested := range v {
+			items = append(items, configString(nested))
+		}
+		return strings.Join(items, ",")
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// Record The Remote State Identity For This Stage Subdirectory In The Stage Directory's Index
+// And Fail If Any Other Stage Subdirectory Already Uses The Same State.   The Module's Entry Is Replaced On
+// Every Audit (And Dropped When Its State Can't Collide), So A Changed Key Doesn't Leave A Stale Entry Behind.
+// Entries For Stage Subdirectories That No Longer Exist Are Dropped So Removed Modules Don't Cause False
+// Positives, Unless staging Reports A Module In This Run Is Being Staged Into Them.   The Index Is Updated Under
+// A File Lock So Modules Staged In Parallel Don't Lose Each Other's Entries.
+func auditRemoteState(stageDir string, stageSubDir string, configPath string, remoteState *remote.RemoteState, staging func(string) bool) error {
+	identity := remoteStateIdentity(remoteState)
+
+	if stageSubDir == "" {
+		stageSubDir = "."
+	}
+
+	indexPath := filepath.Join(stageDir, RemoteStateIndexFile)
+	if identity == nil && !util.FileExists(indexPath) {
+		return nil
+	}
+
+	unlock, err := acquireFileLock(indexPath+".lock", remoteStateIndexLockTimeout)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	index, err := readRemoteStateIndex(indexPath)
+	if err != nil {
+		return err
+	}
+
+	colliding := []RemoteStateIndexEntry{}
+	updatedIndex := []RemoteStateIndexEntry{}
+
+	for _, existing := range index {
+		if existing.StageSubDir == stageSubDir {
+			continue
+		}
+		if !util.IsDir(filepath.Join(stageDir, existing.StageSubDir)) && !staging(existing.StageSubDir) {
+			continue
+		}
+		if identity != nil && existing.Identity == *identity {
+			colliding = append(colliding, existing)
+		}
+		updatedIndex = append(updatedIndex, existing)
+	}
+
+	if identity == nil {
+		return writeRemoteStateIndex(indexPath, updatedIndex)
+	}
+
+	entry := RemoteStateIndexEntry{StageSubDir: stageSubDir, ConfigPath: configPath, Identity: *identity}
+	if err := writeRemoteStateIndex(indexPath, append(updatedIndex, entry)); err != nil {
+		return err
+	}
+
+	if len(colliding) > 0 {
+		return errors.WithStackTrace(RemoteStateCollision{Identity: *identity, Modules: append([]RemoteStateIndexEntry{entry}, colliding...)})
+	}
+
+	return nil
+}
+
+func readRemoteStateIndex(indexPath string) ([]RemoteStateIndexEntry, error) {
+	index := []RemoteStateIndexEntry{}
+	if !util.FileExists(indexPath) {
+		return index, nil
+	}
+
+	contents, err := os.ReadFile(indexPath)
+	if err != nil {
+		return nil, errors.WithStackTrace(err)
+	}
+	if err := json.Unmarshal(contents, &index); err != nil {
+		return nil, errors.WithStackTrace(err)
+	}
+
+	return index, nil
+}
+
+func writeRemoteStateIndex(indexPath string, index []RemoteStateIndexEntry) error {
+	sort.Slice(index, func(i, j int) bool { return index[i].StageSubDir < index[j].StageSubDir })
+
+	contents, err := json.MarshalIndent(index, "", "  ")
+	if err != nil {
+		return errors.WithStackTrace(err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(indexPath), os.ModePerm); err != nil {
+		return errors.WithStackTrace(err)
+	}
+
+	// Write To A Temp File And Rename So A Concurrent Reader Never Sees A Partial Index
+	tempPath := indexPath + ".tmp"
+	if err := os.WriteFile(tempPath, contents, os.FileMode(defaultPermissions)); err != nil {
+		return errors.WithStackTrace(err)
+	}
+
+	return errors.WithStackTrace(os.Rename(tempPath, indexPath))
+}
+
+type RemoteStateCollision struct {
+	Identity RemoteStateIdentity
+	Modules  []RemoteStateIndexEntry
+}
+
+func (err RemoteStateCollision) Error() string {
+	report := []string{}
+	for _, module := range err.Modules {
+		report = append(report, fmt.Sprintf("  - stage subdir %s (config %s)", module.StageSubDir, module.ConfigPath))
+	}
+	return fmt.Sprintf("Remote state %s is shared by more than one staged module:\n%s\nEach module must use its own key/prefix in remote_state.", err.Identity, strings.Join(report, "\n"))
+}
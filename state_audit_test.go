@@ -0,0 +1,81 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gruntwork-io/terragrunt/remote"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAuditRemoteStateReplacesModuleEntry(t *testing.T) {
+	t.Parallel()
+
+	s3State := func(key string) *remote.RemoteState {
+		return &remote.RemoteState{Backend: "s3", Config: map[string]interface{}{"bucket": "state", "key": key}}
+	}
+	localState := &remote.RemoteState{Backend: "local", Config: map[string]interface{}{}}
+
+	type audit struct {
+		stageSubDir string
+		remoteState *remote.RemoteState
+		collides    bool
+	}
+
+	testCases := []struct {
+		name   string
+		audits []audit
+	}{
+		{
+			name: "changed key",
+			audits: []audit{
+				{stageSubDir: "a", remoteState: s3State("shared")},
+				{stageSubDir: "a", remoteState: s3State("a")},
+				{stageSubDir: "b", remoteState: s3State("shared")},
+			},
+		},
+		{
+			name: "changed to local state",
+			audits: []audit{
+				{stageSubDir: "a", remoteState: s3State("shared")},
+				{stageSubDir: "a", remoteState: localState},
+				{stageSubDir: "b", remoteState: s3State("shared")},
+			},
+		},
+		{
+			name: "changed key after a collision",
+			audits: []audit{
+				{stageSubDir: "a", remoteState: s3State("a")},
+				{stageSubDir: "b", remoteState: s3State("a"), collides: true},
+				{stageSubDir: "b", remoteState: s3State("b")},
+				{stageSubDir: "c", remoteState: s3State("a"), collides: true},
+				{stageSubDir: "a", remoteState: s3State("b"), collides: true},
+			},
+		},
+	}
+
+	for _, testCase := range testCases {
+		testCase := testCase
+		t.Run(testCase.name, func(t *testing.T) {
+			t.Parallel()
+
+			stageDir := t.TempDir()
+			staging := func(string) bool { return false }
+			for _, audit := range testCase.audits {
+				require.NoError(t, os.MkdirAll(filepath.Join(stageDir, audit.stageSubDir), 0755))
+				configPath := filepath.Join(audit.stageSubDir, "terragrunt.hcl")
+
+				err := auditRemoteState(stageDir, audit.stageSubDir, configPath, audit.remoteState, staging)
+				if audit.collides {
+					var collision RemoteStateCollision
+					require.ErrorAs(t, err, &collision)
+					assert.Len(t, collision.Modules, 2)
+				} else {
+					require.NoError(t, err)
+				}
+			}
+		})
+	}
+}
@@ -15,9 +15,11 @@ import (
 	"fmt"
 	"io/ioutil"
 	"log"
+	"net/url"
 	"os"
 	"path/filepath"
 	"regexp"
+	"runtime"
 	"strings"
 
 	"github.com/gruntwork-io/go-commons/errors"
@@ -28,6 +30,7 @@ import (
 )
 
 const OPT_TERRAGRUNT_SOURCE = "terragrunt-source"
+const OPT_TERRAGRUNT_SOURCE_MAP = "terragrunt-source-map"
 const OPT_TERRAGRUNT_SOURCE_UPDATE = "terragrunt-source-update"
 
 const CMD_INIT_FROM_MODULE = "init-from-module"
@@ -38,10 +41,76 @@ func main() {
 	stagedir := flag.String("stagedir", ".", "Directory To Stage To")
 	workdir := flag.String("workdir", ".", "Working Directory For Expression")
 	subdirvar := flag.String("subdirvar", "module_path", "Variable For Subdirectory Within Stage Directory")
-	//fullrepo := flag.Bool("fullrepo", false, "Download Full Repo Directory Like Terragrunt Normally Would")
+	fullrepo := flag.Bool("fullrepo", true, "Download Full Repo Directory Like Terragrunt Normally Would, -fullrepo=false Stages Only The Module Directory (After //)")
 	verbose := flag.Bool("verbose", false, "Verbose Outputs")
 	debug := flag.Bool("debug", false, "Debug Outputs")
-	flag.Parse()
+
+	// Stage Every Module Under The Working Directory In One Run, Like Terragrunt's run-all
+	all := flag.Bool("all", false, "Stage Every Module (terragrunt.hcl With A Terraform Source) Under The Working Directory, Downloading Each Source Once")
+	parallelism := flag.Int("parallelism", runtime.NumCPU(), "How Many Modules To Stage At Once With -all")
+
+	// Source Overrides, These Behave Like Terragrunt's --terragrunt-source And --terragrunt-source-map
+	// And Accept The Terragrunt Flag Names As Aliases
+	source := ""
+	sourceMap := keyValueFlag{}
+	flag.StringVar(&source, "source", "", "Override The terraform.source Of The Module Being Staged")
+	flag.StringVar(&source, OPT_TERRAGRUNT_SOURCE, "", "Alias For -source")
+	flag.Var(sourceMap, "source-map", "Rewrite Matching Source URLs To Another Source, Formatted remote=local (Repeatable)")
+	flag.Var(sourceMap, OPT_TERRAGRUNT_SOURCE_MAP, "Alias For -source-map")
+
+	// Cache Control For Stages That Have Already Been Downloaded
+	sourceUpdate := false
+	flag.BoolVar(&sourceUpdate, "source-update", false, "Delete The Stage Subdirectory And Download The Source Again")
+	flag.BoolVar(&sourceUpdate, OPT_TERRAGRUNT_SOURCE_UPDATE, false, "Alias For -source-update")
+	noCache := flag.Bool("no-cache", false, "Always Download The Source Again, Even When The Stage Looks Up To Date")
+	cacheTTL := flag.Duration("cache-ttl", 0, "Download Sources From Branches (Refs That Aren't Commits Or Version Tags) Again Once The Stage Is Older Than This, e.g. 1h (0 Never Expires)")
+	hashContents := flag.Bool("hash-contents", false, "Decide Whether Local Sources Changed By Hashing File Contents Instead Of Modification Times")
+	hashExclude := stringListFlag{}
+	flag.Var(&hashExclude, "hash-exclude", "Glob Pattern Of Files To Leave Out Of The Content Hash Of Local Sources (Repeatable)")
+
+	// Git Options
+	gitCache := flag.String("git-cache", "", "Folder For A Shared Cache Of Bare Git Mirrors, Stages Are Materialised From The Mirrors Instead Of Cloning Each Time")
+	sparse := flag.Bool("sparse", false, "Only Check Out The Module Subdirectory (After //) Of Git Sources And The Directories It References With ../")
+	lockfile := flag.String("lockfile", "", "Lock File Recording The Commit Each Git Source Ref Resolves To (Default <stagedir>/"+DefaultLockFileName+")")
+	locked := flag.Bool("locked", false, "Check Out Exactly The Commits In The Lock File, Failing If A Ref Has Moved Or Isn't Locked")
+	gitSSHKey := flag.String("git-ssh-key", "", "Path Of An SSH Private Key For Git Sources (A Base64 sshkey Query Parameter In The Source Wins)")
+	gitKnownHosts := flag.String("git-known-hosts", "", "Path Of A known_hosts File That SSH Git Remotes Are Strictly Verified Against")
+	gitCredentialHelper := flag.String("git-credential-helper", "", "Git Credential Helper For HTTPS Git Sources Without A TERRASTAGE_GIT_TOKEN_<host> Variable Or .netrc Entry")
+	submodules := flag.Bool("submodules", false, "Fetch Submodules Of Git Sources, Unless The Source Sets ?submodules=true|false")
+	gitBackend := flag.String("git-backend", GitBackendExec, "Git Implementation Git Sources Are Downloaded With: "+GitBackendExec+" (The git Binary) Or "+GitBackendGoGit+" (Built In, No git Binary Needed)")
+
+	// Git Signature Options, Refs That Aren't Signed By A Trusted Key Are Never Staged
+	gitVerifySignatures := flag.String("git-verify-signatures", "", "Only Stage Git Sources Whose Checked Out Commit ("+GitVerifyCommit+") Or Tag ("+GitVerifyTag+") Is Signed By A Key In -git-allowed-signers Or -git-keyring")
+	gitAllowedSigners := flag.String("git-allowed-signers", "", "SSH Allowed Signers File (See ssh-keygen) Of The Keys Trusted To Sign Git Sources With -git-verify-signatures")
+	gitKeyring := flag.String("git-keyring", "", "GPG Public Keyring (gpg --export --armor) Of The Keys Trusted To Sign Git Sources With -git-verify-signatures")
+
+	// Terraform Registry Options, Tokens Come From TF_TOKEN_<host> Or The Terraform CLI Config Like They Do For Terraform
+	registryHosts := keyValueFlag{}
+	flag.Var(registryHosts, "registry-host", "Serve A Terraform Registry Host From Another Base URL, e.g. An Internal Mirror, Formatted host=url (Repeatable)")
+
+	// Retries And Timeouts For Every Source Download
+	downloadTimeout := flag.Duration("download-timeout", 0, "How Long Each Attempt To Download A Source May Take Before It Is Retried, e.g. 5m (0 No Limit)")
+	downloadRetries := flag.Int("download-retries", DefaultDownloadRetries, "How Many Times A Download That Failed With A Network Or Server Error (Or Timed Out) Is Retried")
+	retryBackoff := flag.Duration("retry-backoff", DefaultRetryBackoff, "How Long To Wait Before The First Retry Of A Download, Doubling For Every Retry After That (Up To 1m)")
+
+	// Module Sources Referenced By The Staged Code
+	vendorModules := flag.Bool("vendor-modules", false, "Download The Remote Sources Of Module Blocks In The Staged Code Into A modules Folder In The Stage And Rewrite Them To Relative Paths, Recursively")
+	pinVersions := flag.Bool("pin-versions", false, "Rewrite Terraform Registry Version Constraints In The Staged Code (And The Stage's Own tfr Source) To The Exact Versions They Resolve To")
+
+	// Staging Without A Network, From A Bundle Of Every Source Written By terrastage bundle
+	offline := flag.Bool("offline", false, "Never Download Remote Sources, Take Them From The -bundle Instead (Failing If One Isn't In It)")
+	bundlePath := flag.String("bundle", "", "Source Bundle To Write With terrastage bundle, Or To Stage From With -offline")
+
+	// Settings Committed Alongside The Terragrunt Configs
+	configFile := flag.String("config", "", "Terrastage Config File, With The Checksums Archive Sources Must Match And The Sources That Are Allowed (Default <workdir>/"+DefaultConfigFileName+" When It Exists)")
+
+	// terrastage bundle Downloads Every Source Referenced Under The Working Directory Into A Bundle Instead Of Staging
+	bundleCommand := len(os.Args) > 1 && os.Args[1] == "bundle"
+	if bundleCommand {
+		flag.CommandLine.Parse(os.Args[2:])
+	} else {
+		flag.Parse()
+	}
 
 	// Get Leftover Arguments After Flag Parsing.
 	extraArgs := flag.Args()
@@ -87,37 +156,318 @@ func main() {
 	// Add Trailing Slash To Stage Directory
 	*stagedir = *stagedir + string(os.PathSeparator)
 
-	// Set Config Path
-	configPath := filepath.Join(*workdir, "terragrunt.hcl")
-
-	// Log Working Directory, Stage Directory, and Stage Subdirectory If Output Is Debug
+	// Log Stage Directory And Stage Subdirectory Variable If Output Is Verbose
 	if *verbose || *debug {
-		terragruntOptions.Logger.Infof("Workdir: %s", *workdir)
 		terragruntOptions.Logger.Infof("Stage Dir: %s", *stagedir)
 		terragruntOptions.Logger.Infof("Stage Subdir Variable: %s", *subdirvar)
-		//infoLog.Printf("Workdir: \n%s\n\n", *workdir)
 	}
 
-	// Set Woring Dir To Working Dir
-	terragruntOptions.WorkingDir = *workdir
-
-	// Set Config Path To Config Path
-	terragruntOptions.TerragruntConfigPath = configPath
-
 	// Set Download Dir To Staging Dir
 	terragruntOptions.DownloadDir = *stagedir
 
+	// Set Source Overrides.   Local Paths Are Made Absolute So They Are Relative To Where
+	// Terrastage Was Run Rather Than The Terragrunt Working Directory
+	if source != "" {
+		terragruntOptions.Source = absLocalPath(source)
+	}
+	if len(sourceMap) > 0 {
+		terragruntOptions.SourceMap = map[string]string{}
+		for remoteSource, localSource := range sourceMap {
+			terragruntOptions.SourceMap[remoteSource] = absLocalPath(localSource)
+		}
+	}
+
+	// Wipe And Download Again If Source Update Was Requested
+	terragruntOptions.SourceUpdate = sourceUpdate
+
+	// Set Terrastage Specific Options
+	stageOptions := NewStageOptions()
+	stageOptions.NoCache = *noCache
+	stageOptions.CacheTTL = *cacheTTL
+	stageOptions.HashContents = *hashContents
+	stageOptions.HashExclude = hashExclude
+	stageOptions.Sparse = *sparse
+	stageOptions.Submodules = *submodules
+	stageOptions.GitAuth.CredentialHelper = *gitCredentialHelper
+	if *gitSSHKey != "" {
+		path, err := filepath.Abs(*gitSSHKey)
+		if err != nil {
+			log.Println(err)
+		}
+		stageOptions.GitAuth.SSHKeyFile = path
+	}
+	if *gitKnownHosts != "" {
+		path, err := filepath.Abs(*gitKnownHosts)
+		if err != nil {
+			log.Println(err)
+		}
+		stageOptions.GitAuth.KnownHostsFile = path
+	}
+	if *gitCache != "" {
+		gitCacheDir, err := filepath.Abs(*gitCache)
+		if err != nil {
+			log.Println(err)
+		}
+		stageOptions.GitCacheDir = gitCacheDir
+	}
+
+	// The Built In Git Backend Can't Do What Needs The git Binary
+	switch *gitBackend {
+	case GitBackendExec:
+	case GitBackendGoGit:
+		if *gitCache != "" || *sparse || *gitCredentialHelper != "" {
+			terragruntOptions.Logger.Errorf("The %s Git Backend Doesn't Support -git-cache, -sparse Or -git-credential-helper, Use -git-backend %s", GitBackendGoGit, GitBackendExec)
+			os.Exit(1)
+		}
+	default:
+		terragruntOptions.Logger.Errorf("Unknown Git Backend %q, Use %s Or %s", *gitBackend, GitBackendExec, GitBackendGoGit)
+		os.Exit(1)
+	}
+	stageOptions.GitBackend = *gitBackend
+
+	// Signatures Can Only Be Verified Against Keys That Were Given
+	switch *gitVerifySignatures {
+	case "":
+	case GitVerifyCommit, GitVerifyTag:
+		if *gitAllowedSigners == "" && *gitKeyring == "" {
+			terragruntOptions.Logger.Errorf("-git-verify-signatures Needs The Trusted Keys, Give -git-allowed-signers, -git-keyring Or Both")
+			os.Exit(1)
+		}
+		if *gitBackend == GitBackendGoGit && *gitKeyring == "" {
+			terragruntOptions.Logger.Errorf("The %s Git Backend Can Only Verify GPG Signatures, Give -git-keyring Or Use -git-backend %s", GitBackendGoGit, GitBackendExec)
+			os.Exit(1)
+		}
+	default:
+		terragruntOptions.Logger.Errorf("Unknown Signature Verification %q, Use %s Or %s", *gitVerifySignatures, GitVerifyCommit, GitVerifyTag)
+		os.Exit(1)
+	}
+	stageOptions.GitSignatures.Mode = *gitVerifySignatures
+	if *gitAllowedSigners != "" {
+		path, err := filepath.Abs(*gitAllowedSigners)
+		if err != nil {
+			log.Println(err)
+		}
+		stageOptions.GitSignatures.AllowedSignersFile = path
+	}
+	if *gitKeyring != "" {
+		path, err := filepath.Abs(*gitKeyring)
+		if err != nil {
+			log.Println(err)
+		}
+		stageOptions.GitSignatures.KeyringFile = path
+	}
+
+	if *downloadRetries < 0 || *downloadTimeout < 0 || *retryBackoff < 0 {
+		terragruntOptions.Logger.Errorf("-download-retries, -download-timeout And -retry-backoff Can't Be Negative")
+		os.Exit(1)
+	}
+	stageOptions.DownloadTimeout = *downloadTimeout
+	stageOptions.DownloadRetries = *downloadRetries
+	stageOptions.RetryBackoff = *retryBackoff
+	stageOptions.VendorModules = *vendorModules
+	stageOptions.PinVersions = *pinVersions
+	stageOptions.FullRepo = *fullrepo
+
+	// Registry Overrides Need A Full http(s) Base URL
+	for host, baseURL := range registryHosts {
+		parsedURL, err := url.Parse(baseURL)
+		if err != nil || (parsedURL.Scheme != "https" && parsedURL.Scheme != "http") || parsedURL.Host == "" {
+			terragruntOptions.Logger.Errorf("-registry-host %s Needs An http(s) Base URL, Not %q", host, baseURL)
+			os.Exit(1)
+		}
+	}
+	stageOptions.RegistryHosts = registryHosts
+
+	// Load The Terrastage Config, Which Lives In The Working Directory Unless Given
+	configPath := filepath.Join(*workdir, DefaultConfigFileName)
+	if *configFile != "" {
+		path, err := filepath.Abs(*configFile)
+		if err != nil {
+			log.Println(err)
+		}
+		configPath = path
+	}
+	if *configFile != "" || util.FileExists(configPath) {
+		terrastageConfig, err := loadTerrastageConfig(configPath)
+		if err != nil {
+			terragruntOptions.Logger.Errorf("Load Terrastage Config Had The Following Errors: %s", err)
+			os.Exit(1)
+		}
+		stageOptions.Checksums, err = terrastageConfig.sourceChecksums(*workdir)
+		if err != nil {
+			terragruntOptions.Logger.Errorf("Load Terrastage Config Had The Following Errors: %s", err)
+			os.Exit(1)
+		}
+		stageOptions.AllowedSources = terrastageConfig.AllowedSources
+		stageOptions.AllowedModuleSources = terrastageConfig.AllowedModuleSources
+	}
+
+	// Load The Lock File, Which Lives In The Root Of The Stage Directory Unless Given
+	lockFilePath := filepath.Join(*stagedir, DefaultLockFileName)
+	if *lockfile != "" {
+		path, err := filepath.Abs(*lockfile)
+		if err != nil {
+			log.Println(err)
+		}
+		lockFilePath = path
+	}
+	lockFile, err := loadLockFile(lockFilePath)
+	if err != nil {
+		terragruntOptions.Logger.Errorf("Load Lock File Had The Following Errors: %s", err)
+		os.Exit(1)
+	}
+	stageOptions.Lock = lockFile
+	stageOptions.Locked = *locked
+
 	// Parse Environment Variables And Add To Terragrunt Options
 	terragruntOptions.Env = parseEnvironmentVariables(os.Environ())
 
+	// Write The Bundle Of Every Source Referenced Under The Working Directory, Instead Of Staging
+	if bundleCommand {
+		if *bundlePath == "" || *offline {
+			terragruntOptions.Logger.Errorf("terrastage bundle Needs -bundle, The Path To Write The Bundle To, And Can't Be Run -offline")
+			os.Exit(1)
+		}
+		path, err := filepath.Abs(*bundlePath)
+		if err != nil {
+			log.Println(err)
+		}
+		if err := createBundle(terragruntOptions, stageOptions, *workdir, *stagedir, path); err != nil {
+			terragruntOptions.Logger.Errorf("Create Bundle Had The Following Errors: %s", err)
+			os.Exit(1)
+		}
+		if err := lockFile.Save(); err != nil {
+			terragruntOptions.Logger.Errorf("Save Lock File Had The Following Errors: %s", err)
+		}
+		return
+	}
+
+	// Take Every Remote Source From The Bundle When Offline, The Bundle Is Unpacked Into The Stage Directory For The Run
+	if *offline != (*bundlePath != "") {
+		terragruntOptions.Logger.Errorf("-offline And -bundle Go Together, Staging Offline Needs A Bundle And A Bundle Is Only Read Offline")
+		os.Exit(1)
+	}
+	if *offline {
+		path, err := filepath.Abs(*bundlePath)
+		if err != nil {
+			log.Println(err)
+		}
+		bundle, err := openSourceBundle(path, *stagedir)
+		if err != nil {
+			terragruntOptions.Logger.Errorf("Open Bundle Had The Following Errors: %s", err)
+			os.Exit(1)
+		}
+		stageOptions.Bundle = bundle
+	}
+
+	// Settings Shared By Every Module Staged In This Run
+	run := &stageRun{
+		stageDir:     *stagedir,
+		subdirVar:    *subdirvar,
+		verbose:      *verbose || *debug,
+		locked:       *locked,
+		all:          *all,
+		stageOptions: stageOptions,
+		stageSubDirs: map[string]string{},
+	}
+
+	// Remove The Unpacked Bundle Before Exiting
+	exit := func(code int) {
+		if stageOptions.Bundle != nil {
+			if err := stageOptions.Bundle.Close(); err != nil {
+				terragruntOptions.Logger.Warnf("Could Not Remove The Unpacked Bundle: %s", err)
+			}
+		}
+		os.Exit(code)
+	}
+
+	// Stage Just The Module In The Working Directory Unless -all Is Given
+	if !*all {
+		if err := run.stageModule(terragruntOptions, *workdir); err != nil {
+			exit(1)
+		}
+		exit(0)
+	}
+
+	// Stage Every Module Under The Working Directory, Downloading Each Source Once And Sharing It Between Them
+	if *parallelism < 1 {
+		terragruntOptions.Logger.Errorf("-parallelism Must Be At Least 1")
+		exit(1)
+	}
+	modules, err := findTerragruntModules(*workdir, *stagedir)
+	if err != nil {
+		terragruntOptions.Logger.Errorf("Find Terragrunt Modules Had The Following Errors: %s", err)
+		exit(1)
+	}
+	stageOptions.Downloads = NewDownloadCoordinator(*stagedir)
+	failed := run.stageModules(terragruntOptions, modules, *parallelism)
+	if err := stageOptions.Downloads.Close(); err != nil {
+		terragruntOptions.Logger.Warnf("Could Not Remove The Shared Downloads: %s", err)
+	}
+	if len(failed) > 0 {
+		terragruntOptions.Logger.Errorf("Staging Failed For %d Of %d Modules: %s", len(failed), len(modules), strings.Join(failed, ", "))
+		exit(1)
+	}
+	exit(0)
+}
+
+// Stage The Module In The Given Working Directory: Read Its Terragrunt Config, Download Its Source Into Its
+// Stage Subdirectory And Generate Files There Like Terragrunt Would.   Most Problems Are Logged And Staging
+// Carries On, Errors Are Only Returned For Problems That Must Fail The Run (After Logging Them).
+func (run *stageRun) stageModule(baseOptions *options.TerragruntOptions, workdir string) error {
+	// Set Config Path
+	configPath := filepath.Join(workdir, "terragrunt.hcl")
+
+	// Modules Staged Together Each Get A Clone Of The Options Shared By The Run, And Are Told Apart In The Logs By
+	// Their Working Directory
+	terragruntOptions := baseOptions
+	if run.all {
+		terragruntOptions = baseOptions.Clone(configPath)
+		terragruntOptions.Logger = baseOptions.Logger.WithField("prefix", workdir)
+	}
+
+	// Log Working Directory If Output Is Verbose
+	if run.verbose {
+		terragruntOptions.Logger.Infof("Workdir: %s", workdir)
+	}
+
+	// Set Woring Dir To Working Dir
+	terragruntOptions.WorkingDir = workdir
+
+	// Set Config Path To Config Path
+	terragruntOptions.TerragruntConfigPath = configPath
+
+	// Set Original Config Path, Terragrunt Uses This When Reporting Source Map Errors
+	terragruntOptions.OriginalTerragruntConfigPath = configPath
+	stageOptions := run.stageOptions
+
 	// Read Terragrunt Config File
 	terragruntConfig, err := config.ReadTerragruntConfig(terragruntOptions)
 	if err != nil {
 		terragruntOptions.Logger.Errorf("Read Terragrunt Config Had The Following Errors: %s", err)
 	}
 
+	// Staging Everything Under The Working Directory Picks Up Configs Without A Source, Like The Root Config Modules
+	// Include, There Is Nothing To Stage For Those.   A -source Override Is The Base Of Every Module's Source, Joined
+	// With The Module's Subdirectory (After //) Like Terragrunt's run-all Does.
+	if run.all {
+		if terragruntConfig == nil || terragruntConfig.Terraform == nil || terragruntConfig.Terraform.Source == nil {
+			terragruntOptions.Logger.Debugf("Skipping %s, It Has No Terraform Source", configPath)
+			return nil
+		}
+		if baseOptions.Source != "" {
+			moduleSource, err := config.GetTerragruntSourceForModule(baseOptions.Source, workdir, terragruntConfig)
+			if err != nil {
+				terragruntOptions.Logger.Errorf("Get Source For Module Had The Following Errors: %s", err)
+				return err
+			}
+			terragruntOptions.Source = moduleSource
+		}
+	}
+
 	// See If Source URL Is Included In Terragrunt Config, If So Process That Source
 	updatedTerragruntOptions := terragruntOptions
+	stageDownloadDir := ""
 	sourceUrl, err := config.GetTerraformSourceUrl(terragruntOptions, terragruntConfig)
 	if err != nil {
 		terragruntOptions.Logger.Errorf("Get Source URL Had The Following Errors: %s", err)
@@ -131,25 +481,46 @@ func main() {
 		// To The Include.   Other Strategies Are Possible, And Using A Variable From Terragrunt Inputs
 		// Makes This Extremely Flexible
 		stageSubDir := ""
-		if terragruntConfig.Inputs[*subdirvar] != nil {
-			stageSubDir = terragruntConfig.Inputs[*subdirvar].(string)
+		if terragruntConfig.Inputs[run.subdirVar] != nil {
+			stageSubDir = terragruntConfig.Inputs[run.subdirVar].(string)
 		}
 
 		// Log Stage Subdir If Output Is Verbose
-		if *verbose || *debug {
+		if run.verbose {
 			terragruntOptions.Logger.Infof("Stage Subdir From Variable: %s", stageSubDir)
 		}
 
-		// Unless We Indicate To Download Full Repo, Download Just Single Module Folder
-		//if !*fullrepo {
-		//Get rid of double / in source so it only downloads source directory and not full repo
-		//	sourceUrl = strings.ReplaceAll(sourceUrl, "//", "/")
-		//}
+		// Two Modules Staged Together Into The Same Stage Subdirectory Would Overwrite Each Other
+		if err := run.claimStageSubDir(stageSubDir, configPath); err != nil {
+			terragruntOptions.Logger.Errorf("%s", err)
+			return err
+		}
+
+		// Make Sure No Other Module Staged Into This Stage Directory Shares Remote State With This One.
+		// Copy/Paste In Terragrunt Configs Can Leave Two Modules With The Same Key, So Fail Before Staging.
+		if terragruntConfig.RemoteState != nil {
+			if err := auditRemoteState(run.stageDir, stageSubDir, configPath, terragruntConfig.RemoteState, run.staging); err != nil {
+				terragruntOptions.Logger.Errorf("Remote State Audit Had The Following Errors: %s", err)
+				return err
+			}
+		}
 
 		// Download Using Custom Download Function
-		updatedTerragruntOptions, err = customDownloadTerraformSource(sourceUrl, stageSubDir, terragruntOptions, terragruntConfig)
+		stageDownloadDir = util.JoinPath(run.stageDir, stageSubDir)
+		updatedTerragruntOptions, err = customDownloadTerraformSource(sourceUrl, stageSubDir, terragruntOptions, stageOptions, terragruntConfig)
 		if err != nil {
 			terragruntOptions.Logger.Errorf("Download Terraform Source Had The Following Errors: %s", err)
+
+			// Locked Runs Exist To Reproduce A Known Stage, And Offline Runs Can't Get A Source That Isn't In The
+			// Bundle, So Never Carry On With Something Else.   Neither Does A Source The Allowlist Refuses.
+			if _, notAllowed := errors.Unwrap(err).(SourceNotAllowed); notAllowed || run.locked || stageOptions.Bundle != nil {
+				return err
+			}
+		}
+
+		// Record The Commits Resolved For Git Sources
+		if err := stageOptions.Lock.Save(); err != nil {
+			terragruntOptions.Logger.Errorf("Save Lock File Had The Following Errors: %s", err)
 		}
 
 	}
@@ -158,17 +529,22 @@ func main() {
 	// Need To Look Into Reason In Code, This Is a Quick Fix
 	updatedTerragruntOptions.Logger = terragruntOptions.Logger
 
+	// Keep Track Of Every File Generated Into The Stage
+	generatedFiles := []string{}
+
 	// Handle code generation configs, both generate blocks and generate attribute of remote_state.
 	// Note that relative paths are relative to the terragrunt working dir (where terraform is called).
 	for _, config := range terragruntConfig.GenerateConfigs {
 		if err := codegen.WriteToFile(updatedTerragruntOptions, updatedTerragruntOptions.WorkingDir, config); err != nil {
 			terragruntOptions.Logger.Errorf("Generate Configs Had The Following Errors: %s", err)
 		}
+		generatedFiles = append(generatedFiles, generatedPath(updatedTerragruntOptions.WorkingDir, config.Path))
 	}
 	if terragruntConfig.RemoteState != nil && terragruntConfig.RemoteState.Generate != nil {
 		if err := terragruntConfig.RemoteState.GenerateTerraformCode(updatedTerragruntOptions); err != nil {
 			terragruntOptions.Logger.Errorf("Generate Terraform Code Had The Following Errors: %s", err)
 		}
+		generatedFiles = append(generatedFiles, generatedPath(updatedTerragruntOptions.WorkingDir, terragruntConfig.RemoteState.Generate.Path))
 	}
 
 	// If Terragrunt Remote State Options Are Set, Use These To Generate A Backend.Config File In The Stage Directory
@@ -200,9 +576,79 @@ func main() {
 		if err := ioutil.WriteFile(fileName, backendConfigContents, os.FileMode(int(0600))); err != nil {
 			terragruntOptions.Logger.Errorf("Write backend.config Had The Following Errors: %s", err)
 		}
+		generatedFiles = append(generatedFiles, fileName)
 
 	}
 
+	// Files Terragrunt Copied From The Terragrunt Working Directory Into The Stage
+	copiedFiles := []string{}
+	stageHasMetadata := stageDownloadDir != "" && util.FileExists(filepath.Join(stageDownloadDir, StageMetadataFile))
+	if stageHasMetadata {
+		copiedFiles, err = readModuleManifest(updatedTerragruntOptions.WorkingDir)
+		if err != nil {
+			terragruntOptions.Logger.Warnf("Could Not Read The Module Manifest: %s", err)
+		}
+	}
+
+	// Make The Module Sources Of The Staged Code Resolve Inside The Stage: Copy In The Folders Relative Sources
+	// Reference From Outside Of It, And Vendor Remote Sources If Asked To, So Terraform Doesn't Download Them At Init
+	if stageHasMetadata {
+		rewritten, err := previousRewrittenFiles(stageDownloadDir)
+		if err != nil {
+			terragruntOptions.Logger.Warnf("Could Not Read The Rewritten Files Of The Last Stage: %s", err)
+			rewritten = map[string]bool{}
+		}
+
+		sourceRoot := localSourceRoot(sourceUrl, terragruntOptions.WorkingDir, !stageOptions.FullRepo)
+		unresolved, err := fixRelativeModuleSources(terragruntOptions.Logger, updatedTerragruntOptions.WorkingDir, stageDownloadDir, sourceRoot, terragruntOptions.WorkingDir, copiedFiles, rewritten)
+		if err != nil {
+			terragruntOptions.Logger.Errorf("Fix Relative Module Sources Had The Following Errors: %s", err)
+		}
+		for _, reference := range unresolved {
+			terragruntOptions.Logger.Warnf("Relative module source points outside of the stage and can't be copied in: %s", reference)
+		}
+		if len(unresolved) > 0 && !stageOptions.FullRepo && sourceRoot == "" {
+			terragruntOptions.Logger.Warnf("Only The Module Directory Was Staged (-fullrepo=false), Which Is Unsafe For This Module. Stage The Full Repo So Its Relative Module Sources Resolve.")
+		}
+
+		if stageOptions.PinVersions {
+			if err := pinModuleVersions(updatedTerragruntOptions, stageOptions, stageDownloadDir, rewritten); err != nil {
+				terragruntOptions.Logger.Errorf("Pin Module Versions Had The Following Errors: %s", err)
+				if run.locked {
+					return err
+				}
+			}
+			if err := pinStageSource(updatedTerragruntOptions, stageDownloadDir, sourceUrl, rewritten); err != nil {
+				terragruntOptions.Logger.Errorf("Pin Stage Source Had The Following Errors: %s", err)
+			}
+
+			// Record The Versions Resolved For Registry Constraints
+			if err := stageOptions.Lock.Save(); err != nil {
+				terragruntOptions.Logger.Errorf("Save Lock File Had The Following Errors: %s", err)
+			}
+		}
+
+		if stageOptions.VendorModules {
+			if err := vendorModules(updatedTerragruntOptions, stageOptions, terragruntConfig, stageDownloadDir, rewritten); err != nil {
+				terragruntOptions.Logger.Errorf("Vendor Modules Had The Following Errors: %s", err)
+				if _, notAllowed := errors.Unwrap(err).(SourceNotAllowed); notAllowed || run.locked {
+					return err
+				}
+			}
+
+			// Record The Commits Resolved For Vendored Git Sources
+			if err := stageOptions.Lock.Save(); err != nil {
+				terragruntOptions.Logger.Errorf("Save Lock File Had The Following Errors: %s", err)
+			}
+		}
+
+		rewrittenFiles, err := recordRewrittenFiles(stageDownloadDir, rewritten)
+		if err != nil {
+			terragruntOptions.Logger.Errorf("Record Rewritten Files Had The Following Errors: %s", err)
+		}
+		generatedFiles = append(generatedFiles, rewrittenFiles...)
+	}
+
 	// Write TFVARs File To The Staging Directory.
 	// This Uses The Function That Terragrunt Debug Uses, The Log Messages
 	// Are Updated To Indicate This Is A Stage And Not A Debug.
@@ -210,6 +656,24 @@ func main() {
 	if err != nil {
 		terragruntOptions.Logger.Errorf("Write TFVARS Had The Following Errors: %s", err)
 	}
+	generatedFiles = append(generatedFiles, filepath.Join(updatedTerragruntOptions.WorkingDir, TerragruntTFVarsFile))
+
+	// Record Generated Files, Including Those Copied From The Terragrunt Working Directory, In The Stage Metadata.
+	// A Git Stage Can Then Be Updated Later Without Mistaking Them For Local Changes.
+	if stageHasMetadata {
+		if err := recordGeneratedFiles(stageDownloadDir, append(generatedFiles, copiedFiles...)); err != nil {
+			terragruntOptions.Logger.Errorf("Record Generated Files Had The Following Errors: %s", err)
+		}
+	}
+	return nil
+}
+
+// Return The Path Of A Generated File, Which Is Relative To The Working Directory Unless It Is Absolute
+func generatedPath(workingDir string, path string) string {
+	if filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(workingDir, path)
 }
 
 // Got This Function From Terragrunt Options. It Was Not Exported So Added To This Package
@@ -227,6 +691,50 @@ func parseEnvironmentVariables(environment []string) map[string]string {
 	return environmentMap
 }
 
+// Repeatable key=value Command Line Flag
+type keyValueFlag map[string]string
+
+func (f keyValueFlag) String() string {
+	pairs := []string{}
+	for key, value := range f {
+		pairs = append(pairs, key+"="+value)
+	}
+	return strings.Join(pairs, ",")
+}
+
+func (f keyValueFlag) Set(value string) error {
+	pair := strings.SplitN(value, "=", 2)
+	if len(pair) != 2 || pair[0] == "" || pair[1] == "" {
+		return fmt.Errorf("expected key=value but got %q", value)
+	}
+	f[pair[0]] = pair[1]
+	return nil
+}
+
+// Repeatable Command Line Flag That Collects Every Value
+type stringListFlag []string
+
+func (f *stringListFlag) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *stringListFlag) Set(value string) error {
+	*f = append(*f, value)
+	return nil
+}
+
+// Return The Absolute Path For A Relative Local Path That Exists, Otherwise Return The Source Unchanged
+func absLocalPath(source string) string {
+	if filepath.IsAbs(source) || !util.IsDir(source) {
+		return source
+	}
+	path, err := filepath.Abs(source)
+	if err != nil {
+		return source
+	}
+	return path
+}
+
 //  Had To Grab a Function From The Terragrunt Remote Package That Wasn't Exported.
 type BackendNotDefined struct {
 	Opts        *options.TerragruntOptions
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/gruntwork-io/go-commons/errors"
+	"github.com/hashicorp/hcl/v2/gohcl"
+	"github.com/hashicorp/hcl/v2/hclparse"
+)
+
+// Name Of The Terrastage Config File Read From The Working Directory When -config Isn't Given
+const DefaultConfigFileName = ".terrastage.hcl"
+
+// Names Of The Terrastage Config Blocks Holding The Source Allowlists, Which Errors Refer To
+const (
+	AllowedSourcesBlock       = "allowed_sources"
+	AllowedModuleSourcesBlock = "allowed_module_sources"
+)
+
+// Settings That Are Committed Alongside The Terragrunt Configs Rather Than Given On The Command Line
+type TerrastageConfig struct {
+	// Checksums Archive Sources Must Match, Keyed By Source URL (Without A checksum Query Parameter), e.g.
+	// "https::https://artifacts.example.com/vpc-1.2.0.tar.gz" = "sha256:<hex>"
+	Checksums map[string]string `hcl:"checksums,optional"`
+
+	// Which Sources terraform.source May Point At.   Every Source Is Allowed When It Is Left Out.
+	AllowedSources *SourcePolicy `hcl:"allowed_sources,block"`
+
+	// Which Sources Module Blocks In The Staged Code May Point At When They Are Vendored (-vendor-modules Or
+	// terrastage bundle).   Every Source Is Allowed When It Is Left Out.
+	AllowedModuleSources *SourcePolicy `hcl:"allowed_module_sources,block"`
+}
+
+// Read A Terrastage Config File
+func loadTerrastageConfig(path string) (*TerrastageConfig, error) {
+	file, diags := hclparse.NewParser().ParseHCLFile(path)
+	if diags.HasErrors() {
+		return nil, errors.WithStackTrace(fmt.Errorf("could not parse terrastage config %s: %w", path, diags))
+	}
+	terrastageConfig := &TerrastageConfig{}
+	if diags := gohcl.DecodeBody(file.Body, nil, terrastageConfig); diags.HasErrors() {
+		return nil, errors.WithStackTrace(fmt.Errorf("could not parse terrastage config %s: %w", path, diags))
+	}
+	if terrastageConfig.AllowedSources != nil {
+		if err := terrastageConfig.AllowedSources.validate(AllowedSourcesBlock); err != nil {
+			return nil, err
+		}
+	}
+	if terrastageConfig.AllowedModuleSources != nil {
+		if err := terrastageConfig.AllowedModuleSources.validate(AllowedModuleSourcesBlock); err != nil {
+			return nil, err
+		}
+	}
+	return terrastageConfig, nil
+}
+
+// The Checksums Of The Config Keyed The Way Sources Are Looked Up, With Sources Parsed Relative To The Working
+// Directory.   Every Entry Must Be An Archive Source With A Supported Checksum.
+func (terrastageConfig *TerrastageConfig) sourceChecksums(workingDir string) (map[string]string, error) {
+	checksums := map[string]string{}
+	for source, checksum := range terrastageConfig.Checksums {
+		sourceURL, err := ToSourceUrl(source, workingDir)
+		if err != nil {
+			return nil, err
+		}
+		if !isArchiveSource(sourceURL) {
+			return nil, errors.WithStackTrace(ChecksumNotSupported{Source: sourceURL.Redacted()})
+		}
+		if !checksumRegexp.MatchString(checksum) {
+			return nil, errors.WithStackTrace(InvalidChecksum{Source: sourceURL.Redacted(), Checksum: checksum})
+		}
+		checksums[checksumKey(sourceURL)] = checksum
+	}
+	return checksums, nil
+}